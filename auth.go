@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// authorization returns the value of the Authorization header for
+// line, which is taken from its "auth" field or the -basic and -bearer
+// flags. An empty string is returned, if no credentials are given.
+func authorization(line httpline) (string, error) {
+	auth := line.Auth
+	if auth == "" && basicFlag != "" {
+		auth = "basic " + basicFlag
+	} else if auth == "" && bearerFlag != "" {
+		auth = "bearer " + bearerFlag
+	}
+	if auth == "" {
+		return "", nil
+	}
+	scheme, credentials, _ := strings.Cut(auth, " ")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if !strings.Contains(credentials, ":") {
+			return "", fmt.Errorf("invalid basic credentials '%s'", credentials)
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials)), nil
+	case "bearer":
+		if credentials == "" {
+			return "", fmt.Errorf("missing bearer token")
+		}
+		return "Bearer " + credentials, nil
+	}
+	return "", fmt.Errorf("invalid auth '%s'", auth)
+}
+
+// injectAuth adds the Authorization header for line to its request, if
+// the request has none yet. Invalid credentials are reported by
+// toClientRequest.
+func injectAuth(line *httpline) {
+	value, err := authorization(*line)
+	if err != nil || value == "" || len(headerValues(line.Req, "Authorization")) > 0 {
+		return
+	}
+	line.Req = withHeader(line.Req, "Authorization", value)
+}
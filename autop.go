@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// concurrency limits the number of requests in flight. Its limit can be
+// changed at runtime; see control.go.
+var concurrency *limiter
+
+// limiter limits the number of concurrent requests. If it is adaptive,
+// the limit is adjusted AIMD-style: It grows by one after a limit's
+// worth of requests without timeouts and is halved after a timeout. A
+// nil *limiter never limits requests.
+type limiter struct {
+	mu            sync.Mutex
+	limit         float64
+	max           int
+	adaptive      bool
+	active        int
+	sinceDecrease int
+	changed       chan struct{} // Closed whenever active or limit change.
+}
+
+// newLimiter returns an adaptive limiter, which starts with a limit of
+// one.
+func newLimiter(max int) *limiter {
+	return &limiter{limit: 1, max: max, adaptive: true, changed: make(chan struct{})}
+}
+
+// newFixedLimiter returns a limiter, which only changes its limit when
+// set is called.
+func newFixedLimiter(limit int) *limiter {
+	return &limiter{limit: float64(limit), max: limit, changed: make(chan struct{})}
+}
+
+// acquire waits until another request may be made. It returns false if
+// ctx is done before.
+func (l *limiter) acquire(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+	for {
+		l.mu.Lock()
+		if l.active < int(l.limit) {
+			l.active++
+			l.mu.Unlock()
+			return true
+		}
+		changed := l.changed
+		l.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// release frees the slot of a request acquired before and adjusts the
+// limit according to the results of the request. If no request was
+// made, results is empty.
+func (l *limiter) release(results ...httpline) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	for _, result := range results {
+		if !l.adaptive {
+			break
+		}
+		l.sinceDecrease++
+		if isTimeoutErrno(result.Errno) {
+			// Decrease only once for the requests, that were already in
+			// flight when the first of them timed out.
+			if l.sinceDecrease >= int(l.limit) {
+				l.limit = max(1, l.limit/2)
+				l.sinceDecrease = 0
+			}
+		} else if result.Errno != breakerErrno {
+			l.limit = min(float64(l.max), l.limit+1/l.limit)
+		}
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// set changes the maximum limit to max. The limit of a limiter, that is
+// not adaptive, is set to max as well.
+func (l *limiter) set(max int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.max = max
+	if !l.adaptive || l.limit > float64(max) {
+		l.limit = float64(max)
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// maximum returns the maximum limit.
+func (l *limiter) maximum() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.max
+}
+
+// current returns the current limit.
+func (l *limiter) current() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+func isTimeoutErrno(errno int) bool {
+	switch errno {
+	case 11, 21, 31, 32, 33, 34:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// buffered limits the size of buffered responses if
+// -max-buffered-bytes is given.
+var buffered *byteBudget
+
+// byteBudget keeps track of the size of the responses, that have been
+// received but not written yet. Workers wait before making new requests
+// while the size exceeds the maximum. A nil *byteBudget never blocks.
+type byteBudget struct {
+	mu      sync.Mutex
+	max     int64
+	used    int64
+	changed chan struct{} // Closed whenever used decreases.
+}
+
+func newByteBudget(max int64) *byteBudget {
+	return &byteBudget{max: max, changed: make(chan struct{})}
+}
+
+// wait waits until the size of the buffered responses is below the
+// maximum. It returns false if ctx is done before.
+func (b *byteBudget) wait(ctx context.Context) bool {
+	if b == nil {
+		return true
+	}
+	for {
+		b.mu.Lock()
+		if b.used < b.max {
+			b.mu.Unlock()
+			return true
+		}
+		changed := b.changed
+		b.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// add adds the sizes of the responses of results to the buffered size.
+func (b *byteBudget) add(results ...httpline) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, result := range results {
+		b.used += responseSize(result)
+	}
+}
+
+// release subtracts size from the buffered size, after a response of
+// that size was written.
+func (b *byteBudget) release(size int64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= size
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// responseSize returns the number of bytes of the response data held by
+// result.
+func responseSize(result httpline) int64 {
+	size := len(result.Resp) + len(result.RawResp)
+	for _, entry := range result.transcript {
+		size += len(entry.Data)
+	}
+	if result.FollowUp != nil {
+		size += len(result.FollowUp.Resp)
+	}
+	return int64(size)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// baselineIgnoredHeaders are not compared with the baseline, because
+// they change with every response.
+var baselineIgnoredHeaders = map[string]bool{"date": true, "age": true}
+
+// baselineDiff describes how the response of a line differs from the
+// response of the corresponding line of the baseline.
+type baselineDiff struct {
+	Changed bool     `json:"changed"`
+	New     bool     `json:"new,omitempty"`
+	Status  bool     `json:"status,omitempty"`
+	Headers []string `json:"headers,omitempty"`
+	Body    bool     `json:"body,omitempty"`
+}
+
+// baselineResp is the part of a response, that is compared.
+type baselineResp struct {
+	status   int
+	headers  map[string][]string
+	bodyHash [sha256.Size]byte
+}
+
+// baselineSet holds the responses of a previous run, given with
+// -baseline. A nil *baselineSet compares nothing.
+type baselineSet struct {
+	resps                   map[string]baselineResp
+	seen                    map[string]bool
+	changed, new, unchanged int
+}
+
+// baseline is nil, if -baseline is not given.
+var baseline *baselineSet
+
+// loadBaseline reads the output of a previous run of preq from the file
+// at path, which may be compressed.
+func loadBaseline(path string) (*baselineSet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := decompress(f, "auto")
+	if err != nil {
+		return nil, err
+	}
+	b := &baselineSet{resps: make(map[string]baselineResp), seen: make(map[string]bool)}
+	dec := json.NewDecoder(r)
+	for {
+		var line httpline
+		if err := dec.Decode(&line); errors.Is(err, io.EOF) {
+			return b, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if line.Reqat == nil && line.Err == "" {
+			continue // Passed through without request.
+		}
+		key := baselineKey(line)
+		if _, found := b.resps[key]; found {
+			continue
+		}
+		resp, err := storedResp(&line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line.N, err)
+		}
+		b.resps[key] = toBaselineResp(resp)
+	}
+}
+
+// storedResp returns the response stored in line, which may be
+// compressed or written to a file with -resp-dir.
+func storedResp(line *httpline) (string, error) {
+	if err := decompressResp(line); err != nil {
+		return "", err
+	}
+	if line.Resp == "" && line.RespFile != "" {
+		resp, err := os.ReadFile(line.RespFile)
+		return string(resp), err
+	}
+	return line.Resp, nil
+}
+
+// baselineKey identifies line across runs: by its "id" field, if
+// present, or else by its request as normalized by dedupeKey, which
+// includes the Host header, so that virtual hosts on the same address
+// are told apart. The request from the input is used, which is kept in
+// "origreq", if cookies, credentials or fixes were added, since these
+// change between runs. The address of -fan-out and the attempt of
+// -repeat distinguish lines with the same request.
+func baselineKey(line httpline) string {
+	line.SetDefaults()
+	if line.OrigReq != "" {
+		line.Req = line.OrigReq
+	}
+	key := "req:" + dedupeKey(line)
+	if len(line.ID) > 0 {
+		key = "id:" + string(line.ID)
+	}
+	return key + "\n" + line.Addr + "\n" + strconv.Itoa(line.Attempt)
+}
+
+func toBaselineResp(resp string) baselineResp {
+	b := baselineResp{
+		headers:  make(map[string][]string),
+		bodyHash: sha256.Sum256([]byte(responseBody(resp))),
+	}
+	b.status, _ = statusAndReason(resp)
+	if resp == "" {
+		return b
+	}
+	for _, line := range strings.Split(resp, "\n")[1:] {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			break
+		}
+		name, value, found := strings.Cut(line, ":")
+		if name = strings.ToLower(strings.TrimSpace(name)); found && !baselineIgnoredHeaders[name] {
+			b.headers[name] = append(b.headers[name], strings.TrimSpace(value))
+		}
+	}
+	return b
+}
+
+// compare returns how the response of result differs from the baseline.
+func (b *baselineSet) compare(result httpline) *baselineDiff {
+	if b == nil {
+		return nil
+	}
+	key := baselineKey(result)
+	old, found := b.resps[key]
+	if !found {
+		b.new++
+		return &baselineDiff{Changed: true, New: true}
+	}
+	b.seen[key] = true
+	resp := toBaselineResp(result.Resp)
+	diff := &baselineDiff{
+		Status: resp.status != old.status,
+		Body:   resp.bodyHash != old.bodyHash,
+	}
+	for name, values := range resp.headers {
+		if !slices.Equal(values, old.headers[name]) {
+			diff.Headers = append(diff.Headers, name)
+		}
+	}
+	for name := range old.headers {
+		if _, found := resp.headers[name]; !found {
+			diff.Headers = append(diff.Headers, name)
+		}
+	}
+	slices.Sort(diff.Headers)
+	diff.Changed = diff.Status || diff.Body || len(diff.Headers) > 0
+	if diff.Changed {
+		b.changed++
+	} else {
+		b.unchanged++
+	}
+	return diff
+}
+
+// report writes a summary of the comparison to standard error.
+func (b *baselineSet) report() {
+	if b != nil {
+		missing := len(b.resps) - len(b.seen)
+		fmt.Fprintf(os.Stderr, "Compared with baseline: %d changed, %d unchanged, %d new, %d missing.\n",
+			b.changed, b.unchanged, b.new, missing)
+	}
+}
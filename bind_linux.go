@@ -0,0 +1,18 @@
+package main
+
+import "syscall"
+
+// bindToInterface returns a net.Dialer.Control function, which binds
+// sockets to the network interface iface.
+func bindToInterface(iface string) (func(network, address string, c syscall.RawConn) error, error) {
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		controlErr := c.Control(func(fd uintptr) {
+			err = syscall.BindToDevice(int(fd), iface)
+		})
+		if controlErr != nil {
+			return controlErr
+		}
+		return err
+	}, nil
+}
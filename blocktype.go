@@ -0,0 +1,70 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// blockSignature identifies a response that does not contain real
+// content, but e.g. a WAF block page or a challenge.
+type blockSignature struct {
+	blockType string
+
+	// statuses lists the status codes the signature applies to. If empty,
+	// all status codes match.
+	statuses []int
+
+	// header and headerValue, if set, must be found in the response head.
+	// The header name must be given in lower case. An empty headerValue
+	// matches any value.
+	header      string
+	headerValue string
+
+	// bodyMarker, if set, must be found in the response body.
+	bodyMarker string
+}
+
+var blockSignatures = []blockSignature{
+	{blockType: "captive-portal", statuses: []int{511}},
+	{blockType: "challenge:cloudflare", header: "cf-mitigated", headerValue: "challenge"},
+	{blockType: "challenge:cloudflare", statuses: []int{403, 503}, bodyMarker: "<title>Just a moment...</title>"},
+	{blockType: "challenge:ddos-guard", statuses: []int{403}, header: "server", headerValue: "ddos-guard"},
+	{blockType: "waf:cloudflare", statuses: []int{403}, bodyMarker: "Attention Required! | Cloudflare"},
+	{blockType: "waf:akamai", statuses: []int{403}, header: "server", headerValue: "AkamaiGHost"},
+	{blockType: "waf:aws", statuses: []int{403}, header: "server", headerValue: "awselb"},
+	{blockType: "waf:aws", statuses: []int{403}, bodyMarker: "Generated by cloudfront (CloudFront)"},
+	{blockType: "waf:imperva", bodyMarker: "Incapsula incident ID"},
+	{blockType: "waf:imperva", bodyMarker: "_Incapsula_Resource"},
+	{blockType: "waf:sucuri", bodyMarker: "Sucuri WebSite Firewall - Access Denied"},
+	{blockType: "waf:modsecurity", bodyMarker: "This error was generated by Mod_Security"},
+	{blockType: "waf:f5", bodyMarker: "The requested URL was rejected. Please consult with your administrator."},
+}
+
+// blockType returns the type of the first signature resp matches or ""
+// if none matches.
+func blockType(resp string) string {
+	if resp == "" {
+		return ""
+	}
+	code := statusCode(resp)
+	body := responseBody(resp)
+	for _, sig := range blockSignatures {
+		if sig.matches(resp, code, body) {
+			return sig.blockType
+		}
+	}
+	return ""
+}
+
+func (sig blockSignature) matches(resp string, code int, body string) bool {
+	if len(sig.statuses) > 0 && !slices.Contains(sig.statuses, code) {
+		return false
+	}
+	if sig.header != "" {
+		value, ok := extractHeaders(resp, []string{sig.header})[sig.header]
+		if !ok || !strings.Contains(strings.ToLower(value), strings.ToLower(sig.headerValue)) {
+			return false
+		}
+	}
+	return sig.bodyMarker == "" || strings.Contains(body, sig.bodyMarker)
+}
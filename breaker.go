@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// breakerErrno is the errno of lines, that are skipped, because the
+// circuit breaker of their host is open.
+const breakerErrno = 40
+
+// circuitBreaker is used if -breaker is given.
+var circuitBreaker *breaker
+
+// breaker is a circuit breaker, which stops requests to hosts after a
+// number of consecutive connection failures. A nil *breaker never
+// skips requests.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	failures  map[string]int
+}
+
+func newBreaker(threshold int) *breaker {
+	return &breaker{threshold: threshold, failures: make(map[string]int)}
+}
+
+// skip returns true and marks line as failed, if the circuit breaker
+// for the host of line is open.
+func (b *breaker) skip(line *httpline) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	failures := b.failures[strings.ToLower(line.Host)]
+	b.mu.Unlock()
+	if failures < b.threshold {
+		return false
+	}
+	line.SetDefaults()
+	line.Errno = breakerErrno
+	line.Err = fmt.Sprintf("skipped by circuit breaker after %d consecutive failures", failures)
+	line.Errdetail = "circuit breaker"
+	return true
+}
+
+// record counts connection failures and timeouts of line towards the
+// threshold of its host. A successful request resets the count.
+func (b *breaker) record(line httpline) {
+	if b == nil || line.Errno == breakerErrno {
+		return
+	}
+	host := strings.ToLower(line.Host)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case line.Err == "":
+		delete(b.failures, host)
+	case isConnFailure(line.Errno):
+		b.failures[host]++
+	}
+}
+
+// isConnFailure returns true for the errnos of refused connections and
+// timeouts.
+func isConnFailure(errno int) bool {
+	return errno == 30 || isTimeoutErrno(errno)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// responses is used if -cache is given.
+var responses *responseCache
+
+// responseCache makes sure that identical lines are only requested
+// once. Later lines wait for the result of the first one.
+type responseCache struct {
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	done   chan struct{} // Closed when result is available.
+	result httpline
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{entries: make(map[string]*cacheEntry)}
+}
+
+// cacheKey returns a key, which is equal for lines with the same input
+// fields.
+func cacheKey(request httpline) string {
+	request.SetDefaults()
+	request.QueuedAt, request.N, request.ID = nil, 0, nil
+	key, _ := json.Marshal(request)
+	return string(key)
+}
+
+// do makes request, unless an identical request has been made before,
+// in which case its result is returned with the "cached" field set.
+func (c *responseCache) do(ctx context.Context, request httpline) httpline {
+	key := cacheKey(request)
+	c.mu.Lock()
+	entry, found := c.entries[key]
+	if !found {
+		entry = &cacheEntry{done: make(chan struct{})}
+		c.entries[key] = entry
+	}
+	c.mu.Unlock()
+	if !found {
+		entry.result = makeRequest(ctx, request)
+		close(entry.done)
+		return entry.result
+	}
+	select {
+	case <-entry.done:
+	case <-ctx.Done():
+		request.SetDefaults()
+		setErr(&request, ctx.Err())
+		return request
+	}
+	result := entry.result
+	result.QueuedAt, result.N, result.ID = request.QueuedAt, request.N, request.ID
+	result.Cached = true
+	return result
+}
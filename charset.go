@@ -0,0 +1,56 @@
+package main
+
+import (
+	"mime"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+)
+
+// utf8Body returns the body of resp converted to UTF-8 and the name of
+// the charset it was converted from. The charset is taken from a byte
+// order mark, the Content-Type header or a <meta> tag, in this order;
+// otherwise UTF-8 or windows-1252 is assumed. Empty strings are
+// returned if the body is empty, compressed or not text.
+func utf8Body(resp string) (body, name string) {
+	var contentType string
+	if values := headerValues(resp, "Content-Type"); len(values) > 0 {
+		contentType = values[len(values)-1]
+	}
+	if !isText(contentType) {
+		return "", ""
+	}
+	for _, encoding := range headerValues(resp, "Content-Encoding") {
+		if !strings.EqualFold(encoding, "identity") {
+			return "", ""
+		}
+	}
+	body = responseBody(resp)
+	if body == "" {
+		return "", ""
+	}
+	e, name, _ := charset.DetermineEncoding([]byte(body), contentType)
+	decoded, err := e.NewDecoder().String(body)
+	if err != nil {
+		return "", ""
+	}
+	return strings.ToValidUTF8(decoded, "\uFFFD"), name
+}
+
+// isText reports whether contentType denotes text. A missing
+// Content-Type is assumed to be text.
+func isText(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/ecmascript":
+		return true
+	}
+	return strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+xml") ||
+		strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,22 @@
+package main
+
+import "github.com/codesoap/preq/client"
+
+// chunks describes a response body in the chunked encoding. The trailer
+// fields are joined like those of "h2info".
+type chunks struct {
+	Count    int               `json:"count"`
+	Sizes    []int64           `json:"sizes,omitempty"`
+	Trailers map[string]string `json:"trailers,omitempty"`
+}
+
+func toChunks(info *client.ChunkInfo) *chunks {
+	if info == nil {
+		return nil
+	}
+	return &chunks{
+		Count:    len(info.Sizes),
+		Sizes:    info.Sizes,
+		Trailers: joinFields(info.Trailers),
+	}
+}
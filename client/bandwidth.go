@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// Bandwidth limits the throughput of connections in bytes per second.
+// Reading and writing are limited separately. A Bandwidth can be shared
+// by many connections; it is safe for concurrent use.
+type Bandwidth struct {
+	rate float64
+
+	mu   sync.Mutex
+	next [2]time.Time // The next free slot for reading and writing.
+}
+
+// NewBandwidth returns a Bandwidth that allows bytesPerSecond bytes to
+// be read and written per second.
+func NewBandwidth(bytesPerSecond int) *Bandwidth {
+	return &Bandwidth{rate: float64(bytesPerSecond)}
+}
+
+// reserve reserves the transfer of n bytes and returns the time at which
+// the transfer may start.
+func (b *Bandwidth) reserve(n int, sent bool) time.Time {
+	i := 0
+	if sent {
+		i = 1
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	at := time.Now()
+	if b.next[i].After(at) {
+		at = b.next[i]
+	}
+	b.next[i] = at.Add(time.Duration(float64(n) / b.rate * float64(time.Second)))
+	return at
+}
+
+// chunkSize returns the number of bytes transferred at once, so that
+// the throughput is smooth.
+func (b *Bandwidth) chunkSize() int {
+	return max(1, int(b.rate/10))
+}
+
+// throttle limits the throughput of conn to the bandwidth of the client
+// and the per-connection bandwidth of req or the client. conn is
+// returned unchanged, if there are no limits. Waiting for the bandwidth
+// is aborted when ctx, or the lifetime context stored in it by Warm, is
+// done.
+func (c *Client) throttle(ctx context.Context, conn net.Conn, req Request) net.Conn {
+	perConn := c.ConnBandwidth
+	if req.ConnBandwidth != 0 {
+		perConn = req.ConnBandwidth
+	}
+	if lifetime, ok := ctx.Value(connLifetimeKey{}).(context.Context); ok {
+		ctx = lifetime
+	}
+	t := &throttledConn{Conn: conn, ctx: ctx}
+	if c.Bandwidth != nil {
+		t.limits = append(t.limits, c.Bandwidth)
+	}
+	if perConn > 0 {
+		t.limits = append(t.limits, NewBandwidth(perConn))
+	}
+	if len(t.limits) == 0 {
+		return conn
+	}
+	t.chunk = t.limits[0].chunkSize()
+	for _, limit := range t.limits[1:] {
+		t.chunk = min(t.chunk, limit.chunkSize())
+	}
+	return t
+}
+
+// throttledConn is a connection, whose throughput is limited.
+type throttledConn struct {
+	net.Conn
+	ctx    context.Context
+	limits []*Bandwidth
+	chunk  int
+}
+
+func (t *throttledConn) Read(p []byte) (int, error) {
+	if len(p) > t.chunk {
+		p = p[:t.chunk]
+	}
+	n, err := t.Conn.Read(p)
+	if waitErr := t.wait(n, false); waitErr != nil && err == nil {
+		err = waitErr
+	}
+	return n, err
+}
+
+func (t *throttledConn) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		chunk := p[:min(len(p), t.chunk)]
+		if err := t.wait(len(chunk), true); err != nil {
+			return written, err
+		}
+		n, err := t.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// wait waits until n bytes may be transferred according to all limits.
+func (t *throttledConn) wait(n int, sent bool) error {
+	if n == 0 {
+		return nil
+	}
+	var at time.Time
+	for _, limit := range t.limits {
+		if slot := limit.reserve(n, sent); slot.After(at) {
+			at = slot
+		}
+	}
+	d := time.Until(at)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-t.ctx.Done():
+		return t.ctx.Err()
+	}
+}
@@ -0,0 +1,681 @@
+// Package client makes raw HTTP/1.1 requests, as preq does. The
+// requests are written to the connection unchanged and the response is
+// read using the extractor package.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/codesoap/preq/extractor"
+)
+
+// Request describes a single request.
+type Request struct {
+	Host string
+	Port int
+	TLS  bool
+
+	// Raw is the raw request, which is written to the connection as is.
+	Raw string
+
+	// Addresses optionally holds IP addresses of Host. If given, they are
+	// used instead of doing a DNS lookup.
+	Addresses []string
+
+	// HTTP2 makes the request use HTTP/2. With TLS, HTTP/2 is negotiated
+	// using ALPN, otherwise prior knowledge is assumed. Raw is translated
+	// to HTTP/2 and the response is returned in an equivalent textual
+	// form, starting with a status line like "HTTP/2 200".
+	HTTP2 bool
+
+	// H2CUpgrade makes HTTP/2 requests without TLS use the h2c upgrade
+	// instead of prior knowledge: Raw is sent with an "Upgrade: h2c"
+	// header and, if the server switches protocols, the response is read
+	// using HTTP/2. It is then preceded by the 101 response in Resp.
+	H2CUpgrade bool
+
+	// HTTP3 makes the request use HTTP/3 over QUIC, which requires TLS.
+	// Like with HTTP2, Raw is translated and the response is returned in
+	// an equivalent textual form, starting with a status line like
+	// "HTTP/3 200". HTTP/3 support is experimental.
+	HTTP3 bool
+
+	// ALPN is the list of protocols offered during the TLS handshake. If
+	// empty, the NextProtos of the TLS configuration are used; with HTTP2
+	// "h2" is offered by default.
+	ALPN []string
+
+	// Hello, if set, is the name of a browser, whose TLS ClientHello is
+	// mimicked using uTLS. See Hellos for the available names. It is
+	// ignored for HTTP/3 requests.
+	Hello string
+
+	// SendAt, if set, is the time at which the request is written. The
+	// connection is established beforehand, except for HTTP/3. The
+	// timeout starts at SendAt, if it lies in the future.
+	SendAt time.Time
+
+	// Pacing, if not nil, controls how Raw is written. It is ignored for
+	// HTTP/2 and HTTP/3 requests and for all but the first of pipelined
+	// requests.
+	Pacing *Pacing
+
+	// Body, if set, is a request body, that is written after Raw once the
+	// server answered with 100 Continue or BodyDelay elapsed. If the
+	// server sends a final response first, Body is not written. Zero
+	// BodyDelay means waiting for 100 Continue until the timeout. Body is
+	// ignored for HTTP/2, HTTP/3 and pipelined requests.
+	Body      string
+	BodyDelay time.Duration
+
+	// WebSocket, if not nil, makes the client read WebSocket frames
+	// after a 101 response that upgrades to WebSocket. It is ignored for
+	// pipelined requests.
+	WebSocket *WebSocketCapture
+
+	// Stream, if not nil, limits how much of the response is read.
+	// Reaching a limit while reading the body is no error, but sets
+	// Result.Streamed. It is ignored for pipelined requests.
+	Stream *StreamWindow
+
+	// FollowUp, if not nil, is called with the extracted response. If
+	// it returns a raw request, that request is written on the same
+	// connection and its outcome is stored in Result.FollowUp. It shares
+	// the timeout of the first request. FollowUp is ignored for HTTP/2,
+	// HTTP/3 and pipelined requests.
+	FollowUp func(resp string) (string, error)
+
+	// Timeout, TLSConfig, Dialer, ConnBandwidth and Proxy override the
+	// options of the Client for this request, if set.
+	Timeout       time.Duration
+	TLSConfig     *tls.Config
+	Dialer        *net.Dialer
+	ConnBandwidth int
+	Proxy         *Proxy
+
+	// Warm, if set, is a connection established by Client.Warm for an
+	// equivalent request. It is used instead of establishing a new
+	// connection, unless the server has closed it in the meantime. It
+	// is closed when the request is done. Warm is ignored for HTTP/3.
+	Warm *WarmConn
+}
+
+// Pacing describes how to write a request slowly, e.g. to test the
+// timeouts of servers. Note that the time spent writing counts towards
+// the timeout of the request.
+type Pacing struct {
+	// ChunkSize is the number of bytes written at once. Zero means
+	// writing everything at once.
+	ChunkSize int
+
+	// Delay is the pause between two chunks.
+	Delay time.Duration
+
+	// FinalPause is the pause before writing the CRLFCRLF that ends the
+	// head of the request.
+	FinalPause time.Duration
+}
+
+// Result is the outcome of a request. It may be partially filled, if
+// the request failed.
+type Result struct {
+	// Resp is the response extracted from the connection.
+	Resp string
+
+	// ReqAt is the time at which the request was written. It is zero if
+	// the request could not be written.
+	ReqAt time.Time
+
+	// Ping is the time between writing the request and receiving the
+	// first data of the response. It is zero if no data was received.
+	Ping time.Duration
+
+	// Duration is the time from the start of Do until the response was
+	// extracted or the request failed. It includes the time needed to
+	// connect.
+	Duration time.Duration
+
+	// CertProblem describes why the certificate of the server could not
+	// be verified. It is only set if Client.ReportCertProblems is true.
+	CertProblem *CertProblem
+
+	// Laxities lists the deviations from RFC 7230 that were tolerated
+	// while extracting the response. See the extractor package.
+	Laxities []string
+
+	// Violations lists every deviation from RFC 7230 in HTTP/1
+	// responses, if Client.Conformance is true.
+	Violations []extractor.Violation
+
+	// Framing describes how the end of the body of HTTP/1 responses was
+	// determined and FramingRule, which rule resolved conflicting
+	// framing headers. See extractor.Response.
+	Framing     string
+	FramingRule string
+
+	// Chunks describes the chunked encoding of the response body. It is
+	// only set for HTTP/1 responses with a chunked body.
+	Chunks *ChunkInfo
+
+	// Conn describes the connection that was used. It is only set if a
+	// connection could be established.
+	Conn *ConnInfo
+
+	// RawRequest holds the bytes that were actually written and
+	// RawResponse all bytes that were read, which may include data
+	// following the extracted response. They are only set if
+	// Client.CaptureRaw is true.
+	RawRequest  []byte
+	RawResponse []byte
+
+	// Interim holds the interim 1xx responses, that were received
+	// before Request.Body was written. They are also part of Resp.
+	// BodySent is true if Request.Body was written.
+	Interim  string
+	BodySent bool
+
+	// Frames holds the WebSocket frames received after the upgrade, if
+	// Request.WebSocket was set.
+	Frames []WebSocketFrame
+
+	// Streamed is true if reading the body was stopped, because the end
+	// of Request.Stream was reached.
+	Streamed bool
+
+	// Transcript holds the data written to and read from the connection
+	// after the connection was established. It is only set if
+	// Client.Transcript is true. Pipelined requests share it.
+	Transcript []TranscriptEntry
+
+	// Close describes the connection after the response. It is only
+	// set for HTTP/1 responses, that were extracted completely, except
+	// for the responses of pipelined requests but the last one and for
+	// WebSocket upgrades. With Request.FollowUp, it is set for the
+	// follow-up response instead.
+	Close *CloseInfo
+
+	// FollowUp holds the outcome of the request returned by
+	// Request.FollowUp. FollowUpErr is the error returned by
+	// Request.FollowUp or a *PhaseError describing why the follow-up
+	// request failed.
+	FollowUp    *Result
+	FollowUpErr error
+
+	// HTTP2 describes the HTTP/2 stream. It is only set for HTTP/2
+	// requests.
+	HTTP2 *HTTP2Info
+
+	// HTTP3 describes the QUIC connection. It is only set for HTTP/3
+	// requests, if a connection could be established.
+	HTTP3 *HTTP3Info
+}
+
+// ChunkInfo describes a body in the chunked encoding.
+type ChunkInfo struct {
+	// Sizes holds the sizes of the chunks, without the last chunk of
+	// size 0.
+	Sizes []int64
+
+	// Trailers are the fields of the trailer section.
+	Trailers []extractor.Field
+}
+
+// chunkInfo returns the ChunkInfo of resp or nil, if its body does not
+// use the chunked encoding.
+func chunkInfo(resp extractor.Response) *ChunkInfo {
+	if !resp.Chunked {
+		return nil
+	}
+	return &ChunkInfo{Sizes: resp.Chunks, Trailers: resp.Trailers}
+}
+
+// ConnInfo describes a connection.
+type ConnInfo struct {
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+
+	// TLS is nil if TLS was not used.
+	TLS *tls.ConnectionState
+
+	// Warm is true if the connection was established by Client.Warm.
+	Warm bool
+}
+
+// Client makes requests. The zero value is a valid client without a
+// timeout.
+type Client struct {
+	// Timeout limits the time a whole request may take. Zero means no
+	// timeout.
+	Timeout time.Duration
+
+	// TLSConfig is used for TLS connections. ServerName is set to the
+	// host of the request, if empty.
+	TLSConfig *tls.Config
+
+	// Dialer is used to connect to servers. Its Deadline is overwritten.
+	Dialer *net.Dialer
+
+	// FallbackDelay is the time to wait for a connection attempt before
+	// starting the next one in parallel, if the host has IPv4 and IPv6
+	// addresses. Zero means 250ms, like RFC 8305 recommends. A negative
+	// value disables racing the attempts.
+	FallbackDelay time.Duration
+
+	// Proxy, if not nil, is the HTTP proxy, through which connections
+	// are tunneled.
+	Proxy *Proxy
+
+	// Connector, if not nil, establishes all connections except those
+	// of HTTP/3 requests. Dialer, FallbackDelay, Proxy, Nagle and the
+	// proxies of requests are ignored then.
+	Connector Connector
+
+	// Nagle enables Nagle's algorithm on TCP connections. By default
+	// TCP_NODELAY is set, like net.Dialer does.
+	Nagle bool
+
+	// ReportCertProblems makes requests to servers with invalid
+	// certificates succeed; the problem is reported in
+	// Result.CertProblem instead.
+	ReportCertProblems bool
+
+	// Strict makes requests fail if the response deviates from RFC 7230.
+	Strict bool
+
+	// Conformance makes Do fill Result.Violations with every deviation
+	// from RFC 7230, instead of failing or only listing the tolerated
+	// ones. Strict is ignored then.
+	Conformance bool
+
+	// CloseWait is the time waited after the last response on a
+	// connection, to see whether the server closes it. See CloseInfo.
+	// Zero means not waiting.
+	CloseWait time.Duration
+
+	// Bandwidth, if not nil, limits the total throughput of all
+	// connections. ConnBandwidth limits the throughput of each connection
+	// in bytes per second; zero means no limit. Both limit reading and
+	// writing separately, including the TLS overhead. They are ignored for
+	// HTTP/3 requests.
+	Bandwidth     *Bandwidth
+	ConnBandwidth int
+
+	// CaptureRaw makes Do fill Result.RawRequest and Result.RawResponse.
+	CaptureRaw bool
+
+	// Transcript makes Do fill Result.Transcript. It is ignored for
+	// HTTP/3 requests.
+	Transcript bool
+
+	// Logf, if not nil, is called to log the lifecycle of connections.
+	// The context is the one given to Do. Level 1 is used for the main
+	// steps, level 2 for details.
+	Logf func(ctx context.Context, level int, format string, v ...any)
+}
+
+// Do makes req. If an error occurs, it is a *PhaseError and the Result
+// contains everything that was gathered until the error occurred.
+//
+// The request is aborted when ctx is done, even while the request is
+// written or the response is read. If ctx was canceled, the error wraps
+// context.Canceled.
+func (c *Client) Do(ctx context.Context, req Request) (Result, error) {
+	results, errs := c.DoPipelined(ctx, []Request{req})
+	return results[0], errs[0]
+}
+
+// DoPipelined makes reqs using HTTP/1.1 pipelining: all requests are
+// written back-to-back on a single connection, before the responses are
+// read. The connection is established and configured according to the
+// first request, so all requests should target the same server.
+//
+// A Result and an error are returned for each request, like Do returns
+// them. If a response cannot be extracted, the following ones fail with
+// ErrPipelineBroken. HTTP/2 and HTTP/3 requests cannot be pipelined.
+// The Ping of each result is the time until the first data was received
+// on the connection and, if Client.CaptureRaw is true, the RawResponse
+// of each result holds all bytes read from it.
+func (c *Client) DoPipelined(ctx context.Context, reqs []Request) (results []Result, errs []error) {
+	results, errs = make([]Result, len(reqs)), make([]error, len(reqs))
+	start := time.Now()
+	defer func() {
+		for i := range results {
+			if results[i].Duration == 0 {
+				results[i].Duration = time.Since(start)
+			}
+		}
+	}()
+	fail := func(from int, err error) ([]Result, []error) {
+		for i := from; i < len(reqs); i++ {
+			errs[i] = err
+		}
+		return results, errs
+	}
+	if len(reqs) == 0 {
+		return results, errs
+	}
+	first := reqs[0]
+	timeout := c.Timeout
+	if first.Timeout != 0 {
+		timeout = first.Timeout
+	}
+	if timeout != 0 {
+		start := time.Now()
+		if first.SendAt.After(start) {
+			start = first.SendAt
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, start.Add(timeout))
+		defer cancel()
+	}
+	var problem *CertProblem
+	if c.ReportCertProblems {
+		problem = &CertProblem{}
+	}
+	if first.HTTP3 {
+		if len(reqs) > 1 {
+			return fail(0, &PhaseError{PhaseConnect, errors.New("HTTP/3 requests cannot be pipelined")})
+		}
+		if err := c.waitForSendAt(ctx, first); err != nil {
+			return fail(0, &PhaseError{PhaseConnect, err})
+		}
+		errs[0] = c.doHTTP3(ctx, first, problem, &results[0])
+		return results, errs
+	}
+	conn, warm, err := c.connect(ctx, first, problem)
+	if problem != nil && problem.Err != nil {
+		for i := range results {
+			results[i].CertProblem = problem
+		}
+	}
+	if err != nil {
+		c.logf(ctx, 1, "could not connect: %v", err)
+		return fail(0, err)
+	}
+	info := connInfo(conn)
+	info.Warm = warm
+	for i := range results {
+		results[i].Conn = info
+	}
+	if c.Transcript {
+		rec := &recordingConn{Conn: conn}
+		conn = rec
+		defer func() {
+			for i := range results {
+				results[i].Transcript = rec.transcript()
+			}
+		}()
+	}
+	closeReason := "response complete"
+	defer func() {
+		c.logf(ctx, 1, "closing connection: %s", closeReason)
+		conn.Close()
+	}()
+	stop := context.AfterFunc(ctx, func() {
+		// Unblock pending reads and writes.
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+	defer func() {
+		if !errors.Is(ctx.Err(), context.Canceled) {
+			return
+		}
+		for i, err := range errs {
+			var perr *PhaseError
+			if errors.As(err, &perr) && !errors.Is(err, context.Canceled) {
+				errs[i] = &PhaseError{perr.Phase, fmt.Errorf("%w: %v", context.Canceled, perr.Err)}
+			}
+		}
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = conn.SetDeadline(deadline); err != nil {
+			closeReason = err.Error()
+			return fail(0, &PhaseError{PhaseConnect, err})
+		}
+	}
+	if err = c.waitForSendAt(ctx, first); err != nil {
+		closeReason = err.Error()
+		return fail(0, &PhaseError{PhaseWrite, err})
+	}
+	if first.HTTP2 {
+		if len(reqs) > 1 {
+			closeReason = "HTTP/2 requests cannot be pipelined"
+			return fail(0, &PhaseError{PhaseWrite, errors.New(closeReason)})
+		}
+		if errs[0] = c.doHTTP2(conn, first, &results[0]); errs[0] != nil {
+			closeReason = errs[0].Error()
+		}
+		c.logf(ctx, 1, "received %d bytes of HTTP/2 response", len(results[0].Resp))
+		return results, errs
+	}
+	// Only the first request is paced; the others follow at once.
+	var written int
+	rest := reqs
+	if first.Pacing != nil {
+		written, err = c.write(ctx, conn, first.Raw, first.Pacing)
+		rest = reqs[1:]
+	}
+	if err == nil && len(rest) > 0 {
+		var raw strings.Builder
+		for _, req := range rest {
+			raw.WriteString(req.Raw)
+		}
+		var n int
+		n, err = c.write(ctx, conn, raw.String(), nil)
+		written += n
+	}
+	c.logf(ctx, 1, "wrote %d bytes", written)
+	reqAt := time.Now()
+	for i, req := range reqs {
+		if c.CaptureRaw {
+			results[i].RawRequest = []byte(req.Raw[:min(written, len(req.Raw))])
+		}
+		if written >= len(req.Raw) {
+			results[i].ReqAt = reqAt
+		}
+		written = max(written-len(req.Raw), 0)
+	}
+	if err != nil {
+		closeReason = err.Error()
+		return fail(0, &PhaseError{PhaseWrite, err})
+	}
+	timedConn := &timedReader{r: conn}
+	if c.CaptureRaw {
+		timedConn.raw = &bytes.Buffer{}
+		defer func() {
+			for i := range results {
+				results[i].RawResponse = timedConn.raw.Bytes()
+			}
+		}()
+	}
+	stream := first.Stream
+	if len(reqs) > 1 {
+		stream = nil
+	}
+	if stream != nil && stream.MaxBytes > 0 {
+		timedConn.r = &streamReader{r: conn, remaining: stream.MaxBytes}
+	}
+	if stream != nil && stream.MaxTime > 0 {
+		deadline, ok := ctx.Deadline()
+		if end := reqAt.Add(stream.MaxTime); !ok || end.Before(deadline) {
+			conn.SetReadDeadline(end)
+		}
+	}
+	reader := bufio.NewReader(timedConn)
+	if first.Body != "" && len(reqs) == 1 {
+		early, err := c.sendBody(ctx, conn, reader, first, &results[0])
+		if early != nil {
+			results[0].Resp = results[0].Interim + early.Raw
+			results[0].Laxities, results[0].Violations = early.Laxities, early.Violations
+			if !timedConn.readAt.IsZero() {
+				results[0].Ping = timedConn.readAt.Sub(reqAt)
+			}
+		}
+		if err != nil {
+			closeReason = err.Error()
+			errs[0] = err
+			return results, errs
+		} else if early != nil {
+			c.logf(ctx, 1, "got final response before sending the body")
+			return results, errs
+		}
+	}
+	for i, req := range reqs {
+		resp, err := extractor.ExtractResponseFull(reader, extractor.Options{
+			Method:      method(req.Raw),
+			Limits:      extractor.DefaultLimits,
+			Strict:      c.Strict,
+			Conformance: c.Conformance,
+		})
+		results[i].Resp, results[i].Laxities = results[i].Interim+resp.Raw, resp.Laxities
+		results[i].Violations = resp.Violations
+		results[i].Framing, results[i].FramingRule = resp.Framing, resp.FramingRule
+		results[i].Chunks = chunkInfo(resp)
+		if !timedConn.readAt.IsZero() {
+			results[i].Ping = timedConn.readAt.Sub(reqAt)
+		}
+		c.logf(ctx, 1, "read %d bytes, extracted %d bytes", timedConn.n, len(resp.Raw))
+		results[i].Duration = time.Since(start)
+		if err != nil {
+			err = extractionError(err)
+			if stream != nil && isStreamEnd(ctx, err) {
+				c.logf(ctx, 1, "end of stream window: %v", err)
+				closeReason, results[i].Streamed = "end of stream window", true
+				return results, errs
+			}
+			closeReason = err.Error()
+			errs[i] = err
+			return fail(i+1, &PhaseError{PhaseHead, ErrPipelineBroken})
+		}
+		if i < len(reqs)-1 && resp.Framing == extractor.FramingClose {
+			// The body extends to the end of the connection, so there
+			// are no further responses.
+			closeReason = "body delimited by connection close"
+			return fail(i+1, &PhaseError{PhaseHead, ErrPipelineBroken})
+		}
+		if req.WebSocket != nil && len(reqs) == 1 && isWebSocketUpgrade(resp) {
+			results[i].Frames, err = c.readFrames(ctx, conn, reader, *req.WebSocket)
+			if err != nil {
+				closeReason = err.Error()
+				errs[i] = &PhaseError{PhaseBody, err}
+			}
+		} else if i == len(reqs)-1 && first.FollowUp == nil {
+			results[i].Close = c.closeInfo(ctx, conn, reader, resp)
+		}
+	}
+	if first.FollowUp != nil && len(reqs) == 1 && errs[0] == nil && results[0].Frames == nil {
+		c.doFollowUp(ctx, conn, reader, timedConn, first, &results[0])
+	}
+	return results, errs
+}
+
+// write writes data to conn, pacing it as described by p, if not nil.
+func (c *Client) write(ctx context.Context, conn net.Conn, data string, p *Pacing) (int, error) {
+	if p == nil {
+		return io.WriteString(conn, data)
+	}
+	c.logf(ctx, 2, "writing in chunks of %d bytes with %v between them", p.ChunkSize, p.Delay)
+	head, rest := data, ""
+	if i := strings.Index(data, "\r\n\r\n"); i >= 0 && p.FinalPause > 0 {
+		head, rest = data[:i], data[i:]
+	}
+	written, err := writeChunks(ctx, conn, head, p)
+	if err != nil || rest == "" {
+		return written, err
+	}
+	c.logf(ctx, 2, "pausing %v before the end of the head", p.FinalPause)
+	if err = sleep(ctx, p.FinalPause); err != nil {
+		return written, err
+	}
+	n, err := writeChunks(ctx, conn, rest, p)
+	return written + n, err
+}
+
+// writeChunks writes data to conn in chunks of p.ChunkSize bytes,
+// pausing for p.Delay between them.
+func writeChunks(ctx context.Context, conn net.Conn, data string, p *Pacing) (int, error) {
+	written := 0
+	for written < len(data) {
+		size := len(data) - written
+		if p.ChunkSize > 0 {
+			size = min(size, p.ChunkSize)
+		}
+		n, err := io.WriteString(conn, data[written:written+size])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if written < len(data) {
+			if err = sleep(ctx, p.Delay); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// sleep waits for d or until ctx is done, in which case the error of
+// ctx is returned.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// extractionError wraps an error from the extractor package in a
+// *PhaseError.
+func extractionError(err error) error {
+	var headErr *extractor.HeadError
+	if errors.As(err, &headErr) {
+		return &PhaseError{PhaseHead, err}
+	}
+	return &PhaseError{PhaseBody, err}
+}
+
+func connInfo(conn net.Conn) *ConnInfo {
+	info := &ConnInfo{LocalAddr: conn.LocalAddr(), RemoteAddr: conn.RemoteAddr()}
+	if state, ok := tlsState(conn); ok {
+		info.TLS = &state
+	}
+	return info
+}
+
+func (c *Client) logf(ctx context.Context, level int, format string, v ...any) {
+	if c.Logf != nil {
+		c.Logf(ctx, level, format, v...)
+	}
+}
+
+// waitForSendAt waits until req.SendAt or until ctx is done, in which
+// case the error of ctx is returned.
+func (c *Client) waitForSendAt(ctx context.Context, req Request) error {
+	wait := time.Until(req.SendAt)
+	if req.SendAt.IsZero() || wait <= 0 {
+		return nil
+	}
+	c.logf(ctx, 2, "waiting %v until %s", wait, req.SendAt.Format(time.RFC3339Nano))
+	return sleep(ctx, wait)
+}
+
+// method returns the method of the raw request req.
+func method(req string) string {
+	m, _, _ := strings.Cut(req, " ")
+	return m
+}
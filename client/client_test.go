@@ -0,0 +1,755 @@
+package client_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/codesoap/preq/client"
+	"github.com/codesoap/preq/extractor"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// serve accepts connections on a local port and answers each with resp.
+// If resp is empty, the connections are left open without an answer.
+func serve(t *testing.T, resp string) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				buf := make([]byte, 1024)
+				conn.Read(buf)
+				if resp != "" {
+					conn.Write([]byte(resp))
+					conn.Close()
+				}
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestDo(t *testing.T) {
+	resp := "HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nfoo"
+	port := serve(t, resp+"bar")
+	req := client.Request{
+		Host:      "test.invalid",
+		Port:      port,
+		Raw:       "GET / HTTP/1.1\r\nHost: test.invalid\r\n\r\n",
+		Addresses: []string{"127.0.0.1"},
+	}
+	c := client.Client{Timeout: time.Second, CaptureRaw: true}
+	result, err := c.Do(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if result.Resp != resp {
+		t.Errorf("Got unexpected response.\nGot   : %s\nWanted: %s", result.Resp, resp)
+	}
+	if result.ReqAt.IsZero() {
+		t.Errorf("ReqAt was not set.")
+	}
+	if string(result.RawRequest) != req.Raw {
+		t.Errorf("Got unexpected raw request: %s", result.RawRequest)
+	}
+	if !strings.HasPrefix(string(result.RawResponse), resp) {
+		t.Errorf("Got unexpected raw response: %s", result.RawResponse)
+	}
+	if result.Conn == nil || result.Conn.RemoteAddr.(*net.TCPAddr).Port != port {
+		t.Errorf("Got unexpected connection info: %+v", result.Conn)
+	}
+}
+
+func TestDoTimeout(t *testing.T) {
+	port := serve(t, "")
+	req := client.Request{
+		Host: "127.0.0.1",
+		Port: port,
+		Raw:  "GET / HTTP/1.1\r\nHost: 127.0.0.1\r\n\r\n",
+	}
+	c := client.Client{Timeout: 100 * time.Millisecond}
+	_, err := c.Do(context.Background(), req)
+	var perr *client.PhaseError
+	if !errors.As(err, &perr) || perr.Phase != client.PhaseHead {
+		t.Errorf("Expected error in phase %s, got: %v", client.PhaseHead, err)
+	}
+}
+
+func TestDoFallback(t *testing.T) {
+	resp := "HTTP/1.1 204 No Content\r\n\r\n"
+	// Connection attempts to IPv6 addresses hang, like they would with a
+	// broken AAAA record.
+	dialer := &net.Dialer{
+		ControlContext: func(ctx context.Context, network, address string, _ syscall.RawConn) error {
+			if network == "tcp6" {
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			return nil
+		},
+	}
+	req := client.Request{
+		Host:      "localhost",
+		Port:      serve(t, resp),
+		Raw:       "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		Addresses: []string{"::1", "::2", "127.0.0.1"},
+		Dialer:    dialer,
+	}
+	c := client.Client{Timeout: 5 * time.Second, FallbackDelay: 50 * time.Millisecond}
+	start := time.Now()
+	result, err := c.Do(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Resp != resp {
+		t.Errorf("Got unexpected response '%s'", result.Resp)
+	}
+	// 127.0.0.1 is tried second, after 50ms.
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Request took %v", d)
+	}
+}
+
+func TestDoCancel(t *testing.T) {
+	port := serve(t, "")
+	req := client.Request{
+		Host: "127.0.0.1",
+		Port: port,
+		Raw:  "GET / HTTP/1.1\r\nHost: 127.0.0.1\r\n\r\n",
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	c := client.Client{Timeout: 5 * time.Second}
+	_, err := c.Do(ctx, req)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected cancellation error, got: %v", err)
+	}
+}
+
+func TestDoSendAt(t *testing.T) {
+	port := serve(t, "HTTP/1.1 204 No Content\r\n\r\n")
+	sendAt := time.Now().Add(200 * time.Millisecond)
+	req := client.Request{
+		Host:   "127.0.0.1",
+		Port:   port,
+		Raw:    "GET / HTTP/1.1\r\nHost: 127.0.0.1\r\n\r\n",
+		SendAt: sendAt,
+	}
+	// The timeout starts at SendAt, so it must not expire while waiting.
+	c := client.Client{Timeout: 100 * time.Millisecond}
+	result, err := c.Do(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ReqAt.Before(sendAt) {
+		t.Errorf("Request was sent at %v, before %v", result.ReqAt, sendAt)
+	}
+}
+
+func TestDoPacing(t *testing.T) {
+	port := serve(t, "")
+	req := client.Request{
+		Host:   "127.0.0.1",
+		Port:   port,
+		Raw:    "GET / HTTP/1.1\r\nHost: 127.0.0.1\r\n\r\n",
+		Pacing: &client.Pacing{ChunkSize: 10, Delay: 20 * time.Millisecond, FinalPause: 100 * time.Millisecond},
+	}
+	c := client.Client{Timeout: 300 * time.Millisecond, CaptureRaw: true}
+	start := time.Now()
+	result, err := c.Do(context.Background(), req)
+	var perr *client.PhaseError
+	if !errors.As(err, &perr) || perr.Phase != client.PhaseHead {
+		t.Errorf("Expected error in phase %s, got: %v", client.PhaseHead, err)
+	}
+	if string(result.RawRequest) != req.Raw {
+		t.Errorf("Got unexpected raw request '%s'", result.RawRequest)
+	}
+	// 3 pauses between the chunks of the first 31 bytes and the final
+	// pause.
+	if elapsed := result.ReqAt.Sub(start); elapsed < 160*time.Millisecond {
+		t.Errorf("Request was written too fast, in %v", elapsed)
+	}
+}
+
+func TestDoBody(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 1024)
+				n, _ := conn.Read(buf)
+				switch {
+				case strings.HasPrefix(string(buf[:n]), "PUT /silent "):
+					// Answer only after receiving the body.
+				case strings.HasPrefix(string(buf[:n]), "PUT /continue "):
+					conn.Write([]byte("HTTP/1.1 100 Continue\r\n\r\n"))
+				default:
+					conn.Write([]byte("HTTP/1.1 417 Expectation Failed\r\nContent-Length: 0\r\n\r\n"))
+					return
+				}
+				n, _ = conn.Read(buf)
+				fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", n, buf[:n])
+			}()
+		}
+	}()
+	port := l.Addr().(*net.TCPAddr).Port
+	tests := []struct {
+		path      string
+		bodyDelay time.Duration
+		interim   string
+		resp      string
+	}{
+		{"/continue", 0, "HTTP/1.1 100 Continue\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nfoo"},
+		{"/silent", 50 * time.Millisecond, "", "HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nfoo"},
+		{"/refuse", 0, "", "HTTP/1.1 417 Expectation Failed\r\nContent-Length: 0\r\n\r\n"},
+	}
+	c := client.Client{Timeout: time.Second}
+	for i, tt := range tests {
+		req := client.Request{
+			Host:      "127.0.0.1",
+			Port:      port,
+			Raw:       "PUT " + tt.path + " HTTP/1.1\r\nHost: 127.0.0.1\r\nExpect: 100-continue\r\nContent-Length: 3\r\n\r\n",
+			Body:      "foo",
+			BodyDelay: tt.bodyDelay,
+		}
+		result, err := c.Do(context.Background(), req)
+		if err != nil {
+			t.Errorf("%d. Got unexpected error: %v", i, err)
+		}
+		if result.Interim != tt.interim {
+			t.Errorf("%d. Got unexpected interim response '%s'", i, result.Interim)
+		}
+		if result.Resp != tt.interim+tt.resp {
+			t.Errorf("%d. Got unexpected response '%s'", i, result.Resp)
+		}
+		if result.BodySent != (tt.path != "/refuse") {
+			t.Errorf("%d. Got unexpected BodySent %v", i, result.BodySent)
+		}
+	}
+}
+
+func TestDoWebSocket(t *testing.T) {
+	upgrade := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"
+	frames := "\x81\x05hello" + // Text frame.
+		"\x82\x83\x01\x02\x03\x04\x01\x02\x03" + // Masked binary frame.
+		"\x81\x02hi"
+	port := serve(t, upgrade+frames)
+	req := client.Request{
+		Host:      "127.0.0.1",
+		Port:      port,
+		Raw:       "GET / HTTP/1.1\r\nHost: 127.0.0.1\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n",
+		WebSocket: &client.WebSocketCapture{MaxFrames: 2},
+	}
+	c := client.Client{Timeout: time.Second}
+	result, err := c.Do(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Resp != upgrade {
+		t.Errorf("Got unexpected response '%s'", result.Resp)
+	}
+	expected := []client.WebSocketFrame{
+		{Fin: true, Opcode: 1, Payload: []byte("hello")},
+		{Fin: true, Opcode: 2, Payload: []byte{0, 0, 0}},
+	}
+	if len(result.Frames) != len(expected) {
+		t.Fatalf("Got %d frames instead of %d", len(result.Frames), len(expected))
+	}
+	for i, frame := range result.Frames {
+		if frame.Fin != expected[i].Fin || frame.Opcode != expected[i].Opcode || string(frame.Payload) != string(expected[i].Payload) {
+			t.Errorf("%d. Got unexpected frame %+v", i, frame)
+		}
+	}
+}
+
+func TestDoStream(t *testing.T) {
+	head := "HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\n\r\n"
+	port := serve(t, head+"data: 1\n\ndata: 2\n\n")
+	req := client.Request{
+		Host:   "127.0.0.1",
+		Port:   port,
+		Raw:    "GET / HTTP/1.1\r\nHost: 127.0.0.1\r\n\r\n",
+		Stream: &client.StreamWindow{MaxBytes: int64(len(head) + 9)},
+	}
+	c := client.Client{Timeout: time.Second}
+	result, err := c.Do(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Streamed {
+		t.Error("Streamed is not set")
+	}
+	if result.Resp != head+"data: 1\n\n" {
+		t.Errorf("Got unexpected response '%s'", result.Resp)
+	}
+}
+
+func TestDoCloseInfo(t *testing.T) {
+	tests := []struct {
+		resp string
+		want client.CloseInfo
+	}{
+		{"HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nfoo", client.CloseInfo{Persistent: true, Closed: true}},
+		{"HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nfoobar", client.CloseInfo{Persistent: true, Closed: true, ExtraBytes: 3}},
+		{"HTTP/1.1 200 OK\r\nConnection: keep-alive, Close\r\nContent-Length: 0\r\n\r\n", client.CloseInfo{Header: true, Closed: true}},
+	}
+	c := client.Client{Timeout: time.Second, CloseWait: 500 * time.Millisecond}
+	for i, tt := range tests {
+		req := client.Request{
+			Host: "127.0.0.1",
+			Port: serve(t, tt.resp),
+			Raw:  "GET / HTTP/1.1\r\nHost: 127.0.0.1\r\n\r\n",
+		}
+		result, err := c.Do(context.Background(), req)
+		if err != nil {
+			t.Fatalf("%d. Got unexpected error: %v", i, err)
+		}
+		if result.Close == nil {
+			t.Fatalf("%d. Got no close info", i)
+		}
+		got := *result.Close
+		if got.Closed && got.ClosedAfter <= 0 {
+			t.Errorf("%d. Got unexpected closing time %v", i, got.ClosedAfter)
+		}
+		got.ClosedAfter = 0
+		if got != tt.want {
+			t.Errorf("%d. Got close info %+v, wanted %+v", i, got, tt.want)
+		}
+	}
+}
+
+func TestDoTranscript(t *testing.T) {
+	resp := "HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nfoo"
+	req := client.Request{
+		Host: "127.0.0.1",
+		Port: serve(t, resp),
+		Raw:  "GET / HTTP/1.1\r\nHost: 127.0.0.1\r\n\r\n",
+	}
+	c := client.Client{Timeout: time.Second, Transcript: true}
+	result, err := c.Do(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent, received string
+	for _, entry := range result.Transcript {
+		if entry.Sent {
+			sent += string(entry.Data)
+		} else {
+			received += string(entry.Data)
+		}
+	}
+	if sent != req.Raw {
+		t.Errorf("Got unexpected sent data '%s'", sent)
+	}
+	if received != resp {
+		t.Errorf("Got unexpected received data '%s'", received)
+	}
+}
+
+func TestDoBandwidth(t *testing.T) {
+	resp := "HTTP/1.1 200 OK\r\nContent-Length: 300\r\n\r\n" + strings.Repeat("a", 300)
+	req := client.Request{
+		Host:          "127.0.0.1",
+		Port:          serve(t, resp),
+		Raw:           "GET / HTTP/1.1\r\nHost: 127.0.0.1\r\n\r\n",
+		ConnBandwidth: 1000,
+	}
+	c := client.Client{Timeout: 5 * time.Second}
+	start := time.Now()
+	result, err := c.Do(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Resp != resp {
+		t.Errorf("Got unexpected response '%s'", result.Resp)
+	}
+	// Reading the response at 1000 bytes per second takes at least 0.3s.
+	if d := time.Since(start); d < 250*time.Millisecond {
+		t.Errorf("Request took only %v", d)
+	}
+}
+
+// serveProxy runs a CONNECT proxy on a local port, which requires the
+// given Proxy-Authorization.
+func serveProxy(t *testing.T, auth string) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+				if req.Header.Get("Proxy-Authorization") != auth {
+					io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 0\r\n\r\n")
+					return
+				}
+				upstream, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n")
+					return
+				}
+				defer upstream.Close()
+				io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+				go io.Copy(upstream, conn)
+				io.Copy(conn, upstream)
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestDoProxy(t *testing.T) {
+	resp := "HTTP/1.1 204 No Content\r\n\r\n"
+	req := client.Request{
+		Host: "127.0.0.1",
+		Port: serve(t, resp),
+		Raw:  "GET / HTTP/1.1\r\nHost: 127.0.0.1\r\n\r\n",
+	}
+	auth := client.BasicAuth("user", "secret")
+	proxy := client.Proxy{Host: "127.0.0.1", Port: serveProxy(t, auth), Auth: auth}
+	c := client.Client{Timeout: 5 * time.Second, Proxy: &proxy}
+	result, err := c.Do(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Resp != resp {
+		t.Errorf("Got unexpected response '%s'", result.Resp)
+	}
+
+	proxy.Auth = "Bearer token"
+	_, err = c.Do(context.Background(), req)
+	var perr *client.PhaseError
+	if !errors.As(err, &perr) || perr.Phase != client.PhaseProxy {
+		t.Errorf("Expected error in phase %s, got: %v", client.PhaseProxy, err)
+	}
+}
+
+func TestDoFollowUp(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for i := 0; ; i++ {
+			req, err := http.ReadRequest(reader)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(conn, "HTTP/1.1 302 Found\r\nLocation: /%d\r\nContent-Length: %d\r\n\r\n%s",
+				i+1, len(req.URL.Path), req.URL.Path)
+		}
+	}()
+	req := client.Request{
+		Host: "127.0.0.1",
+		Port: l.Addr().(*net.TCPAddr).Port,
+		Raw:  "GET /0 HTTP/1.1\r\nHost: 127.0.0.1\r\n\r\n",
+		FollowUp: func(resp string) (string, error) {
+			if !strings.Contains(resp, "Location: /1\r\n") {
+				return "", fmt.Errorf("unexpected response '%s'", resp)
+			}
+			return "GET /1 HTTP/1.1\r\nHost: 127.0.0.1\r\n\r\n", nil
+		},
+	}
+	c := client.Client{Timeout: time.Second}
+	result, err := c.Do(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.FollowUpErr != nil {
+		t.Fatal(result.FollowUpErr)
+	}
+	want := "HTTP/1.1 302 Found\r\nLocation: /2\r\nContent-Length: 2\r\n\r\n/1"
+	if result.FollowUp == nil || result.FollowUp.Resp != want {
+		t.Errorf("Got unexpected follow-up result %+v", result.FollowUp)
+	}
+}
+
+func TestDoPipelined(t *testing.T) {
+	resps := []string{
+		"HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nfoo",
+		"HTTP/1.1 404 Not Found\r\nContent-Length: 3\r\n\r\n",
+	}
+	port := serve(t, resps[0]+resps[1])
+	raw := "GET / HTTP/1.1\r\nHost: 127.0.0.1\r\n\r\n"
+	reqs := []client.Request{
+		{Host: "127.0.0.1", Port: port, Raw: raw},
+		{Host: "127.0.0.1", Port: port, Raw: strings.Replace(raw, "GET", "HEAD", 1)},
+		{Host: "127.0.0.1", Port: port, Raw: raw},
+		{Host: "127.0.0.1", Port: port, Raw: raw},
+	}
+	c := client.Client{Timeout: time.Second}
+	results, errs := c.DoPipelined(context.Background(), reqs)
+	for i, resp := range resps {
+		if errs[i] != nil {
+			t.Errorf("%d. Got unexpected error: %v", i, errs[i])
+		}
+		if results[i].Resp != resp {
+			t.Errorf("%d. Got unexpected response.\nGot   : %s\nWanted: %s", i, results[i].Resp, resp)
+		}
+	}
+	var perr *client.PhaseError
+	if !errors.As(errs[2], &perr) || perr.Phase != client.PhaseHead {
+		t.Errorf("Got unexpected error for missing response: %v", errs[2])
+	}
+	if !errors.Is(errs[3], client.ErrPipelineBroken) {
+		t.Errorf("Got unexpected error after missing response: %v", errs[3])
+	}
+}
+
+func TestDoHTTP2(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Trailer", "X-Trailer")
+		fmt.Fprintf(w, "%s %s %s %s", r.Proto, r.Method, r.URL.Path, body)
+		w.Header().Set("X-Trailer", "foo")
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+	port := ts.Listener.Addr().(*net.TCPAddr).Port
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+	req := client.Request{
+		Host:      "example.com",
+		Port:      port,
+		TLS:       true,
+		Raw:       "POST /path HTTP/1.1\r\nHost: example.com\r\nContent-Length: 3\r\n\r\nbar",
+		Addresses: []string{"127.0.0.1"},
+		HTTP2:     true,
+	}
+	c := client.Client{Timeout: time.Second, TLSConfig: &tls.Config{RootCAs: roots}}
+	result, err := c.Do(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(result.Resp, "HTTP/2 200\r\n") ||
+		!strings.HasSuffix(result.Resp, "\r\n\r\nHTTP/2.0 POST /path bar") {
+		t.Errorf("Got unexpected response: %s", result.Resp)
+	}
+	expectedTrailers := []extractor.Field{{Name: "x-trailer", Value: "foo"}}
+	if result.HTTP2 == nil || !slices.Equal(result.HTTP2.Trailers, expectedTrailers) {
+		t.Errorf("Got unexpected HTTP/2 info: %+v", result.HTTP2)
+	}
+}
+
+func TestDoH2C(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.Proto, r.URL.Path)
+	})
+	ts := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer ts.Close()
+	port := ts.Listener.Addr().(*net.TCPAddr).Port
+	for _, upgrade := range []bool{false, true} {
+		req := client.Request{
+			Host:       "127.0.0.1",
+			Port:       port,
+			Raw:        "GET /path HTTP/1.1\r\nHost: 127.0.0.1\r\n\r\n",
+			HTTP2:      true,
+			H2CUpgrade: upgrade,
+		}
+		c := client.Client{Timeout: time.Second}
+		result, err := c.Do(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Got unexpected error with upgrade=%t: %v", upgrade, err)
+		}
+		if upgrade && !strings.HasPrefix(result.Resp, "HTTP/1.1 101 Switching Protocols\r\n") {
+			t.Errorf("Missing 101 response: %s", result.Resp)
+		}
+		if !strings.Contains(result.Resp, "HTTP/2 200\r\n") || !strings.HasSuffix(result.Resp, " /path") {
+			t.Errorf("Got unexpected response with upgrade=%t: %s", upgrade, result.Resp)
+		}
+	}
+}
+
+func TestDoHTTP3(t *testing.T) {
+	ts := httptest.NewUnstartedServer(nil)
+	ts.StartTLS()
+	ts.Close()
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := http3.Server{
+		TLSConfig: http3.ConfigureTLSConfig(ts.TLS.Clone()),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s %s", r.Proto, r.URL.Path)
+		}),
+	}
+	go server.Serve(udpConn)
+	defer server.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+	req := client.Request{
+		Host:      "example.com",
+		Port:      udpConn.LocalAddr().(*net.UDPAddr).Port,
+		TLS:       true,
+		Raw:       "GET /path HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		Addresses: []string{"127.0.0.1"},
+		HTTP3:     true,
+	}
+	c := client.Client{Timeout: time.Second, TLSConfig: &tls.Config{RootCAs: roots}}
+	result, err := c.Do(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(result.Resp, "HTTP/3 200\r\n") || !strings.HasSuffix(result.Resp, "\r\n\r\nHTTP/3.0 /path") {
+		t.Errorf("Got unexpected response: %s", result.Resp)
+	}
+	if result.HTTP3 == nil || result.HTTP3.QUICVersion == "" {
+		t.Errorf("Got unexpected HTTP/3 info: %+v", result.HTTP3)
+	}
+}
+
+func TestDoHello(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	}))
+	defer ts.Close()
+	for _, hello := range client.Hellos() {
+		req := client.Request{
+			Host:  "127.0.0.1",
+			Port:  ts.Listener.Addr().(*net.TCPAddr).Port,
+			TLS:   true,
+			Raw:   "GET / HTTP/1.1\r\nHost: 127.0.0.1\r\nConnection: close\r\n\r\n",
+			Hello: hello,
+		}
+		c := client.Client{Timeout: time.Second, ReportCertProblems: true}
+		result, err := c.Do(context.Background(), req)
+		if err != nil {
+			t.Errorf("Got unexpected error with hello %s: %v", hello, err)
+		} else if !strings.HasSuffix(result.Resp, "HTTP/1.1") {
+			t.Errorf("Got unexpected response with hello %s: %s", hello, result.Resp)
+		}
+	}
+}
+
+func TestDoWarm(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	}))
+	defer ts.Close()
+	req := client.Request{
+		Host: "127.0.0.1",
+		Port: ts.Listener.Addr().(*net.TCPAddr).Port,
+		TLS:  true,
+		Raw:  "GET / HTTP/1.1\r\nHost: 127.0.0.1\r\nConnection: close\r\n\r\n",
+	}
+	c := client.Client{Timeout: time.Second, ReportCertProblems: true}
+	for _, closed := range []bool{false, true} {
+		warm, err := c.Warm(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if closed {
+			ts.CloseClientConnections()
+			time.Sleep(100 * time.Millisecond)
+		}
+		req.Warm = warm
+		result, err := c.Do(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Got unexpected error with closed=%t: %v", closed, err)
+		}
+		if !strings.HasSuffix(result.Resp, "HTTP/1.1") {
+			t.Errorf("Got unexpected response with closed=%t: %s", closed, result.Resp)
+		}
+		if result.Conn.Warm == closed {
+			t.Errorf("Expected Conn.Warm to be %t", !closed)
+		}
+		if result.CertProblem == nil {
+			t.Errorf("Expected a certificate problem with closed=%t", closed)
+		}
+	}
+}
+
+func TestDoConnector(t *testing.T) {
+	resp := "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+	req := client.Request{
+		Host: "offline.invalid",
+		Port: 80,
+		Raw:  "GET / HTTP/1.1\r\nHost: offline.invalid\r\n\r\n",
+	}
+	received := make(chan string, 1)
+	c := client.Client{
+		Timeout: time.Second,
+		Connector: client.ConnectorFunc(func(ctx context.Context, req client.Request) (net.Conn, error) {
+			conn, server := net.Pipe()
+			go func() {
+				defer server.Close()
+				buf := make([]byte, len(req.Raw))
+				if _, err := io.ReadFull(server, buf); err != nil {
+					return
+				}
+				received <- string(buf)
+				server.Write([]byte(resp))
+			}()
+			return conn, nil
+		}),
+	}
+	result, err := c.Do(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Resp != resp {
+		t.Errorf("Got unexpected response '%s'", result.Resp)
+	}
+	if got := <-received; got != req.Raw {
+		t.Errorf("Server received unexpected request '%s'", got)
+	}
+
+	c.Connector = client.ConnectorFunc(func(ctx context.Context, req client.Request) (net.Conn, error) {
+		return nil, errors.New("no route")
+	})
+	_, err = c.Do(context.Background(), req)
+	var perr *client.PhaseError
+	if !errors.As(err, &perr) || perr.Phase != client.PhaseConnect {
+		t.Errorf("Expected error in phase %s, got: %v", client.PhaseConnect, err)
+	}
+}
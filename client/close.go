@@ -0,0 +1,92 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/codesoap/preq/extractor"
+)
+
+// maxExtraBytes is the maximum number of bytes following a response,
+// that are read while waiting for the server to close the connection.
+const maxExtraBytes = 64 * 1024
+
+// CloseInfo describes the behavior of a connection after a response.
+type CloseInfo struct {
+	// Header is true if the response had a "Connection: close" header.
+	Header bool
+
+	// Persistent is true if the connection may be reused according to
+	// the response. See extractor.Response.
+	Persistent bool
+
+	// Closed is true if the server closed the connection within
+	// Client.CloseWait after the response. It is always false if
+	// CloseWait is zero.
+	Closed bool
+
+	// ClosedAfter is the time between the end of the response and the
+	// close of the connection, if Closed is true.
+	ClosedAfter time.Duration
+
+	// ExtraBytes is the number of bytes received after the response.
+	// Without CloseWait, only bytes that arrived together with the
+	// response are counted.
+	ExtraBytes int
+}
+
+// closeInfo describes the connection conn after resp has been extracted
+// from reader. If c.CloseWait is not zero, up to c.CloseWait is spent
+// waiting for the server to close the connection, but not longer than
+// the deadline of ctx.
+func (c *Client) closeInfo(ctx context.Context, conn net.Conn, reader *bufio.Reader, resp extractor.Response) *CloseInfo {
+	info := &CloseInfo{Persistent: resp.Persistent}
+	for _, field := range resp.Header {
+		if strings.EqualFold(field.Name, "Connection") && hasToken(field.Value, "close") {
+			info.Header = true
+		}
+	}
+	info.ExtraBytes = reader.Buffered()
+	if c.CloseWait <= 0 {
+		return info
+	}
+	start := time.Now()
+	deadline := start.Add(c.CloseWait)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return info
+	}
+	reader.Discard(info.ExtraBytes)
+	for info.ExtraBytes < maxExtraBytes {
+		_, err := reader.Peek(1)
+		if err != nil {
+			info.Closed = errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET)
+			if info.Closed {
+				info.ClosedAfter = time.Since(start)
+			}
+			return info
+		}
+		n, _ := reader.Discard(reader.Buffered())
+		info.ExtraBytes += n
+	}
+	return info
+}
+
+// hasToken returns true if the comma separated list contains token,
+// ignoring case.
+func hasToken(list, token string) bool {
+	for _, t := range strings.Split(list, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,274 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// getConn resolves the host of req, connects to it, possibly through a
+// proxy or Client.Connector, and performs the TLS handshake, if
+// necessary. Returned errors are *PhaseErrors. If problem is not nil,
+// certificate problems are stored there instead of failing the
+// handshake.
+func (c *Client) getConn(ctx context.Context, req Request, problem *CertProblem) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if c.Connector != nil {
+		conn, err = c.dialConnector(ctx, req, c.Connector)
+	} else if proxy := c.proxy(req); proxy != nil {
+		conn, err = c.dialProxy(ctx, req, proxy)
+	} else {
+		conn, err = c.dialDirect(ctx, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !req.TLS {
+		return conn, nil
+	}
+	var tlsConn net.Conn
+	if req.Hello != "" {
+		tlsConn, err = handshakeUTLS(ctx, conn, c.tlsConfig(req, nil), req.Hello, problem)
+	} else {
+		stdConn := tls.Client(conn, c.tlsConfig(req, problem))
+		tlsConn, err = stdConn, stdConn.HandshakeContext(ctx)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, &PhaseError{PhaseTLS, err}
+	}
+	state, _ := tlsState(tlsConn)
+	c.logf(ctx, 1, "TLS handshake done")
+	c.logf(ctx, 2, "TLS version %s, cipher suite %s, ALPN protocol '%s'",
+		tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite), state.NegotiatedProtocol)
+	if req.HTTP2 && state.NegotiatedProtocol != "h2" {
+		tlsConn.Close()
+		return nil, &PhaseError{PhaseTLS, ErrNoHTTP2}
+	}
+	return tlsConn, nil
+}
+
+// dialDirect resolves the host of req and connects to it. Returned
+// errors are *PhaseErrors.
+func (c *Client) dialDirect(ctx context.Context, req Request) (net.Conn, error) {
+	ips, err := resolve(ctx, req)
+	if err != nil {
+		return nil, &PhaseError{PhaseDNS, err}
+	}
+	c.logf(ctx, 1, "resolved %s to %v", req.Host, ips)
+	conn, err := c.dialAny(ctx, req, ips)
+	if err != nil {
+		return nil, &PhaseError{PhaseConnect, err}
+	}
+	c.logf(ctx, 1, "connected to %s", conn.RemoteAddr())
+	return c.throttle(ctx, conn, req), nil
+}
+
+// tlsState returns the TLS connection state of conn, if it is a TLS
+// connection.
+func tlsState(conn net.Conn) (tls.ConnectionState, bool) {
+	switch conn := conn.(type) {
+	case *tls.Conn:
+		return conn.ConnectionState(), true
+	case *utlsConn:
+		return conn.state, true
+	}
+	return tls.ConnectionState{}, false
+}
+
+// resolve returns the IP addresses of req.Host. If req.Addresses is
+// set, they are used instead of doing a DNS lookup.
+func resolve(ctx context.Context, req Request) ([]net.IP, error) {
+	if len(req.Addresses) > 0 {
+		ips := make([]net.IP, len(req.Addresses))
+		for i, addr := range req.Addresses {
+			if ips[i] = net.ParseIP(addr); ips[i] == nil {
+				return nil, fmt.Errorf("invalid IP address '%s'", addr)
+			}
+		}
+		return ips, nil
+	}
+	if ip := net.ParseIP(req.Host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, req.Host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	return ips, nil
+}
+
+// defaultFallbackDelay is the Connection Attempt Delay recommended by
+// RFC 8305.
+const defaultFallbackDelay = 250 * time.Millisecond
+
+// dialAny connects to the first reachable IP of ips. If ips contains
+// IPv4 and IPv6 addresses, the attempts race like RFC 8305 describes.
+// Otherwise they are made one after another and, like net.Dialer does,
+// the remaining time until the deadline of ctx is spread over the
+// addresses, so that a single unreachable address does not use up all
+// the time.
+func (c *Client) dialAny(ctx context.Context, req Request, ips []net.IP) (net.Conn, error) {
+	if c.FallbackDelay >= 0 && mixedFamilies(ips) {
+		return c.dialRacing(ctx, req, interleaveFamilies(ips))
+	}
+	var err error
+	for i, ip := range ips {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if deadline, ok := ctx.Deadline(); ok {
+			attemptCtx, cancel = context.WithDeadline(ctx, partialDeadline(deadline, len(ips)-i))
+		}
+		var conn net.Conn
+		conn, err = c.dial(attemptCtx, req, ip)
+		cancel()
+		if err == nil {
+			return conn, nil
+		}
+		c.logf(ctx, 2, "could not connect to %s: %v", ip, err)
+	}
+	return nil, err
+}
+
+// dialRacing connects to the first reachable IP of ips. An attempt is
+// started every FallbackDelay, or as soon as the previous one failed,
+// while the earlier attempts keep running. The first established
+// connection is used and the others are closed.
+func (c *Client) dialRacing(ctx context.Context, req Request, ips []net.IP) (net.Conn, error) {
+	delay := c.FallbackDelay
+	if delay == 0 {
+		delay = defaultFallbackDelay
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	attempts := make(chan dialAttempt, len(ips))
+	next, pending := 0, 0
+	start := func() {
+		ip := ips[next]
+		next++
+		pending++
+		go func() {
+			conn, err := c.dial(ctx, req, ip)
+			attempts <- dialAttempt{ip, conn, err}
+		}()
+	}
+	start()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	var err error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			start()
+		case a := <-attempts:
+			pending--
+			if a.err == nil {
+				go closeAttempts(attempts, pending)
+				return a.conn, nil
+			}
+			err = a.err
+			c.logf(ctx, 2, "could not connect to %s: %v", a.ip, err)
+			if next == len(ips) {
+				continue
+			}
+			if !timer.Stop() {
+				<-timer.C
+			}
+			start()
+		}
+		if next < len(ips) {
+			timer.Reset(delay)
+		}
+	}
+	return nil, err
+}
+
+type dialAttempt struct {
+	ip   net.IP
+	conn net.Conn
+	err  error
+}
+
+// closeAttempts closes the connections of the n attempts, which are
+// still pending after another one succeeded.
+func closeAttempts(attempts <-chan dialAttempt, n int) {
+	for ; n > 0; n-- {
+		if a := <-attempts; a.err == nil {
+			a.conn.Close()
+		}
+	}
+}
+
+// dial connects to ip.
+func (c *Client) dial(ctx context.Context, req Request, ip net.IP) (net.Conn, error) {
+	var dialer net.Dialer
+	if req.Dialer != nil {
+		dialer = *req.Dialer
+	} else if c.Dialer != nil {
+		dialer = *c.Dialer
+	}
+	addr := net.JoinHostPort(ip.String(), strconv.Itoa(req.Port))
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err == nil && c.Nagle {
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			if err = tcpConn.SetNoDelay(false); err != nil {
+				conn.Close()
+			}
+		}
+	}
+	return conn, err
+}
+
+// mixedFamilies returns whether ips contains IPv4 and IPv6 addresses.
+func mixedFamilies(ips []net.IP) bool {
+	for _, ip := range ips[1:] {
+		if (ip.To4() == nil) != (ips[0].To4() == nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// interleaveFamilies returns ips with the IPv4 and IPv6 addresses
+// alternating, starting with the family of the first address, as
+// RFC 8305 recommends.
+func interleaveFamilies(ips []net.IP) []net.IP {
+	var first, second []net.IP
+	for _, ip := range ips {
+		if (ip.To4() == nil) == (ips[0].To4() == nil) {
+			first = append(first, ip)
+		} else {
+			second = append(second, ip)
+		}
+	}
+	interleaved := make([]net.IP, 0, len(ips))
+	for i := 0; i < max(len(first), len(second)); i++ {
+		if i < len(first) {
+			interleaved = append(interleaved, first[i])
+		}
+		if i < len(second) {
+			interleaved = append(interleaved, second[i])
+		}
+	}
+	return interleaved
+}
+
+// partialDeadline returns the deadline for one of addrsRemaining
+// connection attempts, which should not take less than two seconds,
+// if possible.
+func partialDeadline(deadline time.Time, addrsRemaining int) time.Time {
+	const minAttemptTime = 2 * time.Second
+	remaining := time.Until(deadline)
+	attemptTime := remaining / time.Duration(addrsRemaining)
+	if attemptTime < minAttemptTime {
+		attemptTime = min(minAttemptTime, remaining)
+	}
+	return time.Now().Add(attemptTime)
+}
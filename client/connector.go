@@ -0,0 +1,42 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// Connector establishes the connections for requests. It can be used to
+// connect through custom proxies or anonymity networks, or to serve
+// requests from test doubles, while the client still performs the TLS
+// handshake, writes the requests and extracts the responses.
+type Connector interface {
+	// Connect returns a connection to the server of req. req.Addresses,
+	// if set, are the addresses to connect to instead of the ones of
+	// req.Host. Errors, that are not *PhaseErrors, are reported in
+	// PhaseConnect.
+	Connect(ctx context.Context, req Request) (net.Conn, error)
+}
+
+// ConnectorFunc adapts a function to the Connector interface.
+type ConnectorFunc func(ctx context.Context, req Request) (net.Conn, error)
+
+// Connect calls f(ctx, req).
+func (f ConnectorFunc) Connect(ctx context.Context, req Request) (net.Conn, error) {
+	return f(ctx, req)
+}
+
+// dialConnector connects to the server of req using connector. Returned
+// errors are *PhaseErrors.
+func (c *Client) dialConnector(ctx context.Context, req Request, connector Connector) (net.Conn, error) {
+	conn, err := connector.Connect(ctx, req)
+	if err != nil {
+		var perr *PhaseError
+		if !errors.As(err, &perr) {
+			err = &PhaseError{PhaseConnect, err}
+		}
+		return nil, err
+	}
+	c.logf(ctx, 1, "connected to %s", conn.RemoteAddr())
+	return c.throttle(ctx, conn, req), nil
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/codesoap/preq/extractor"
+)
+
+// sendBody writes req.Body to conn after the server answered with 100
+// Continue or req.BodyDelay elapsed. Interim responses are stored in
+// result. If the server sends a final response instead, it is returned
+// and the body is not written. Returned errors are *PhaseErrors.
+func (c *Client) sendBody(ctx context.Context, conn net.Conn, reader *bufio.Reader, req Request, result *Result) (*extractor.Response, error) {
+	deadline, _ := ctx.Deadline()
+	for {
+		if req.BodyDelay > 0 {
+			delayed := time.Now().Add(req.BodyDelay)
+			if deadline.IsZero() || delayed.Before(deadline) {
+				conn.SetReadDeadline(delayed)
+			}
+		}
+		_, err := reader.Peek(1)
+		conn.SetReadDeadline(deadline)
+		if ctx.Err() != nil {
+			// The deadline set to unblock reads has just been overwritten.
+			conn.SetReadDeadline(time.Unix(1, 0))
+		}
+		if err != nil {
+			if req.BodyDelay > 0 && errors.Is(err, os.ErrDeadlineExceeded) && ctx.Err() == nil {
+				c.logf(ctx, 2, "got no response within %v", req.BodyDelay)
+				break
+			}
+			return nil, &PhaseError{PhaseHead, err}
+		}
+		resp, err := extractor.ExtractResponseFull(reader, extractor.Options{
+			Method:      method(req.Raw),
+			Limits:      extractor.DefaultLimits,
+			Strict:      c.Strict,
+			Conformance: c.Conformance,
+			Interim:     true,
+		})
+		if err != nil {
+			return &resp, extractionError(err)
+		}
+		if resp.StatusCode < 100 || resp.StatusCode >= 200 || resp.StatusCode == 101 {
+			return &resp, nil
+		}
+		c.logf(ctx, 2, "got interim response with status %d", resp.StatusCode)
+		result.Interim += resp.Raw
+		if resp.StatusCode == 100 {
+			break
+		}
+	}
+	written, err := io.WriteString(conn, req.Body)
+	c.logf(ctx, 1, "wrote %d bytes of body", written)
+	if c.CaptureRaw {
+		result.RawRequest = append(result.RawRequest, req.Body[:written]...)
+	}
+	if err != nil {
+		return nil, &PhaseError{PhaseWrite, err}
+	}
+	result.BodySent = true
+	return nil, nil
+}
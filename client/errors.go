@@ -0,0 +1,38 @@
+package client
+
+import "errors"
+
+// ErrPipelineBroken is the error for pipelined requests, whose response
+// could not be read, because a previous response could not be
+// extracted.
+var ErrPipelineBroken = errors.New("a previous response of the pipeline could not be extracted")
+
+// ErrNoHTTP2 is the error for HTTP/2 requests to servers, that did not
+// agree on HTTP/2 during the TLS handshake.
+var ErrNoHTTP2 = errors.New("server does not support HTTP/2")
+
+// Phases of a request, in which an error may occur.
+const (
+	PhaseDNS     = "dns"
+	PhaseConnect = "connect"
+	PhaseProxy   = "proxy"
+	PhaseTLS     = "tls"
+	PhaseWrite   = "write"
+	PhaseHead    = "header read"
+	PhaseBody    = "body read"
+)
+
+// PhaseError is an error that occurred during a specific phase of a
+// request.
+type PhaseError struct {
+	Phase string
+	Err   error
+}
+
+func (e *PhaseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PhaseError) Unwrap() error {
+	return e.Err
+}
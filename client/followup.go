@@ -0,0 +1,54 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"time"
+
+	"github.com/codesoap/preq/extractor"
+)
+
+// doFollowUp sends the follow-up request of req, that is built from the
+// response in result, on conn and stores its outcome in result.
+func (c *Client) doFollowUp(ctx context.Context, conn net.Conn, reader *bufio.Reader, timedConn *timedReader, req Request, result *Result) {
+	raw, err := req.FollowUp(result.Resp)
+	if err != nil {
+		result.FollowUpErr = err
+		return
+	} else if raw == "" {
+		return
+	}
+	next := &Result{Conn: result.Conn}
+	result.FollowUp = next
+	written, err := c.write(ctx, conn, raw, nil)
+	c.logf(ctx, 1, "wrote %d bytes of follow-up request", written)
+	if c.CaptureRaw {
+		next.RawRequest = []byte(raw[:written])
+	}
+	if err != nil {
+		result.FollowUpErr = &PhaseError{PhaseWrite, err}
+		return
+	}
+	next.ReqAt = time.Now()
+	timedConn.readAt = time.Time{}
+	resp, err := extractor.ExtractResponseFull(reader, extractor.Options{
+		Method:      method(raw),
+		Limits:      extractor.DefaultLimits,
+		Strict:      c.Strict,
+		Conformance: c.Conformance,
+	})
+	next.Resp, next.Laxities, next.Violations = resp.Raw, resp.Laxities, resp.Violations
+	next.Chunks = chunkInfo(resp)
+	next.Framing, next.FramingRule = resp.Framing, resp.FramingRule
+	if !timedConn.readAt.IsZero() {
+		next.Ping = timedConn.readAt.Sub(next.ReqAt)
+	}
+	next.Duration = time.Since(next.ReqAt)
+	c.logf(ctx, 1, "extracted %d bytes of follow-up response", len(resp.Raw))
+	if err != nil {
+		result.FollowUpErr = extractionError(err)
+		return
+	}
+	next.Close = c.closeInfo(ctx, conn, reader, resp)
+}
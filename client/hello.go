@@ -0,0 +1,118 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"slices"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// helloIDs maps the names of the supported ClientHello fingerprints to
+// their uTLS IDs.
+var helloIDs = map[string]utls.ClientHelloID{
+	"android":    utls.HelloAndroid_11_OkHttp,
+	"chrome":     utls.HelloChrome_Auto,
+	"edge":       utls.HelloEdge_Auto,
+	"firefox":    utls.HelloFirefox_Auto,
+	"ios":        utls.HelloIOS_Auto,
+	"randomized": utls.HelloRandomized,
+	"safari":     utls.HelloSafari_Auto,
+}
+
+// Hellos returns the names of the ClientHello fingerprints, which can be
+// used for Request.Hello, in alphabetical order.
+func Hellos() []string {
+	names := make([]string, 0, len(helloIDs))
+	for name := range helloIDs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// handshakeUTLS performs the TLS handshake on conn using uTLS, which
+// mimics the ClientHello of the browser hello. Only the server name, the
+// root CAs, the client certificates, the versions and the ALPN protocols
+// of conf are used. The ALPN extension of the mimicked ClientHello offers
+// "http/1.1", if conf.NextProtos is empty, because the browsers would
+// offer "h2" as well.
+func handshakeUTLS(ctx context.Context, conn net.Conn, conf *tls.Config, hello string, problem *CertProblem) (net.Conn, error) {
+	id, ok := helloIDs[hello]
+	if !ok {
+		return nil, fmt.Errorf("unknown ClientHello '%s'", hello)
+	}
+	nextProtos := conf.NextProtos
+	if len(nextProtos) == 0 {
+		nextProtos = []string{"http/1.1"}
+	}
+	uconf := &utls.Config{
+		ServerName:         conf.ServerName,
+		RootCAs:            conf.RootCAs,
+		InsecureSkipVerify: true, // Verified below, like crypto/tls would.
+		MinVersion:         conf.MinVersion,
+		MaxVersion:         conf.MaxVersion,
+		NextProtos:         nextProtos,
+	}
+	for _, cert := range conf.Certificates {
+		uconf.Certificates = append(uconf.Certificates, utls.Certificate{
+			Certificate: cert.Certificate,
+			PrivateKey:  cert.PrivateKey,
+			Leaf:        cert.Leaf,
+		})
+	}
+	var uconn *utls.UConn
+	if id == utls.HelloRandomized {
+		uconn = utls.UClient(conn, uconf, utls.HelloRandomizedALPN)
+	} else {
+		spec, err := utls.UTLSIdToSpec(id)
+		if err != nil {
+			return nil, err
+		}
+		for _, ext := range spec.Extensions {
+			if alpn, ok := ext.(*utls.ALPNExtension); ok {
+				alpn.AlpnProtocols = nextProtos
+			}
+		}
+		uconn = utls.UClient(conn, uconf, utls.HelloCustom)
+		if err = uconn.ApplyPreset(&spec); err != nil {
+			return nil, err
+		}
+	}
+	if err := uconn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	state := toTLSState(uconn.ConnectionState())
+	if err := verifyCert(state, conf.RootCAs); err != nil {
+		if problem != nil {
+			*problem = CertProblem{Reason: certErrReason(err), Err: err}
+		} else if !conf.InsecureSkipVerify {
+			return nil, err
+		}
+	}
+	return &utlsConn{uconn, state}, nil
+}
+
+// utlsConn is a connection established by uTLS. Its connection state
+// is available in the format of crypto/tls.
+type utlsConn struct {
+	*utls.UConn
+	state tls.ConnectionState
+}
+
+func toTLSState(s utls.ConnectionState) tls.ConnectionState {
+	return tls.ConnectionState{
+		Version:                     s.Version,
+		HandshakeComplete:           s.HandshakeComplete,
+		DidResume:                   s.DidResume,
+		CipherSuite:                 s.CipherSuite,
+		NegotiatedProtocol:          s.NegotiatedProtocol,
+		ServerName:                  s.ServerName,
+		PeerCertificates:            s.PeerCertificates,
+		VerifiedChains:              s.VerifiedChains,
+		SignedCertificateTimestamps: s.SignedCertificateTimestamps,
+		OCSPResponse:                s.OCSPResponse,
+	}
+}
@@ -0,0 +1,420 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codesoap/preq/extractor"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// HTTP2Info describes the HTTP/2 stream of a request.
+type HTTP2Info struct {
+	StreamID uint32
+
+	// Settings are the settings sent by the server, by name.
+	Settings map[string]uint32
+
+	// Frames counts the received frames by type.
+	Frames map[string]int
+
+	Trailers []extractor.Field
+
+	// RSTCode and GoAwayCode are the error codes of RST_STREAM and GOAWAY
+	// frames received from the server, if any.
+	RSTCode    string
+	GoAwayCode string
+}
+
+// h2Request is a raw request translated to HTTP/2.
+type h2Request struct {
+	header []hpack.HeaderField // Including the pseudo-header fields.
+	body   []byte
+}
+
+// connectionHeaders are the header fields that are specific to HTTP/1
+// connections and must not be sent with HTTP/2.
+var connectionHeaders = map[string]bool{
+	"connection":        true,
+	"host":              true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+// translateRequest translates the raw HTTP/1 request of req to HTTP/2.
+// The order of the header fields is kept.
+func translateRequest(req Request) (h2Request, error) {
+	var h2req h2Request
+	r := bufio.NewReader(strings.NewReader(req.Raw))
+	requestLine, err := r.ReadString('\n')
+	if err != nil {
+		return h2req, fmt.Errorf("could not read request line: %w", err)
+	}
+	fields := strings.Fields(requestLine)
+	if len(fields) != 3 {
+		return h2req, fmt.Errorf("invalid request line '%s'", strings.TrimSpace(requestLine))
+	}
+	method, target := fields[0], fields[1]
+	var header []hpack.HeaderField
+	var authority string
+	var chunked bool
+	contentLength := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return h2req, fmt.Errorf("could not read header: %w", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			return h2req, fmt.Errorf("invalid header line '%s'", line)
+		}
+		name, value = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(value)
+		switch name {
+		case "host":
+			authority = value
+		case "transfer-encoding":
+			chunked = strings.EqualFold(value, "chunked")
+		case "content-length":
+			if contentLength, err = strconv.Atoi(value); err != nil {
+				return h2req, fmt.Errorf("invalid Content-Length '%s'", value)
+			}
+		case "te":
+			if !strings.EqualFold(value, "trailers") {
+				continue
+			}
+		}
+		if !connectionHeaders[name] {
+			header = append(header, hpack.HeaderField{Name: name, Value: value})
+		}
+	}
+	if chunked {
+		h2req.body, err = io.ReadAll(httputil.NewChunkedReader(r))
+	} else if contentLength >= 0 {
+		h2req.body = make([]byte, contentLength)
+		_, err = io.ReadFull(r, h2req.body)
+	}
+	if err != nil {
+		return h2req, fmt.Errorf("could not read body: %w", err)
+	}
+
+	scheme := "http"
+	if req.TLS {
+		scheme = "https"
+	}
+	path := target
+	if u, err := url.Parse(target); err == nil && u.IsAbs() {
+		scheme, authority, path = u.Scheme, u.Host, u.RequestURI()
+	}
+	if authority == "" {
+		authority = req.Host
+		if req.TLS && req.Port != 443 || !req.TLS && req.Port != 80 {
+			authority = net.JoinHostPort(req.Host, strconv.Itoa(req.Port))
+		}
+	}
+	h2req.header = []hpack.HeaderField{{Name: ":method", Value: method}}
+	if method == "CONNECT" {
+		h2req.header = append(h2req.header, hpack.HeaderField{Name: ":authority", Value: target})
+	} else {
+		h2req.header = append(h2req.header,
+			hpack.HeaderField{Name: ":scheme", Value: scheme},
+			hpack.HeaderField{Name: ":authority", Value: authority},
+			hpack.HeaderField{Name: ":path", Value: path})
+	}
+	h2req.header = append(h2req.header, header...)
+	return h2req, nil
+}
+
+// h2Exchange holds the state of a request on an HTTP/2 connection.
+type h2Exchange struct {
+	w     io.Writer
+	fr    *http2.Framer
+	info  *HTTP2Info
+	resp  strings.Builder // The response in HTTP/1 like textual form.
+	body  []byte          // The part of the request body, that is yet to be sent.
+	reqAt time.Time
+	ping  time.Duration
+	final bool // Whether the final response head was received.
+	ended bool // Whether the server ended the stream.
+
+	connWindow, streamWindow int64 // The flow-control windows for sending.
+	maxFrameSize             int
+}
+
+const h2StreamID = 1
+
+// doHTTP2 makes req on conn, which must be ready for HTTP/2 frames,
+// unless the h2c upgrade is used. The fields of result are filled as far
+// as possible.
+func (c *Client) doHTTP2(conn net.Conn, req Request, result *Result) error {
+	var w io.Writer = conn
+	timedConn := &timedReader{r: conn}
+	if c.CaptureRaw {
+		var rawRequest bytes.Buffer
+		w = io.MultiWriter(conn, &rawRequest)
+		defer func() { result.RawRequest = rawRequest.Bytes() }()
+		timedConn.raw = &bytes.Buffer{}
+		defer func() { result.RawResponse = timedConn.raw.Bytes() }()
+	}
+	if req.H2CUpgrade && !req.TLS {
+		return c.upgradeH2C(w, timedConn, req, result)
+	}
+	h2req, err := translateRequest(req)
+	if err != nil {
+		return &PhaseError{PhaseWrite, fmt.Errorf("could not translate request to HTTP/2: %w", err)}
+	}
+	e := newH2Exchange(w, timedConn, result)
+	e.body = h2req.body
+	if err = e.writePreface(); err == nil {
+		err = e.writeHeaders(h2req)
+	}
+	if err != nil {
+		return &PhaseError{PhaseWrite, err}
+	}
+	result.ReqAt = e.reqAt
+	return e.finish(result)
+}
+
+// h2cSettings is the base64url encoded payload of the SETTINGS frame,
+// which is sent as the HTTP2-Settings header field during the h2c
+// upgrade. It disables server push.
+const h2cSettings = "AAIAAAAA"
+
+// upgradeH2C sends req as HTTP/1.1 request with an "Upgrade: h2c" header
+// to w. If the server switches to HTTP/2, the response is read from
+// stream 1. Otherwise the HTTP/1.1 response is stored in result.
+func (c *Client) upgradeH2C(w io.Writer, r *timedReader, req Request, result *Result) error {
+	requestLine, rest, _ := strings.Cut(req.Raw, "\n")
+	raw := requestLine + "\n" +
+		"Connection: Upgrade, HTTP2-Settings\r\n" +
+		"Upgrade: h2c\r\n" +
+		"HTTP2-Settings: " + h2cSettings + "\r\n" + rest
+	if _, err := io.WriteString(w, raw); err != nil {
+		return &PhaseError{PhaseWrite, err}
+	}
+	result.ReqAt = time.Now()
+	reader := bufio.NewReader(r)
+	resp, err := extractor.ExtractResponseFull(reader, extractor.Options{
+		Method:      method(req.Raw),
+		Limits:      extractor.DefaultLimits,
+		Strict:      c.Strict,
+		Conformance: c.Conformance,
+	})
+	result.Resp, result.Laxities, result.Violations = resp.Raw, resp.Laxities, resp.Violations
+	if !r.readAt.IsZero() {
+		result.Ping = r.readAt.Sub(result.ReqAt)
+	}
+	if err != nil {
+		return extractionError(err)
+	} else if resp.StatusCode != 101 {
+		return nil
+	}
+	e := newH2Exchange(w, reader, result)
+	e.reqAt, e.ping = result.ReqAt, result.Ping
+	e.resp.WriteString(resp.Raw)
+	if err = e.writePreface(); err != nil {
+		return &PhaseError{PhaseWrite, err}
+	}
+	return e.finish(result)
+}
+
+func newH2Exchange(w io.Writer, r io.Reader, result *Result) *h2Exchange {
+	e := &h2Exchange{
+		w:            w,
+		fr:           http2.NewFramer(w, r),
+		info:         &HTTP2Info{StreamID: h2StreamID, Settings: map[string]uint32{}, Frames: map[string]int{}},
+		connWindow:   65535,
+		streamWindow: 65535,
+		maxFrameSize: 16384,
+	}
+	e.fr.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	e.fr.MaxHeaderListSize = uint32(extractor.DefaultLimits.MaxHeadSize)
+	result.HTTP2 = e.info
+	return e
+}
+
+// finish reads the response and stores it in result.
+func (e *h2Exchange) finish(result *Result) error {
+	err := e.run()
+	result.Resp, result.Ping = e.resp.String(), e.ping
+	if err != nil {
+		if !e.final {
+			return &PhaseError{PhaseHead, err}
+		}
+		return &PhaseError{PhaseBody, err}
+	}
+	return nil
+}
+
+// writePreface writes the connection preface, including the SETTINGS
+// frame.
+func (e *h2Exchange) writePreface() error {
+	if _, err := io.WriteString(e.w, http2.ClientPreface); err != nil {
+		return err
+	}
+	return e.fr.WriteSettings(http2.Setting{ID: http2.SettingEnablePush, Val: 0})
+}
+
+// writeHeaders writes the head of h2req.
+func (e *h2Exchange) writeHeaders(h2req h2Request) error {
+	var block bytes.Buffer
+	enc := hpack.NewEncoder(&block)
+	for _, field := range h2req.header {
+		if err := enc.WriteField(field); err != nil {
+			return err
+		}
+	}
+	fragment := block.Bytes()
+	first := fragment[:min(len(fragment), e.maxFrameSize)]
+	fragment = fragment[len(first):]
+	err := e.fr.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      h2StreamID,
+		BlockFragment: first,
+		EndStream:     len(h2req.body) == 0,
+		EndHeaders:    len(fragment) == 0,
+	})
+	for err == nil && len(fragment) > 0 {
+		next := fragment[:min(len(fragment), e.maxFrameSize)]
+		fragment = fragment[len(next):]
+		err = e.fr.WriteContinuation(h2StreamID, len(fragment) == 0, next)
+	}
+	e.reqAt = time.Now()
+	return err
+}
+
+// run sends the request body and reads frames until the response is
+// complete.
+func (e *h2Exchange) run() error {
+	for !e.ended {
+		if err := e.sendBody(); err != nil {
+			return fmt.Errorf("could not send body: %w", err)
+		}
+		f, err := e.fr.ReadFrame()
+		if err != nil {
+			return err
+		}
+		e.info.Frames[f.Header().Type.String()]++
+		if err = e.handle(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendBody sends as much of the remaining body as flow control allows.
+func (e *h2Exchange) sendBody() error {
+	for len(e.body) > 0 {
+		n := int(min(int64(len(e.body)), int64(e.maxFrameSize), e.connWindow, e.streamWindow))
+		if n <= 0 {
+			return nil
+		}
+		if err := e.fr.WriteData(h2StreamID, n == len(e.body), e.body[:n]); err != nil {
+			return err
+		}
+		e.body = e.body[n:]
+		e.connWindow -= int64(n)
+		e.streamWindow -= int64(n)
+	}
+	return nil
+}
+
+func (e *h2Exchange) handle(f http2.Frame) error {
+	switch f := f.(type) {
+	case *http2.SettingsFrame:
+		if f.IsAck() {
+			return nil
+		}
+		f.ForeachSetting(func(s http2.Setting) error {
+			e.info.Settings[s.ID.String()] = s.Val
+			switch s.ID {
+			case http2.SettingInitialWindowSize:
+				e.streamWindow += int64(s.Val) - 65535
+			case http2.SettingMaxFrameSize:
+				e.maxFrameSize = int(s.Val)
+			}
+			return nil
+		})
+		return e.fr.WriteSettingsAck()
+	case *http2.WindowUpdateFrame:
+		if f.StreamID == 0 {
+			e.connWindow += int64(f.Increment)
+		} else if f.StreamID == h2StreamID {
+			e.streamWindow += int64(f.Increment)
+		}
+	case *http2.PingFrame:
+		if !f.IsAck() {
+			return e.fr.WritePing(true, f.Data)
+		}
+	case *http2.GoAwayFrame:
+		e.info.GoAwayCode = f.ErrCode.String()
+		if f.LastStreamID < h2StreamID {
+			return fmt.Errorf("server sent GOAWAY with error code %s", f.ErrCode)
+		}
+	case *http2.RSTStreamFrame:
+		if f.StreamID == h2StreamID {
+			e.info.RSTCode = f.ErrCode.String()
+			return fmt.Errorf("server reset stream with error code %s", f.ErrCode)
+		}
+	case *http2.MetaHeadersFrame:
+		if f.StreamID != h2StreamID {
+			return nil
+		}
+		e.receivedStreamData()
+		if f.Truncated {
+			return extractor.ErrHeadTooLarge
+		}
+		if e.final {
+			for _, field := range f.RegularFields() {
+				e.info.Trailers = append(e.info.Trailers, extractor.Field{Name: field.Name, Value: field.Value})
+			}
+		} else {
+			status := f.PseudoValue("status")
+			fmt.Fprintf(&e.resp, "HTTP/2 %s\r\n", status)
+			for _, field := range f.RegularFields() {
+				fmt.Fprintf(&e.resp, "%s: %s\r\n", field.Name, field.Value)
+			}
+			e.resp.WriteString("\r\n")
+			e.final = !strings.HasPrefix(status, "1")
+		}
+		e.ended = f.StreamEnded()
+	case *http2.DataFrame:
+		if f.StreamID != h2StreamID {
+			return nil
+		}
+		e.receivedStreamData()
+		if !e.final {
+			return errors.New("received DATA frame before the response head")
+		}
+		e.resp.Write(f.Data())
+		e.ended = f.StreamEnded()
+		if n := uint32(f.Length); n > 0 && !e.ended {
+			// Keep the flow-control windows open; the body is read anyway.
+			if err := e.fr.WriteWindowUpdate(0, n); err != nil {
+				return err
+			}
+			return e.fr.WriteWindowUpdate(h2StreamID, n)
+		}
+	}
+	return nil
+}
+
+func (e *h2Exchange) receivedStreamData() {
+	if e.ping == 0 {
+		e.ping = time.Since(e.reqAt)
+	}
+}
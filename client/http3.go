@@ -0,0 +1,165 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codesoap/preq/extractor"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// HTTP3Info describes the QUIC connection of an HTTP/3 request.
+type HTTP3Info struct {
+	QUICVersion string
+	Used0RTT    bool
+	Trailers    []extractor.Field
+}
+
+// doHTTP3 makes req using HTTP/3 over QUIC. The fields of result are
+// filled as far as possible. HTTP/3 support is experimental.
+func (c *Client) doHTTP3(ctx context.Context, req Request, problem *CertProblem, result *Result) (err error) {
+	defer func() {
+		var perr *PhaseError
+		if errors.As(err, &perr) && errors.Is(ctx.Err(), context.Canceled) {
+			err = &PhaseError{perr.Phase, fmt.Errorf("%w: %v", context.Canceled, perr.Err)}
+		}
+	}()
+	if !req.TLS {
+		return &PhaseError{PhaseConnect, errors.New("HTTP/3 requires TLS")}
+	}
+	h2req, err := translateRequest(req)
+	if err != nil {
+		return &PhaseError{PhaseWrite, fmt.Errorf("could not translate request to HTTP/3: %w", err)}
+	}
+	ips, err := resolve(ctx, req)
+	if err != nil {
+		return &PhaseError{PhaseDNS, err}
+	}
+	c.logf(ctx, 1, "resolved %s to %v", req.Host, ips)
+	tlsConf := c.tlsConfig(req, problem)
+	if len(req.ALPN) == 0 {
+		tlsConf.NextProtos = []string{http3.NextProtoH3}
+	}
+	var conn quic.Connection
+	for _, ip := range ips {
+		addr := net.JoinHostPort(ip.String(), strconv.Itoa(req.Port))
+		if conn, err = quic.DialAddr(ctx, addr, tlsConf, &quic.Config{}); err == nil {
+			break
+		}
+		c.logf(ctx, 2, "could not connect to %s: %v", ip, err)
+	}
+	if problem != nil && problem.Err != nil {
+		result.CertProblem = problem
+	}
+	if err != nil {
+		c.logf(ctx, 1, "could not connect: %v", err)
+		return &PhaseError{PhaseConnect, err}
+	}
+	state := conn.ConnectionState()
+	c.logf(ctx, 1, "QUIC connection to %s established", conn.RemoteAddr())
+	defer conn.CloseWithError(0, "")
+	result.Conn = &ConnInfo{LocalAddr: conn.LocalAddr(), RemoteAddr: conn.RemoteAddr(), TLS: &state.TLS}
+	info := &HTTP3Info{QUICVersion: state.Version.String(), Used0RTT: state.Used0RTT}
+	result.HTTP3 = info
+
+	httpReq, err := toHTTPRequest(ctx, h2req)
+	if err != nil {
+		return &PhaseError{PhaseWrite, fmt.Errorf("could not translate request to HTTP/3: %w", err)}
+	}
+	if c.CaptureRaw {
+		result.RawRequest = []byte(req.Raw)
+	}
+	rt := &http3.SingleDestinationRoundTripper{
+		Connection:             conn,
+		DisableCompression:     true,
+		MaxResponseHeaderBytes: int64(extractor.DefaultLimits.MaxHeadSize),
+	}
+	result.ReqAt = time.Now()
+	resp, err := rt.RoundTrip(httpReq)
+	if err != nil {
+		return &PhaseError{PhaseHead, err}
+	}
+	defer resp.Body.Close()
+	result.Ping = time.Since(result.ReqAt)
+	var out strings.Builder
+	fmt.Fprintf(&out, "HTTP/3 %d\r\n", resp.StatusCode)
+	writeHeader(&out, resp.Header)
+	out.WriteString("\r\n")
+	_, err = io.Copy(&out, resp.Body)
+	result.Resp = out.String()
+	c.logf(ctx, 1, "received %d bytes of HTTP/3 response", out.Len())
+	if c.CaptureRaw {
+		result.RawResponse = []byte(result.Resp)
+	}
+	if err != nil {
+		return &PhaseError{PhaseBody, err}
+	}
+	for _, name := range sortedHeaderNames(resp.Trailer) {
+		for _, value := range resp.Trailer[name] {
+			info.Trailers = append(info.Trailers, extractor.Field{Name: strings.ToLower(name), Value: value})
+		}
+	}
+	return nil
+}
+
+// toHTTPRequest converts h2req, whose header contains the pseudo-header
+// fields of HTTP/2, to an *http.Request.
+func toHTTPRequest(ctx context.Context, h2req h2Request) (*http.Request, error) {
+	var method, scheme, authority, path string
+	header := make(http.Header)
+	for _, field := range h2req.header {
+		switch field.Name {
+		case ":method":
+			method = field.Value
+		case ":scheme":
+			scheme = field.Value
+		case ":authority":
+			authority = field.Value
+		case ":path":
+			path = field.Value
+		default:
+			header.Add(field.Name, field.Value)
+		}
+	}
+	u, err := url.Parse(scheme + "://" + authority + path)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(h2req.body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header = header
+	httpReq.Host = authority
+	return httpReq, nil
+}
+
+// writeHeader writes header to out, sorted by name and with lower case
+// names.
+func writeHeader(out *strings.Builder, header http.Header) {
+	for _, name := range sortedHeaderNames(header) {
+		for _, value := range header[name] {
+			fmt.Fprintf(out, "%s: %s\r\n", strings.ToLower(name), value)
+		}
+	}
+}
+
+func sortedHeaderNames(header http.Header) []string {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,134 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxProxyHeadSize limits the size of the head of the response to a
+// CONNECT request.
+const maxProxyHeadSize = 64 * 1024
+
+// Proxy is an HTTP proxy, through which connections are tunneled using
+// CONNECT. Proxies are ignored for HTTP/3 requests.
+type Proxy struct {
+	Host string
+	Port int
+
+	// TLS makes the connection to the proxy itself use TLS. The
+	// certificate of the proxy is verified with the TLS configuration of
+	// the request, but problems are never only reported.
+	TLS bool
+
+	// Auth, if set, is sent as the Proxy-Authorization header, e.g.
+	// BasicAuth(user, password) or "Bearer " followed by a token.
+	Auth string
+}
+
+// BasicAuth returns the credentials for the basic authentication
+// scheme, as used in the Proxy-Authorization header.
+func BasicAuth(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+// proxy returns the proxy to use for req or nil.
+func (c *Client) proxy(req Request) *Proxy {
+	if req.Proxy != nil {
+		return req.Proxy
+	}
+	return c.Proxy
+}
+
+// dialProxy connects to proxy and tunnels a connection to the host of
+// req through it. If req.Addresses is set, the tunnel leads to the first
+// address instead. Returned errors are *PhaseErrors.
+func (c *Client) dialProxy(ctx context.Context, req Request, proxy *Proxy) (net.Conn, error) {
+	ips, err := resolve(ctx, Request{Host: proxy.Host})
+	if err != nil {
+		return nil, &PhaseError{PhaseDNS, err}
+	}
+	conn, err := c.dialAny(ctx, Request{Port: proxy.Port, Dialer: req.Dialer}, ips)
+	if err != nil {
+		return nil, &PhaseError{PhaseConnect, err}
+	}
+	c.logf(ctx, 1, "connected to proxy %s", conn.RemoteAddr())
+	conn = c.throttle(ctx, conn, req)
+	if proxy.TLS {
+		conf := c.tlsConfig(Request{Host: proxy.Host, TLSConfig: req.TLSConfig}, nil)
+		conf.ServerName, conf.NextProtos = proxy.Host, nil
+		tlsConn := tls.Client(conn, conf)
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, &PhaseError{PhaseProxy, err}
+		}
+		c.logf(ctx, 2, "TLS handshake with proxy done")
+		conn = tlsConn
+	}
+	target := req.Host
+	if len(req.Addresses) > 0 {
+		target = req.Addresses[0]
+	}
+	target = net.JoinHostPort(target, strconv.Itoa(req.Port))
+	if err = tunnel(ctx, conn, target, proxy.Auth); err != nil {
+		conn.Close()
+		return nil, &PhaseError{PhaseProxy, err}
+	}
+	c.logf(ctx, 1, "tunneled to %s", target)
+	return conn, nil
+}
+
+// tunnel sends a CONNECT request for target to the proxy at the other
+// end of conn and reads its response.
+func tunnel(ctx context.Context, conn net.Conn, target, auth string) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+	req := "CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n"
+	if auth != "" {
+		req += "Proxy-Authorization: " + auth + "\r\n"
+	}
+	if _, err := conn.Write([]byte(req + "\r\n")); err != nil {
+		return err
+	}
+	head, err := readProxyHead(conn)
+	if err != nil {
+		return err
+	}
+	statusLine, _, _ := strings.Cut(head, "\r\n")
+	proto, status, _ := strings.Cut(statusLine, " ")
+	if !strings.HasPrefix(proto, "HTTP/") || len(status) < 3 {
+		return fmt.Errorf("malformed response from proxy: '%s'", statusLine)
+	}
+	if status[0] != '2' {
+		return fmt.Errorf("proxy answered '%s'", status)
+	}
+	return nil
+}
+
+// readProxyHead reads the head of the proxy's response to CONNECT byte
+// by byte, so that nothing of the tunneled connection is consumed.
+func readProxyHead(conn net.Conn) (string, error) {
+	var head bytes.Buffer
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(head.Bytes(), []byte("\r\n\r\n")) {
+		if head.Len() >= maxProxyHeadSize {
+			return "", errors.New("response from proxy is too long")
+		}
+		if _, err := conn.Read(b); err != nil {
+			return "", err
+		}
+		head.WriteByte(b[0])
+	}
+	return head.String(), nil
+}
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// StreamWindow limits how much of a response is read. It is meant for
+// endpoints with unbounded bodies, like Server-Sent Events. Zero values
+// mean no limit.
+type StreamWindow struct {
+	// MaxBytes is the maximum number of bytes read for the response,
+	// including the head.
+	MaxBytes int64
+
+	// MaxTime is the maximum time spent reading the response, starting
+	// when the request was written.
+	MaxTime time.Duration
+}
+
+// errStreamLimit is returned by a streamReader after MaxBytes bytes.
+var errStreamLimit = errors.New("stream window size reached")
+
+// streamReader reads at most remaining bytes from r.
+type streamReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	if s.remaining <= 0 {
+		return 0, errStreamLimit
+	}
+	if int64(len(p)) > s.remaining {
+		p = p[:s.remaining]
+	}
+	n, err := s.r.Read(p)
+	s.remaining -= int64(n)
+	return n, err
+}
+
+// isStreamEnd returns true if err was caused by reaching the end of the
+// stream window while reading the body.
+func isStreamEnd(ctx context.Context, err error) bool {
+	var perr *PhaseError
+	if !errors.As(err, &perr) || perr.Phase != PhaseBody {
+		return false
+	}
+	return errors.Is(err, errStreamLimit) || errors.Is(err, os.ErrDeadlineExceeded) && ctx.Err() == nil
+}
@@ -0,0 +1,36 @@
+package client
+
+import (
+	"bytes"
+	"io"
+	"time"
+)
+
+// timedReader records the time at which the first byte was read from r.
+type timedReader struct {
+	r      io.Reader
+	readAt time.Time
+	n      int64 // The number of bytes read.
+
+	// raw receives a copy of all bytes read, if not nil.
+	raw *bytes.Buffer
+}
+
+func (t *timedReader) Read(p []byte) (n int, err error) {
+	if t.readAt.IsZero() && len(p) > 1 {
+		// Read a single byte first, so that the time is recorded as soon
+		// as data is available, not after a whole buffer was copied. For
+		// TLS connections, a whole record must still be received and
+		// decrypted before the first byte is available.
+		p = p[:1]
+	}
+	n, err = t.r.Read(p)
+	if t.readAt.IsZero() && n > 0 {
+		t.readAt = time.Now()
+	}
+	t.n += int64(n)
+	if t.raw != nil {
+		t.raw.Write(p[:n])
+	}
+	return n, err
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+)
+
+// CertProblem describes why the certificate of a server could not be
+// verified.
+type CertProblem struct {
+	// Reason is one of "hostname mismatch", "unknown authority",
+	// "expired", "invalid" or "other".
+	Reason string
+	Err    error
+}
+
+// tlsConfig returns the TLS configuration for req. If problem is not
+// nil, certificate verification errors do not abort the handshake but
+// are stored in problem instead.
+func (c *Client) tlsConfig(req Request, problem *CertProblem) *tls.Config {
+	var conf *tls.Config
+	if req.TLSConfig != nil {
+		conf = req.TLSConfig.Clone()
+	} else if c.TLSConfig != nil {
+		conf = c.TLSConfig.Clone()
+	} else {
+		conf = &tls.Config{}
+	}
+	if conf.ServerName == "" {
+		conf.ServerName = req.Host
+	}
+	if len(req.ALPN) > 0 {
+		conf.NextProtos = req.ALPN
+	} else if req.HTTP2 && len(conf.NextProtos) == 0 {
+		conf.NextProtos = []string{"h2"}
+	}
+	if problem != nil {
+		conf.InsecureSkipVerify = true
+		roots := conf.RootCAs
+		conf.VerifyConnection = func(cs tls.ConnectionState) error {
+			if err := verifyCert(cs, roots); err != nil {
+				*problem = CertProblem{Reason: certErrReason(err), Err: err}
+			}
+			return nil
+		}
+	}
+	return conf
+}
+
+// verifyCert does the verification that crypto/tls would do, if
+// InsecureSkipVerify was not set.
+func verifyCert(cs tls.ConnectionState, roots *x509.CertPool) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("no certificate received")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       cs.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+func certErrReason(err error) string {
+	var hostnameErr x509.HostnameError
+	var authorityErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	switch {
+	case errors.As(err, &hostnameErr):
+		return "hostname mismatch"
+	case errors.As(err, &authorityErr):
+		return "unknown authority"
+	case errors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired:
+		return "expired"
+	case errors.As(err, &invalidErr):
+		return "invalid"
+	}
+	return "other"
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// TranscriptEntry is a chunk of data, that was written to or read from
+// a connection. For TLS connections, the data is the decrypted
+// application data.
+type TranscriptEntry struct {
+	Time time.Time
+	Sent bool
+	Data []byte
+}
+
+// recordingConn records all data written to and read from a connection.
+type recordingConn struct {
+	net.Conn
+
+	mu      sync.Mutex
+	entries []TranscriptEntry
+}
+
+func (r *recordingConn) Read(p []byte) (int, error) {
+	n, err := r.Conn.Read(p)
+	r.record(false, p[:n])
+	return n, err
+}
+
+func (r *recordingConn) Write(p []byte) (int, error) {
+	n, err := r.Conn.Write(p)
+	r.record(true, p[:n])
+	return n, err
+}
+
+func (r *recordingConn) record(sent bool, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, TranscriptEntry{time.Now(), sent, append([]byte(nil), data...)})
+}
+
+func (r *recordingConn) transcript() []TranscriptEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.entries
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+// connLifetimeKey is the key of the context, that bounds the lifetime
+// of connections established by Warm, in the context passed to getConn.
+type connLifetimeKey struct{}
+
+// WarmConn is a connection, that has been established and whose TLS
+// handshake is done, ahead of the request that will use it. See
+// Request.Warm.
+type WarmConn struct {
+	conn    net.Conn
+	problem *CertProblem
+
+	// Created is the time at which the connection was established.
+	Created time.Time
+}
+
+// Warm establishes a connection for req, including the TLS handshake,
+// without writing anything. The connection can be passed to a later,
+// equivalent request in Request.Warm, so that the time needed to
+// connect overlaps with other work. The timeout of the client or req
+// applies to establishing the connection; ctx bounds the lifetime of
+// the connection. HTTP/3 connections cannot be warmed up.
+func (c *Client) Warm(ctx context.Context, req Request) (*WarmConn, error) {
+	if req.HTTP3 {
+		return nil, &PhaseError{PhaseConnect, errors.New("HTTP/3 connections cannot be warmed up")}
+	}
+	connCtx := context.WithValue(ctx, connLifetimeKey{}, ctx)
+	timeout := c.Timeout
+	if req.Timeout != 0 {
+		timeout = req.Timeout
+	}
+	if timeout != 0 {
+		var cancel context.CancelFunc
+		connCtx, cancel = context.WithTimeout(connCtx, timeout)
+		defer cancel()
+	}
+	var problem *CertProblem
+	if c.ReportCertProblems {
+		problem = &CertProblem{}
+	}
+	conn, err := c.getConn(connCtx, req, problem)
+	if err != nil {
+		return nil, err
+	}
+	if problem != nil && problem.Err == nil {
+		problem = nil
+	}
+	return &WarmConn{conn: conn, problem: problem, Created: time.Now()}, nil
+}
+
+// Close closes the connection. It must be called for connections that
+// are not passed to a request.
+func (w *WarmConn) Close() error {
+	return w.conn.Close()
+}
+
+// alive reports whether the connection seems usable, i.e. the server
+// has neither closed it nor sent anything unexpected. The deadline lies
+// in the future, because reads with an expired deadline fail without
+// looking at the connection.
+func (w *WarmConn) alive() bool {
+	if err := w.conn.SetReadDeadline(time.Now().Add(time.Millisecond)); err != nil {
+		return false
+	}
+	var b [1]byte
+	_, err := w.conn.Read(b[:])
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		return false
+	}
+	return w.conn.SetReadDeadline(time.Time{}) == nil
+}
+
+// connect returns the connection of req.Warm, if it is still alive, or
+// establishes a new one. warm reports which one happened.
+func (c *Client) connect(ctx context.Context, req Request, problem *CertProblem) (conn net.Conn, warm bool, err error) {
+	if w := req.Warm; w != nil {
+		if w.alive() {
+			c.logf(ctx, 1, "using warm connection to %s", w.conn.RemoteAddr())
+			if problem != nil && w.problem != nil {
+				*problem = *w.problem
+			}
+			return w.conn, true, nil
+		}
+		c.logf(ctx, 1, "warm connection was closed; connecting again")
+		w.conn.Close()
+	}
+	conn, err = c.getConn(ctx, req, problem)
+	return conn, false, err
+}
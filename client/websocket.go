@@ -0,0 +1,134 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/codesoap/preq/extractor"
+)
+
+// maxFramePayload is the maximum payload size of captured WebSocket
+// frames.
+const maxFramePayload = 16 << 20
+
+// WebSocketCapture limits how much WebSocket traffic is read after an
+// upgrade. Reading also ends with a close frame, when the server closes
+// the connection or when the timeout of the request expires. Zero
+// values mean no limit.
+type WebSocketCapture struct {
+	MaxFrames int
+	MaxTime   time.Duration
+}
+
+// WebSocketFrame is a frame received from a WebSocket server. The
+// payload is unmasked, if the frame was masked.
+type WebSocketFrame struct {
+	Fin     bool
+	Opcode  byte
+	Payload []byte
+}
+
+// isWebSocketUpgrade returns true if resp switches the protocol to
+// WebSocket.
+func isWebSocketUpgrade(resp extractor.Response) bool {
+	if resp.StatusCode != 101 {
+		return false
+	}
+	for _, field := range resp.Header {
+		if strings.EqualFold(field.Name, "Upgrade") && strings.EqualFold(strings.TrimSpace(field.Value), "websocket") {
+			return true
+		}
+	}
+	return false
+}
+
+// readFrames reads WebSocket frames from reader within the limits of
+// capture. Reaching a limit is no error; the frames read until then
+// are returned.
+func (c *Client) readFrames(ctx context.Context, conn net.Conn, reader *bufio.Reader, capture WebSocketCapture) ([]WebSocketFrame, error) {
+	if capture.MaxTime > 0 {
+		deadline, ok := ctx.Deadline()
+		if end := time.Now().Add(capture.MaxTime); !ok || end.Before(deadline) {
+			conn.SetReadDeadline(end)
+		}
+	}
+	var frames []WebSocketFrame
+	for capture.MaxFrames <= 0 || len(frames) < capture.MaxFrames {
+		frame, err := readFrame(reader)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, os.ErrDeadlineExceeded) && !errors.Is(ctx.Err(), context.Canceled) {
+				break
+			}
+			return frames, err
+		}
+		c.logf(ctx, 2, "read WebSocket frame with opcode %d and %d bytes payload", frame.Opcode, len(frame.Payload))
+		frames = append(frames, frame)
+		if frame.Opcode == 0x8 {
+			break
+		}
+	}
+	c.logf(ctx, 1, "read %d WebSocket frames", len(frames))
+	return frames, nil
+}
+
+// readFrame reads a single frame. io.EOF is only returned, if the
+// connection ended before the frame.
+func readFrame(r *bufio.Reader) (WebSocketFrame, error) {
+	var frame WebSocketFrame
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return frame, err
+	}
+	frame.Fin, frame.Opcode = head[0]&0x80 != 0, head[0]&0x0f
+	masked, length := head[1]&0x80 != 0, uint64(head[1]&0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return frame, unexpectedEOF(err)
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return frame, unexpectedEOF(err)
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxFramePayload {
+		return frame, fmt.Errorf("WebSocket frame payload of %d bytes too large", length)
+	}
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			return frame, unexpectedEOF(err)
+		}
+	}
+	var payload bytes.Buffer
+	if _, err := io.CopyN(&payload, r, int64(length)); err != nil {
+		return frame, unexpectedEOF(err)
+	}
+	frame.Payload = payload.Bytes()
+	if masked {
+		for i := range frame.Payload {
+			frame.Payload[i] ^= key[i%4]
+		}
+	}
+	return frame, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
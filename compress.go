@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var zstdEncoder, _ = zstd.NewWriter(nil)
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+// compressResp moves result.Resp to result.RespZstd64 in compressed
+// form, if it is longer than minSize bytes.
+func compressResp(result *httpline, minSize int) {
+	if len(result.Resp) <= minSize {
+		return
+	}
+	compressed := zstdEncoder.EncodeAll([]byte(result.Resp), nil)
+	result.RespZstd64 = base64.StdEncoding.EncodeToString(compressed)
+	result.Resp = ""
+}
+
+// decompressResp moves result.RespZstd64 back to result.Resp.
+func decompressResp(result *httpline) error {
+	if result.RespZstd64 == "" {
+		return nil
+	}
+	compressed, err := base64.StdEncoding.DecodeString(result.RespZstd64)
+	if err != nil {
+		return err
+	}
+	resp, err := zstdDecoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return err
+	}
+	result.Resp, result.RespZstd64 = string(resp), ""
+	return nil
+}
+
+// convert reads preq output from standard input and prints it with
+// compressed responses decompressed.
+func convert() {
+	dec := json.NewDecoder(os.Stdin)
+	for {
+		var rawLine json.RawMessage
+		if err := dec.Decode(&rawLine); errors.Is(err, io.EOF) {
+			return
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, "Error: Could not read standard input:", err)
+			os.Exit(1)
+		}
+		var line httpline
+		if err := json.Unmarshal(rawLine, &line); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Could not parse line '%s': %v\n", rawLine, err)
+			os.Exit(1)
+		}
+		if err := decompressResp(&line); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Could not decompress response of line '%s': %v\n", rawLine, err)
+			os.Exit(1)
+		}
+		out, err := json.Marshal(line)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error: Could not generate result:", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(out))
+	}
+}
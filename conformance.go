@@ -0,0 +1,20 @@
+package main
+
+import "github.com/codesoap/preq/extractor"
+
+// violation is an element of the "violations" field.
+type violation struct {
+	Rule   string `json:"rule"`
+	Detail string `json:"detail"`
+}
+
+func toViolations(vs []extractor.Violation) []violation {
+	if len(vs) == 0 {
+		return nil
+	}
+	out := make([]violation, len(vs))
+	for i, v := range vs {
+		out[i] = violation{v.Laxity, v.Detail}
+	}
+	return out
+}
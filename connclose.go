@@ -0,0 +1,53 @@
+package main
+
+import (
+	"time"
+
+	"github.com/codesoap/preq/client"
+)
+
+// connClose describes the behavior of the connection after the
+// response. Closed is only set, if -close-wait is given.
+type connClose struct {
+	Header     bool  `json:"header"`
+	Persistent bool  `json:"persistent"`
+	Closed     *bool `json:"closed,omitempty"`
+	ExtraBytes int   `json:"extrabytes"`
+}
+
+func toConnClose(info *client.CloseInfo) *connClose {
+	if info == nil {
+		return nil
+	}
+	c := &connClose{Header: info.Header, Persistent: info.Persistent, ExtraBytes: info.ExtraBytes}
+	if closeWaitFlag > 0 {
+		closed := info.Closed && info.ClosedAfter <= closeWaitFlag
+		c.Closed = &closed
+	}
+	return c
+}
+
+// keepAliveGrace is the time, within which a server must not close the
+// connection after the response to count as supporting keep-alive.
+const keepAliveGrace = 100 * time.Millisecond
+
+// keepAlive is the result of probing the keep-alive behavior of the
+// server with -keepalive-probe. IdleCloseMS is only set, if the server
+// closed the idle connection within the probing time.
+type keepAlive struct {
+	Supported   bool   `json:"supported"`
+	IdleCloseMS *int64 `json:"idleclosems,omitempty"`
+}
+
+func toKeepAlive(info *client.CloseInfo) *keepAlive {
+	if info == nil || keepAliveProbeFlag <= 0 {
+		return nil
+	}
+	closed := info.Closed && info.ClosedAfter <= keepAliveProbeFlag
+	k := &keepAlive{Supported: info.Persistent && (!closed || info.ClosedAfter >= keepAliveGrace)}
+	if closed {
+		ms := info.ClosedAfter.Milliseconds()
+		k.IdleCloseMS = &ms
+	}
+	return k
+}
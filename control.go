@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// delay is the current pause between dispatching requests. It starts
+// with -delay and can be changed at runtime.
+var delay atomic.Int64
+
+// workers starts the goroutines, that make the requests.
+var workers *workerPool
+
+// workerPool runs the workers. More of them are started, if the
+// parallelism is raised at runtime. Lowering the parallelism leaves the
+// workers running, but limits them via concurrency.
+type workerPool struct {
+	mu      sync.Mutex
+	work    func()
+	started int
+	running int
+	done    chan struct{} // Closed when all workers have finished.
+}
+
+func newWorkerPool(work func()) *workerPool {
+	return &workerPool{work: work, done: make(chan struct{})}
+}
+
+// grow starts workers until n have been started. Nothing is started
+// after all workers have finished, because the input has ended then.
+func (p *workerPool) grow(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.started > 0 && p.running == 0 {
+		return
+	}
+	for ; p.started < n; p.started++ {
+		p.running++
+		go func() {
+			p.work()
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if p.running--; p.running == 0 {
+				close(p.done)
+			}
+		}()
+	}
+}
+
+// setParallelism changes the number of parallel requests to n. With
+// -auto-p, n becomes the maximum.
+func setParallelism(n int) {
+	concurrency.set(n)
+	workers.grow(n)
+}
+
+// handleControlSignals steps the parallelism up by one on stepUpSignal
+// and down by one on stepDownSignal. Each change is reported to standard
+// error.
+func handleControlSignals() {
+	if stepUpSignal == nil {
+		return
+	}
+	signals := make(chan os.Signal, 8)
+	signal.Notify(signals, stepUpSignal, stepDownSignal)
+	for sig := range signals {
+		n := concurrency.maximum()
+		if sig == stepUpSignal {
+			n++
+		} else if n > 1 {
+			n--
+		}
+		setParallelism(n)
+		fmt.Fprintf(os.Stderr, "Parallelism set to %d.\n", n)
+	}
+}
+
+// serveControl accepts connections on the Unix socket at path and
+// executes the commands sent over them. Errors, that occur after the
+// socket has been created, are reported to standard error.
+func serveControl(path string) error {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error: Could not accept control connection:", err)
+				return
+			}
+			go handleControl(conn)
+		}
+	}()
+	return nil
+}
+
+// closeControl removes the control socket, if -control-socket is given.
+func closeControl() {
+	if controlSocketFlag != "" {
+		os.Remove(controlSocketFlag)
+	}
+}
+
+// handleControl executes the commands, that are sent over conn, one
+// per line, and answers each with one or more lines. The last line of
+// an answer is "ok" or starts with "error: ".
+func handleControl(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		answer, err := control(strings.Fields(scanner.Text()))
+		if err != nil {
+			answer = "error: " + err.Error() + "\n"
+		} else {
+			answer += "ok\n"
+		}
+		if _, err = conn.Write([]byte(answer)); err != nil {
+			return
+		}
+	}
+}
+
+// control executes a single command and returns its output.
+func control(command []string) (string, error) {
+	if len(command) == 0 {
+		return "", fmt.Errorf("missing command")
+	}
+	switch command[0] {
+	case "get":
+		return fmt.Sprintf("p %d\ndelay %v\n", concurrency.maximum(), time.Duration(delay.Load())), nil
+	case "status":
+		return fmt.Sprintf("read %d\ncompleted %d\nfailed %d\nworkers %d\n",
+			prog.read.Load(), prog.completed.Load(), prog.failed.Load(), concurrency.current()), nil
+	case "set":
+		if len(command) != 3 {
+			return "", fmt.Errorf("usage: set p|delay value")
+		}
+		return "", setLimit(command[1], command[2])
+	}
+	return "", fmt.Errorf("unknown command '%s'", command[0])
+}
+
+// setLimit sets the limit name to value.
+func setLimit(name, value string) error {
+	switch name {
+	case "p":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid parallelism '%s'", value)
+		}
+		setParallelism(n)
+	case "delay":
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid delay '%s'", value)
+		}
+		delay.Store(int64(d))
+	default:
+		return fmt.Errorf("unknown limit '%s'", name)
+	}
+	return nil
+}
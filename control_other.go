@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// The parallelism can only be changed via signals on Unix-like systems.
+var stepUpSignal, stepDownSignal os.Signal
@@ -0,0 +1,11 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// The signals, that step the parallelism up and down.
+var stepUpSignal, stepDownSignal os.Signal = syscall.SIGUSR1, syscall.SIGUSR2
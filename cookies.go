@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jar is used if -cookies is given.
+var jar *cookieJar
+
+// cookieJar stores the cookies set by responses, so that they can be
+// sent with later requests. The domain, path and secure attributes of
+// cookies are ignored.
+type cookieJar struct {
+	mu      sync.Mutex
+	perHost bool
+	cookies map[string][]*http.Cookie // By scope.
+}
+
+func newCookieJar(perHost bool) *cookieJar {
+	return &cookieJar{perHost: perHost, cookies: make(map[string][]*http.Cookie)}
+}
+
+func (j *cookieJar) scope(host string) string {
+	if j.perHost {
+		return strings.ToLower(host)
+	}
+	return ""
+}
+
+// store stores the cookies set by the response of line. Expired cookies
+// are removed.
+func (j *cookieJar) store(line httpline) {
+	if j == nil || line.Resp == "" {
+		return
+	}
+	values := headerValues(line.Resp, "Set-Cookie")
+	if len(values) == 0 {
+		return
+	}
+	resp := http.Response{Header: http.Header{"Set-Cookie": values}}
+	scope := j.scope(line.Host)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, cookie := range resp.Cookies() {
+		cookies := slices.DeleteFunc(j.cookies[scope], func(c *http.Cookie) bool { return c.Name == cookie.Name })
+		expired := cookie.MaxAge < 0 || !cookie.Expires.IsZero() && cookie.Expires.Before(time.Now())
+		if !expired {
+			cookies = append(cookies, cookie)
+		}
+		j.cookies[scope] = cookies
+	}
+}
+
+// inject adds the stored cookies for the host of line to its request.
+// Cookies that are already present in the request are kept.
+func (j *cookieJar) inject(line *httpline) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	cookies := slices.Clone(j.cookies[j.scope(line.Host)])
+	j.mu.Unlock()
+	if len(cookies) == 0 {
+		return
+	}
+	present := make(map[string]bool)
+	existing := strings.Join(headerValues(line.Req, "Cookie"), "; ")
+	for _, pair := range strings.Split(existing, ";") {
+		name, _, _ := strings.Cut(pair, "=")
+		present[strings.TrimSpace(name)] = true
+	}
+	pairs := []string{}
+	if existing != "" {
+		pairs = append(pairs, existing)
+	}
+	added := false
+	for _, cookie := range cookies {
+		if !present[cookie.Name] {
+			pairs = append(pairs, cookie.Name+"="+cookie.Value)
+			added = true
+		}
+	}
+	if added {
+		line.Req = withHeader(line.Req, "Cookie", strings.Join(pairs, "; "))
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// deduper recognizes lines whose requests are equivalent to those of
+// lines seen before.
+type deduper struct {
+	seen       map[string]bool
+	duplicates int
+}
+
+func newDeduper() *deduper {
+	return &deduper{seen: make(map[string]bool)}
+}
+
+// isDuplicate returns true if an equivalent line has been seen before.
+// Defaults for TLS and port must already have been applied to line.
+func (d *deduper) isDuplicate(line httpline) bool {
+	key := dedupeKey(line)
+	if d.seen[key] {
+		d.duplicates++
+		return true
+	}
+	d.seen[key] = true
+	return false
+}
+
+// dedupeKey builds a key from the normalized target of line, ignoring
+// the case of the host, default ports and trailing slashes. The Host
+// header is part of the key, so that virtual hosts are distinguished,
+// but its case and a default port are ignored as well. The options, that
+// affect the connection, like the protocol, addresses and TLS options,
+// are part of the key, too.
+func dedupeKey(line httpline) string {
+	requestLine, rest, _ := strings.Cut(line.Req, "\n")
+	var method, target, version string
+	if fields := strings.Fields(requestLine); len(fields) == 3 {
+		method, target, version = fields[0], fields[1], fields[2]
+	} else {
+		method = requestLine
+	}
+	var key strings.Builder
+	key.WriteString(strings.ToLower(line.Host) + "\n")
+	key.WriteString(strconv.Itoa(line.Port) + "\n")
+	key.WriteString(strconv.FormatBool(*line.TLS) + "\n")
+	connOptions, _ := json.Marshal(struct {
+		H2, H3, FanOut  bool
+		H2C             h2cMode
+		Addresses, ALPN []string
+		tlsOptions
+		sourceOptions
+		proxyOptions
+	}{useHTTP2(line), useHTTP3(line), fanOut(line), line.H2C, line.Addresses, line.ALPN,
+		line.tlsOptions, line.sourceOptions, line.proxyOptions})
+	key.Write(append(connOptions, '\n'))
+	key.WriteString(method + " " + normalizeTarget(target) + " " + version + "\n")
+	for _, header := range strings.SplitAfter(rest, "\n") {
+		name, value, _ := strings.Cut(header, ":")
+		if strings.EqualFold(strings.TrimSpace(name), "host") {
+			key.WriteString("host:" + normalizeHostHeader(value, *line.TLS) + "\n")
+		} else {
+			key.WriteString(header)
+		}
+	}
+	return key.String()
+}
+
+// normalizeHostHeader lower cases the value of a Host header and removes
+// the default port of the scheme.
+func normalizeHostHeader(value string, useTLS bool) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if useTLS {
+		return strings.TrimSuffix(value, ":443")
+	}
+	return strings.TrimSuffix(value, ":80")
+}
+
+func normalizeTarget(target string) string {
+	if strings.Contains(target, "://") {
+		// Absolute-form request target.
+		if u, err := url.Parse(target); err == nil {
+			u.Scheme, u.Host = strings.ToLower(u.Scheme), strings.ToLower(u.Host)
+			if u.Scheme == "http" && u.Port() == "80" || u.Scheme == "https" && u.Port() == "443" {
+				u.Host = u.Hostname()
+			}
+			u.Path = normalizePath(u.Path)
+			return u.String()
+		}
+	}
+	path, query, hasQuery := strings.Cut(target, "?")
+	path = normalizePath(path)
+	if hasQuery {
+		return path + "?" + query
+	}
+	return path
+}
+
+func normalizePath(path string) string {
+	if trimmed := strings.TrimRight(path, "/"); trimmed != "" {
+		return trimmed
+	}
+	return "/"
+}
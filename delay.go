@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// pause returns the time to wait between dispatching two requests,
+// which is the current delay plus a random duration below -jitter.
+func pause() time.Duration {
+	d := time.Duration(delay.Load())
+	if jitterFlag > 0 {
+		d += time.Duration(rand.Int63n(int64(jitterFlag)))
+	}
+	return d
+}
+
+// sleep waits for d or until ctx is done. It returns false in the latter
+// case.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// delayLines forwards the lines from in to out, pausing between them.
+func delayLines(ctx context.Context, in, out chan httpline) {
+	defer close(out)
+	first := true
+	for line := range in {
+		if !first && !stopped.Load() && !sleep(ctx, pause()) {
+			return
+		}
+		first = false
+		if !send(ctx, out, line) {
+			return
+		}
+	}
+}
+
+// pacer makes a worker pause between its requests, if -delay-per-worker
+// is given.
+type pacer struct {
+	started bool
+}
+
+// wait pauses before all but the first request of the worker. If ctx
+// is done, it returns early; the following request will fail then.
+func (p *pacer) wait(ctx context.Context) {
+	if !delayPerWorkerFlag {
+		return
+	}
+	if p.started {
+		sleep(ctx, pause())
+	}
+	p.started = true
+}
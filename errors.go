@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/codesoap/preq/client"
+)
+
+// certProblem describes why the certificate of a server could not be
+// verified.
+type certProblem struct {
+	Reason string `json:"reason"`
+	Err    string `json:"err"`
+}
+
+// setErr fills the "err", "errno", "errdetail" and "oserr" fields of
+// request.
+func setErr(request *httpline, err error) {
+	request.Err, request.Errno = err.Error(), toErrno(err)
+	request.OSErr = osErrName(err)
+	var perr *client.PhaseError
+	if errors.As(err, &perr) {
+		request.Errdetail = perr.Phase
+	}
+}
+
+// setValidationErr marks request as invalid because of the given
+// problems.
+func setValidationErr(request *httpline, problems []string) {
+	request.Errno, request.Err = 99, strings.Join(problems, "; ")
+	request.Errdetail = "validation"
+}
+
+func toErrno(err error) int {
+	var perr *client.PhaseError
+	var phase string
+	if errors.As(err, &perr) {
+		phase = perr.Phase
+	}
+	if isTimeout(err) {
+		switch phase {
+		case client.PhaseDNS:
+			return 11
+		case client.PhaseTLS:
+			return 21
+		case client.PhaseWrite:
+			return 32
+		case client.PhaseHead:
+			return 33
+		case client.PhaseBody:
+			return 34
+		}
+		return 31
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return 10
+	}
+	var certErr *tls.CertificateVerificationError
+	if errors.As(err, &certErr) {
+		return 20 // FIXME: Cannot distinguish different TLS errors.
+	}
+	var syscallErr *os.SyscallError
+	if errors.As(err, &syscallErr) && syscallErr.Err == syscall.ECONNREFUSED {
+		return 30
+	}
+	if phase == client.PhaseWrite {
+		// FIXME: errno 30 may not be ideal.
+		return 30
+	}
+	return 99 // Undefined errno for unknown error.
+}
+
+// osErrName returns the name of the OS error, that caused err, like
+// "ECONNRESET", or "" if err was not caused by a system call.
+func osErrName(err error) string {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return ""
+	}
+	return errnoName(errno)
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout() ||
+		errors.Is(err, os.ErrDeadlineExceeded) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+)
+
+// externalizeResp removes the response from result, leaving only its
+// SHA-256 digest in result.RespSHA256. If dir is not empty, the
+// response is written to a file in dir, which is named after the
+// digest, and the path is stored in result.RespFile.
+func externalizeResp(result *httpline, dir string) error {
+	resp := []byte(result.Resp)
+	if result.RespZstd64 != "" {
+		if err := decompressResp(result); err != nil {
+			return err
+		}
+		resp = []byte(result.Resp)
+	}
+	digest := sha256.Sum256(resp)
+	result.RespSHA256 = hex.EncodeToString(digest[:])
+	result.Resp = ""
+	if dir == "" {
+		return nil
+	}
+	path := filepath.Join(dir, result.RespSHA256+".http")
+	if err := os.WriteFile(path, resp, 0644); err != nil {
+		return err
+	}
+	result.RespFile = path
+	return nil
+}
@@ -5,147 +5,750 @@ package extractor
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 // TODO: Improve conformance with RFC.
-// TODO: Improve performance/reduce memory allocations.
 
-const maxBufSize = 1024
+const maxBufSize = 32 * 1024
 
-// ExtractResponse extracts the response from a reader.
+// maxPrealloc restricts how much memory is allocated up front for a
+// body with the announced Content-Length.
+const maxPrealloc = 1024 * 1024
+
+var readerPool = sync.Pool{
+	New: func() any { return bufio.NewReader(nil) },
+}
+
+var bufPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, maxBufSize)
+		return &buf
+	},
+}
+
+// Limits restricts the size of the head of a response. Zero values mean
+// no limit.
+type Limits struct {
+	MaxHeaderCount int // The maximum number of header fields.
+	MaxLineLength  int // The maximum length of a line in the head.
+	MaxHeadSize    int // The maximum size of the whole head.
+}
+
+// DefaultLimits are the limits used by ExtractResponse.
+var DefaultLimits = Limits{
+	MaxHeaderCount: 1000,
+	MaxLineLength:  64 * 1024,
+	MaxHeadSize:    1024 * 1024,
+}
+
+// Errors returned, wrapped in a HeadError, if a limit is exceeded.
+var (
+	ErrTooManyHeaders = errors.New("too many header fields")
+	ErrLineTooLong    = errors.New("head line too long")
+	ErrHeadTooLarge   = errors.New("head too large")
+)
+
+// Laxities that may be tolerated when not extracting strictly.
+const (
+	LaxBareLF          = "bare LF line ending"
+	LaxStatusLine      = "malformed status line"
+	LaxHeaderNoColon   = "header line without colon"
+	LaxHeaderSpace     = "whitespace between header name and colon"
+	LaxChunkSize       = "malformed chunk size line"
+	LaxChunkDataEnding = "chunk data not followed by CRLF"
+)
+
+// Deviations that are only recorded in conformance mode, because
+// tolerating them is allowed for clients.
+const (
+	LaxObsFold            = "obsolete line folding"
+	LaxDuplicateLength    = "duplicate Content-Length"
+	LaxLengthWithEncoding = "Content-Length with Transfer-Encoding"
+	LaxHTTP10Encoding     = "Transfer-Encoding in HTTP/1.0"
+)
+
+// Framings describe how the end of the body was determined.
+const (
+	FramingNone    = "no body"
+	FramingLength  = "content-length"
+	FramingChunked = "chunked"
+	FramingClose   = "close-delimited"
+)
+
+// Rules, that resolve conflicting framing headers according to RFC
+// 7230, section 3.3.3.
+const (
+	RuleEncodingOverridesLength = "Transfer-Encoding overrides Content-Length"
+	RuleMergedLength            = "identical Content-Length values merged"
+	RuleHTTP10Encoding          = "Transfer-Encoding ignored in HTTP/1.0"
+)
+
+// maxViolations limits the number of violations recorded for a single
+// response.
+const maxViolations = 100
+
+// A Violation is a deviation from RFC 7230, that was found in
+// conformance mode.
+type Violation struct {
+	Laxity string // One of the Lax* constants.
+	Detail string // The offending data, possibly shortened.
+}
+
+// A HeadError is returned by ExtractResponse if the error occurred
+// while reading the head of the response.
+type HeadError struct {
+	Err error
+}
+
+func (e *HeadError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *HeadError) Unwrap() error {
+	return e.Err
+}
+
+// A StrictError is returned if a deviation from RFC 7230 was found
+// while extracting strictly. Laxity is one of the Lax* constants.
+type StrictError struct {
+	Laxity string
+}
+
+func (e *StrictError) Error() string {
+	return "invalid response: " + e.Laxity
+}
+
+// Options configure the extraction.
+type Options struct {
+	// Method is the method of the request, e.g. "GET". It is needed,
+	// because responses to HEAD requests and successful responses to
+	// CONNECT requests have no body.
+	Method string
+
+	// Limits restricts the size of the head.
+	Limits Limits
+
+	// Strict makes the extraction fail with a *StrictError on deviations
+	// from RFC 7230, which are otherwise tolerated.
+	Strict bool
+
+	// Conformance makes the extraction record every deviation from RFC
+	// 7230 in Response.Violations, including those that clients may
+	// tolerate. Strict is ignored in conformance mode.
+	Conformance bool
+
+	// Interim makes the extraction stop after an interim 1xx response,
+	// instead of continuing with the following response. This is needed
+	// to react to a 100 Continue response.
+	Interim bool
+}
+
+// ExtractResponse extracts the response from a reader, using
+// DefaultLimits.
 //
 // It mostly adheres to RFC 7230, section 3.3.3., but is more lax at
 // times. For example, \n is also accepted instead of \r\n in some
 // places.
 func ExtractResponse(in io.Reader, headRequest bool) (string, error) {
-	var out strings.Builder
-	reader := bufio.NewReader(in)
-	contentLength, chunked, noBody, err := readHead(reader, &out)
-	if err != nil || headRequest || noBody {
-		return out.String(), err
-	}
-	if chunked {
-		err = readChunkedBody(reader, &out)
-	} else if contentLength != nil {
-		err = copyN(reader, &out, *contentLength)
-	} else {
-		_, err = io.Copy(&out, reader)
-	}
-	return out.String(), err
-}
-
-func readHead(in *bufio.Reader, out io.Writer) (*int64, bool, bool, error) {
-	var contentLength *int64
-	var chunked bool
-	line, err := readAndCopyLine(in, out)
-	if err != nil {
-		return nil, false, false, fmt.Errorf("could not read status line: %w", err)
+	opts := Options{Limits: DefaultLimits}
+	if headRequest {
+		opts.Method = "HEAD"
+	}
+	resp, _, err := ExtractResponseWithOptions(in, opts)
+	return resp, err
+}
+
+// ExtractResponseWithOptions is like ExtractResponse, but is configured
+// by opts. Unless opts.Strict is set, the tolerated deviations from RFC
+// 7230 are returned as laxities, which are Lax* constants.
+func ExtractResponseWithOptions(in io.Reader, opts Options) (resp string, laxities []string, err error) {
+	e := newExtraction(in, opts, false)
+	defer e.release()
+	err = e.extract()
+	return e.out.String(), e.laxities, err
+}
+
+// Field is a header or trailer field.
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Response is a parsed response. For interim 1xx responses preceding
+// the final response, only Raw contains data, unless Options.Interim is
+// set.
+type Response struct {
+	// Raw is the response as returned by ExtractResponse.
+	Raw string
+
+	StatusCode int // 0 if the status line is malformed.
+	Header     []Field
+	Body       string // The body, with the chunked encoding removed.
+	Trailers   []Field
+
+	// Chunked is true if the body uses the chunked encoding. Chunks
+	// holds the sizes of its chunks, without the last chunk of size 0.
+	Chunked bool
+	Chunks  []int64
+
+	// Consumed is the number of bytes that were consumed from the reader.
+	Consumed int64
+
+	// Laxities are the deviations from RFC 7230 that were tolerated.
+	Laxities []string
+
+	// Violations holds every deviation from RFC 7230, if
+	// Options.Conformance is set. At most 100 are recorded.
+	Violations []Violation
+
+	// Framing is one of the Framing* constants, if the head of the final
+	// response could be read. FramingRule is the Rule* constant, that
+	// was applied to resolve conflicting framing headers, if any.
+	Framing     string
+	FramingRule string
+
+	// Persistent is true if the connection may be used for further
+	// requests after the response. HTTP/1.0 connections are only
+	// persistent with "Connection: keep-alive", HTTP/1.1 connections
+	// unless "Connection: close" is given. Connections, that are closed
+	// to end the body, are never persistent.
+	Persistent bool
+}
+
+// ExtractResponseFull is like ExtractResponseWithOptions, but parses the
+// response. The returned response is filled as far as possible, even if
+// an error occurs.
+//
+// If in is a *bufio.Reader, it is used directly. Since no data beyond
+// the response is consumed from it, it can be used to read following
+// responses, e.g. on a keep-alive connection.
+func ExtractResponseFull(in io.Reader, opts Options) (Response, error) {
+	e := newExtraction(in, opts, true)
+	defer e.release()
+	err := e.extract()
+	resp := Response{
+		Raw:        e.out.String(),
+		StatusCode: e.head.statusCode,
+		Header:     e.header,
+		Trailers:   e.trailers,
+		Chunked:    e.chunked,
+		Chunks:     e.chunks,
+		Consumed:   int64(e.out.Len()),
+		Laxities:   e.laxities,
+		Violations: e.violations,
+	}
+	if e.framing != "" {
+		resp.Framing, resp.FramingRule = e.framing, e.head.rule
+		resp.Persistent = e.head.persistent() && e.framing != FramingClose
+	}
+	if e.head.chunked {
+		resp.Body = e.body.String()
+	} else if e.headEnd > 0 {
+		resp.Body = resp.Raw[e.headEnd:]
+	}
+	return resp, err
+}
+
+// ExtractResponses extracts n consecutive responses from r, as they are
+// sent in reply to pipelined requests. methods holds the methods of the
+// n requests. DefaultLimits are used.
+//
+// The responses extracted until an error occurred are returned, the
+// last one possibly incomplete. A response without a defined length
+// ends only when r does, so it must be the last one.
+func ExtractResponses(r io.Reader, n int, methods []string) ([]Response, error) {
+	if len(methods) != n {
+		return nil, fmt.Errorf("got %d methods for %d responses", len(methods), n)
+	}
+	reader := bufio.NewReader(r)
+	resps := make([]Response, 0, n)
+	for i := 0; i < n; i++ {
+		opts := Options{Method: methods[i], Limits: DefaultLimits}
+		resp, err := ExtractResponseFull(reader, opts)
+		resps = append(resps, resp)
+		if err != nil {
+			return resps, fmt.Errorf("could not extract response %d: %w", i+1, err)
+		}
+	}
+	return resps, nil
+}
+
+// extraction holds the state of a single extraction.
+type extraction struct {
+	in         *bufio.Reader
+	out        *strings.Builder
+	opts       Options
+	headSize   int
+	laxities   []string
+	violations []Violation
+
+	// The following fields are only filled, if full is true.
+	full     bool
+	head     head
+	headEnd  int // The end of the final head in out.
+	header   []Field
+	trailers []Field
+	framing  string
+	chunked  bool // Whether a chunked body was read.
+	chunks   []int64
+	body     strings.Builder // The decoded body of chunked responses.
+	pooled   bool            // Whether in must be returned to readerPool.
+}
+
+func newExtraction(in io.Reader, opts Options, full bool) *extraction {
+	reader, ok := in.(*bufio.Reader)
+	if !ok {
+		reader = readerPool.Get().(*bufio.Reader)
+		reader.Reset(in)
+	}
+	return &extraction{in: reader, out: &strings.Builder{}, opts: opts, full: full, pooled: !ok}
+}
+
+// release returns the reader to readerPool, if it was taken from there.
+// e.in must not be used afterwards.
+func (e *extraction) release() {
+	if e.pooled {
+		e.in.Reset(nil)
+		readerPool.Put(e.in)
+		e.in, e.pooled = nil, false
 	}
-	noBody := hasNoBodyStatusCode(line)
+}
+
+func (e *extraction) extract() error {
+	var err error
 	for {
-		line, err := readAndCopyLine(in, out)
+		if e.head, err = e.readHead(); err != nil {
+			return &HeadError{err}
+		}
+		// Interim responses are followed by another response. 101
+		// Switching Protocols is final, though.
+		if !e.head.isInterim() || e.opts.Interim {
+			break
+		}
+	}
+	e.headEnd = e.out.Len()
+	h, method := e.head, e.opts.Method
+	if h.hasNoBody() || strings.EqualFold(method, "HEAD") ||
+		strings.EqualFold(method, "CONNECT") && h.statusCode >= 200 && h.statusCode < 300 {
+		e.framing = FramingNone
+		return nil
+	}
+	if h.chunked {
+		e.framing = FramingChunked
+		return e.readChunkedBody()
+	} else if h.contentLength != nil {
+		e.framing = FramingLength
+		e.out.Grow(int(min(*h.contentLength, maxPrealloc)))
+		return copyN(e.in, e.out, *h.contentLength)
+	}
+	// Without a length, and with a Transfer-Encoding, whose final coding
+	// is not chunked, the body ends when the connection is closed.
+	e.framing = FramingClose
+	_, err = io.Copy(e.out, e.in)
+	return err
+}
+
+// tolerate records the laxity or returns a *StrictError if extracting
+// strictly. In conformance mode, a violation with the offending data is
+// recorded as well.
+func (e *extraction) tolerate(laxity, data string) error {
+	if e.opts.Conformance {
+		e.violate(laxity, data)
+	} else if e.opts.Strict {
+		return &StrictError{laxity}
+	}
+	if !slices.Contains(e.laxities, laxity) {
+		e.laxities = append(e.laxities, laxity)
+	}
+	return nil
+}
+
+// violate records a violation in conformance mode. Repeated violations
+// are recorded only once.
+func (e *extraction) violate(laxity, data string) {
+	if !e.opts.Conformance || len(e.violations) >= maxViolations {
+		return
+	}
+	const maxDetail = 80
+	if len(data) > maxDetail {
+		data = data[:maxDetail] + "..."
+	}
+	v := Violation{laxity, data}
+	if !slices.Contains(e.violations, v) {
+		e.violations = append(e.violations, v)
+	}
+}
+
+// head holds the information from the head of a response, that is
+// needed to find the end of its body.
+type head struct {
+	statusCode    int // 0 if the status line is malformed.
+	contentLength *int64
+	chunked       bool
+	encoded       bool   // Whether a Transfer-Encoding header is present.
+	lengthErr     error  // Describes an invalid Content-Length.
+	lengthMerged  bool   // Whether identical Content-Length values were merged.
+	rule          string // The Rule* applied to resolve a conflict, if any.
+	http10        bool   // Whether the response uses HTTP/1.0.
+	close         bool   // Whether "Connection: close" is given.
+	keepAlive     bool   // Whether "Connection: keep-alive" is given.
+}
+
+// persistent returns whether the connection may be reused according to
+// the HTTP version and the Connection header.
+func (h head) persistent() bool {
+	if h.http10 {
+		return h.keepAlive && !h.close
+	}
+	return !h.close
+}
+
+func (h head) isInterim() bool {
+	return h.statusCode >= 100 && h.statusCode < 200 && h.statusCode != 101
+}
+
+func (h head) hasNoBody() bool {
+	return h.statusCode >= 100 && h.statusCode < 200 || h.statusCode == 204 || h.statusCode == 304
+}
+
+func (e *extraction) readHead() (head, error) {
+	var h head
+	e.header = nil
+	line, err := e.readAndCopyHeadLine()
+	if err != nil {
+		return h, fmt.Errorf("could not read status line: %w", err)
+	}
+	if !isValidStatusLine(line) {
+		if err = e.tolerate(LaxStatusLine, line); err != nil {
+			return h, err
+		}
+	}
+	h.statusCode = parseStatusCode(line)
+	h.http10 = strings.HasPrefix(line, "HTTP/1.0 ")
+	var field string // The current field, with continuation lines unfolded.
+	for headerCount := 0; ; headerCount++ {
+		line, err := e.readAndCopyHeadLine()
 		if err != nil {
-			return nil, false, false, fmt.Errorf("could not read line: %w", err)
+			return h, fmt.Errorf("could not read line: %w", err)
+		}
+		if line != "" && (line[0] == ' ' || line[0] == '\t') && field != "" {
+			// Obsolete line folding is replaced by a space, as RFC 7230,
+			// section 3.2.4. demands.
+			e.violate(LaxObsFold, line)
+			field += " " + strings.TrimSpace(line)
+			continue
+		}
+		if field != "" {
+			e.addHeaderField(&h, field)
 		}
 		if line == "" {
 			break
 		}
-		lowerLine := strings.ToLower(line)
-		if strings.HasPrefix(lowerLine, "content-length:") {
-			if contentLength != nil {
-				return nil, false, false, fmt.Errorf("multiple Content-Length headers found")
+		if limit := e.opts.Limits.MaxHeaderCount; limit > 0 && headerCount >= limit {
+			return h, ErrTooManyHeaders
+		}
+		if err = e.checkHeaderLine(line); err != nil {
+			return h, err
+		}
+		field = line
+	}
+	return h, e.applyFramingRules(&h)
+}
+
+// addHeaderField adds the unfolded header field to e.header and records
+// the framing information of it in h.
+func (e *extraction) addHeaderField(h *head, field string) {
+	e.addField(&e.header, field)
+	name, value, _ := strings.Cut(field, ":")
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "content-length":
+		length, duplicate, err := parseContentLength(value)
+		if err != nil {
+			if h.lengthErr == nil {
+				h.lengthErr = fmt.Errorf("invalid Content-Length in '%s': %w", field, err)
 			}
-			n := strings.TrimSpace(strings.SplitN(line, ":", 2)[1])
-			i, err := strconv.ParseInt(n, 10, 64)
-			if err != nil {
-				return nil, false, false, fmt.Errorf("invalid Content-Length in '%s': %w", line, err)
+			return
+		}
+		if h.contentLength != nil {
+			if *h.contentLength != length {
+				h.lengthErr = errors.New("multiple Content-Length headers with differing values found")
+				return
 			}
-			contentLength = &i
+			duplicate = true
+		}
+		if duplicate {
+			e.violate(LaxDuplicateLength, field)
+			h.lengthMerged = true
+		}
+		h.contentLength = &length
+	case "transfer-encoding":
+		codings := strings.Split(value, ",")
+		h.chunked = strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked")
+		h.encoded = true
+	case "connection":
+		h.close = h.close || hasToken(value, "close")
+		h.keepAlive = h.keepAlive || hasToken(value, "keep-alive")
+	}
+}
+
+// parseContentLength parses the value of a Content-Length header, which
+// may be a list of identical values. duplicate is true in this case.
+func parseContentLength(value string) (length int64, duplicate bool, err error) {
+	values := strings.Split(value, ",")
+	for i, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" || strings.Trim(v, "0123456789") != "" {
+			return 0, false, fmt.Errorf("invalid length '%s'", v)
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, false, err
 		}
-		if strings.HasPrefix(lowerLine, "transfer-encoding:") {
-			fields := strings.Split(strings.SplitN(line, ":", 2)[1], ",")
-			chunked = strings.TrimSpace(fields[len(fields)-1]) == "chunked"
+		if i > 0 && n != length {
+			return 0, false, errors.New("differing values in list")
 		}
+		length = n
 	}
-	return contentLength, chunked, noBody, nil
+	return length, len(values) > 1, nil
 }
 
-func hasNoBodyStatusCode(statusLine string) bool {
+// applyFramingRules resolves conflicts between the Content-Length and
+// Transfer-Encoding headers as described in RFC 7230, section 3.3.3.:
+// Transfer-Encoding overrides Content-Length and an invalid
+// Content-Length is an error only without Transfer-Encoding. As RFC
+// 9112, section 6.1. demands, the framing of HTTP/1.0 responses with
+// Transfer-Encoding is considered faulty, so that they are read until
+// the connection is closed.
+func (e *extraction) applyFramingRules(h *head) error {
+	hasLength := h.contentLength != nil || h.lengthErr != nil
+	switch {
+	case h.encoded && h.http10:
+		e.violate(LaxHTTP10Encoding, "HTTP/1.0")
+		h.contentLength, h.chunked, h.rule = nil, false, RuleHTTP10Encoding
+	case h.encoded && hasLength:
+		if h.contentLength != nil {
+			e.violate(LaxLengthWithEncoding, fmt.Sprintf("Content-Length: %d", *h.contentLength))
+		} else {
+			e.violate(LaxLengthWithEncoding, h.lengthErr.Error())
+		}
+		h.contentLength, h.rule = nil, RuleEncodingOverridesLength
+	case h.lengthErr != nil:
+		return h.lengthErr
+	case h.lengthMerged:
+		h.rule = RuleMergedLength
+	}
+	return nil
+}
+
+// hasToken returns true if the comma separated list contains token,
+// ignoring case.
+func hasToken(list, token string) bool {
+	for _, t := range strings.Split(list, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *extraction) checkHeaderLine(line string) error {
+	name, _, found := strings.Cut(line, ":")
+	if !found {
+		return e.tolerate(LaxHeaderNoColon, line)
+	}
+	if strings.TrimRight(name, " \t") != name {
+		return e.tolerate(LaxHeaderSpace, line)
+	}
+	return nil
+}
+
+// addField parses line and appends it to fields, if e.full is set.
+// Continuation lines are appended to the value of the previous field.
+func (e *extraction) addField(fields *[]Field, line string) {
+	if !e.full {
+		return
+	}
+	if (line[0] == ' ' || line[0] == '\t') && len(*fields) > 0 {
+		last := &(*fields)[len(*fields)-1]
+		last.Value += " " + strings.TrimSpace(line)
+		return
+	}
+	name, value, _ := strings.Cut(line, ":")
+	*fields = append(*fields, Field{strings.TrimSpace(name), strings.TrimSpace(value)})
+}
+
+func isValidStatusLine(line string) bool {
+	version, rest, found := strings.Cut(line, " ")
+	if !found || len(version) != len("HTTP/1.1") || !strings.HasPrefix(version, "HTTP/") {
+		return false
+	}
+	code, _, _ := strings.Cut(rest, " ")
+	if len(code) != 3 {
+		return false
+	}
+	_, err := strconv.Atoi(code)
+	return err == nil
+}
+
+// parseStatusCode returns the status code from statusLine or 0, if it
+// cannot be parsed.
+func parseStatusCode(statusLine string) int {
 	fields := strings.Fields(statusLine)
 	if len(fields) < 2 {
-		return false
+		return 0
 	}
 	statusCode, err := strconv.Atoi(fields[1])
 	if err != nil {
-		return false
+		return 0
 	}
-	return statusCode >= 100 && statusCode < 200 || statusCode == 204 || statusCode == 304
+	return statusCode
 }
 
-func readChunkedBody(in *bufio.Reader, out io.Writer) error {
+func (e *extraction) readChunkedBody() error {
+	e.chunked = true
 	for {
-		chunk, err := readAndCopyLine(in, out)
+		chunk, err := e.readAndCopyLine()
 		if err != nil {
 			return err
 		}
-		chunkSize, err := strconv.ParseInt(strings.Split(chunk, ";")[0], 16, 64)
+		sizeField, _, hasExt := strings.Cut(chunk, ";")
+		if !hasExt && strings.TrimSpace(sizeField) != sizeField {
+			if err = e.tolerate(LaxChunkSize, chunk); err != nil {
+				return err
+			}
+		}
+		chunkSize, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
 		if err != nil {
 			return fmt.Errorf("invalid chunk '%s'", chunk)
 		}
 		if chunkSize == 0 {
 			break
 		}
-		// +2 ist for \r\n that must come at the end of each chunk.
-		if err = copyN(in, out, chunkSize+2); err != nil {
+		var out io.Writer = e.out
+		if e.full {
+			out = io.MultiWriter(e.out, &e.body)
+			e.chunks = append(e.chunks, chunkSize)
+		}
+		if err = copyN(e.in, out, chunkSize); err != nil {
 			return fmt.Errorf("could not read full chunk body: %w", err)
 		}
+		if err = e.readChunkDataEnding(); err != nil {
+			return err
+		}
 	}
 	for {
-		line, err := readAndCopyLine(in, out)
+		line, err := e.readAndCopyLine()
 		if err != nil {
 			return err
 		}
 		if line == "" {
 			return nil
 		}
+		e.addField(&e.trailers, line)
 	}
 }
 
-func readAndCopyLine(in *bufio.Reader, out io.Writer) (string, error) {
-	rawLine, err := in.ReadBytes('\n')
+// readChunkDataEnding reads the \r\n that must come at the end of each
+// chunk. If the next two bytes are not \r\n, they are consumed anyway.
+func (e *extraction) readChunkDataEnding() error {
+	var ending [2]byte
+	for i := range ending {
+		b, err := e.in.ReadByte()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return fmt.Errorf("could not read full chunk body: %w", err)
+		}
+		ending[i] = b
+		e.out.WriteByte(b)
+	}
+	if ending != [2]byte{'\r', '\n'} {
+		return e.tolerate(LaxChunkDataEnding, string(ending[:]))
+	}
+	return nil
+}
+
+// readAndCopyHeadLine is like readAndCopyLine, but enforces the line
+// length and head size limits.
+func (e *extraction) readAndCopyHeadLine() (string, error) {
+	limits := e.opts.Limits
+	var rawLine []byte
+	for {
+		fragment, err := e.in.ReadSlice('\n')
+		if rawLine == nil && err == nil {
+			// The common case: the whole line was buffered. It is
+			// valid until the next read, so there is no need to copy.
+			rawLine = fragment
+		} else {
+			rawLine = append(rawLine, fragment...)
+		}
+		if limits.MaxLineLength > 0 && len(rawLine) > limits.MaxLineLength {
+			return "", ErrLineTooLong
+		}
+		if limits.MaxHeadSize > 0 && e.headSize+len(rawLine) > limits.MaxHeadSize {
+			return "", ErrHeadTooLarge
+		}
+		if err == nil {
+			break
+		} else if err != bufio.ErrBufferFull {
+			return "", fmt.Errorf("could not read line: %w", err)
+		}
+	}
+	e.headSize += len(rawLine)
+	return e.copyLine(rawLine)
+}
+
+func (e *extraction) readAndCopyLine() (string, error) {
+	rawLine, err := e.in.ReadBytes('\n')
 	if err != nil {
 		return "", fmt.Errorf("could not read line: %w", err)
 	}
-	if _, err := out.Write(rawLine); err != nil {
+	return e.copyLine(rawLine)
+}
+
+// copyLine writes rawLine to the output and returns it without line
+// ending.
+func (e *extraction) copyLine(rawLine []byte) (string, error) {
+	if _, err := e.out.Write(rawLine); err != nil {
 		return "", fmt.Errorf("could not write line: %w", err)
 	}
-	return strings.TrimRight(string(rawLine), "\r\n"), nil
+	line := string(rawLine)
+	if !strings.HasSuffix(line, "\r\n") {
+		if err := e.tolerate(LaxBareLF, line); err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimRight(line, "\r\n"), nil
 }
 
+// copyN copies exactly n bytes from in to out. If in ends early,
+// io.ErrUnexpectedEOF is returned.
 func copyN(in *bufio.Reader, out io.Writer, n int64) error {
 	if n == 0 {
 		return nil
 	}
-	for n > maxBufSize {
-		buf := make([]byte, maxBufSize)
-		read, err := in.Read(buf)
-		if err != nil {
-			return err
+	bufp := bufPool.Get().(*[]byte)
+	defer bufPool.Put(bufp)
+	buf := *bufp
+	for n > 0 {
+		read, err := in.Read(buf[:min(n, int64(len(buf)))])
+		if _, werr := out.Write(buf[:read]); werr != nil {
+			return werr
 		}
-		_, err = out.Write(buf[:read])
-		if err != nil {
+		n -= int64(read)
+		if err == io.EOF && n > 0 {
+			return io.ErrUnexpectedEOF
+		} else if err != nil && n > 0 {
 			return err
 		}
-		n -= int64(read)
 	}
-	buf := make([]byte, n)
-	if _, err := io.ReadFull(in, buf); err != nil {
-		return err
-	}
-	_, err := out.Write(buf)
-	return err
+	return nil
 }
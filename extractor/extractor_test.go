@@ -1,6 +1,10 @@
 package extractor_test
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
+	"slices"
 	"strings"
 	"testing"
 
@@ -75,10 +79,27 @@ var tests = []testCase{
 		"HTTP/1.1 200 OK\r\nContent-Length: 1\r\nTransfer-Encoding: chunked\r\n\r\na\r\nAll good.\n\r\n0\r\n\r\n",
 		false,
 	},
+	{
+		false,
+		"HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nfoobar",
+		"HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nfoo",
+		false,
+	},
+	{
+		false,
+		"HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\nHTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 204 No Content\r\n\r\nfoo",
+		"HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\nHTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 204 No Content\r\n\r\n",
+		false,
+	},
+	{
+		false,
+		"HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\n\x81\x03foo",
+		"HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\n",
+		false,
+	},
 	// TODO: More tests with errors due to invalid sizes.
 	// TODO: Test when Content-Length and Transfer-Encoding are present.
 	// TODO: Ensure correct differentiation between \n and \r\n.
-	// TODO: Ensure correct handling of responses to CONNECT requests?
 }
 
 func TestExtraction(t *testing.T) {
@@ -91,3 +112,270 @@ func TestExtraction(t *testing.T) {
 		}
 	}
 }
+
+func TestHeadError(t *testing.T) {
+	_, err := extractor.ExtractResponse(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Len"), false)
+	var headErr *extractor.HeadError
+	if !errors.As(err, &headErr) {
+		t.Errorf("Expected HeadError for incomplete head, got: %v", err)
+	}
+	_, err = extractor.ExtractResponse(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nfoo"), false)
+	if err == nil || errors.As(err, &headErr) {
+		t.Errorf("Expected non-HeadError for incomplete body, got: %v", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	limits := extractor.Limits{MaxHeaderCount: 2, MaxLineLength: 32, MaxHeadSize: 64}
+	limitTests := []struct {
+		in          string
+		expectedErr error
+	}{
+		{"HTTP/1.1 200 OK\r\nA: 1\r\nB: 2\r\n\r\n", nil},
+		{"HTTP/1.1 200 OK\r\nA: 1\r\nB: 2\r\nC: 3\r\n\r\n", extractor.ErrTooManyHeaders},
+		{"HTTP/1.1 200 OK\r\nA: " + strings.Repeat("a", 32) + "\r\n\r\n", extractor.ErrLineTooLong},
+		{"HTTP/1.1 200 OK\r\nA: " + strings.Repeat("a", 25) + "\r\nB: " + strings.Repeat("b", 25) + "\r\n\r\n", extractor.ErrHeadTooLarge},
+	}
+	for i, tt := range limitTests {
+		opts := extractor.Options{Limits: limits}
+		_, _, err := extractor.ExtractResponseWithOptions(strings.NewReader(tt.in), opts)
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("%d. Expected error '%v', got: %v", i, tt.expectedErr, err)
+		}
+	}
+}
+
+func TestStrictness(t *testing.T) {
+	strictTests := []struct {
+		in               string
+		expectedLaxities []string
+	}{
+		{"HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nfoo", nil},
+		{"HTTP/1.1 200 OK\nContent-Length: 3\n\nfoo", []string{extractor.LaxBareLF}},
+		{"HTTP/1.1 200 OK\r\nContent-Length : 3\r\n\r\nfoo", []string{extractor.LaxHeaderSpace}},
+		{"HTTP/1.1 200 OK\r\nFoo\r\n\r\n", []string{extractor.LaxHeaderNoColon}},
+		{"HTTP/1.1 OK\r\n\r\n", []string{extractor.LaxStatusLine}},
+		{"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nfoo\n\n0\r\n\r\n", []string{extractor.LaxChunkDataEnding}},
+		{"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3 \r\nfoo\r\n0\r\n\r\n", []string{extractor.LaxChunkSize}},
+	}
+	for i, tt := range strictTests {
+		_, laxities, err := extractor.ExtractResponseWithOptions(strings.NewReader(tt.in), extractor.Options{})
+		if err != nil {
+			t.Errorf("%d. Got unexpected error: %v", i, err)
+		} else if !slices.Equal(laxities, tt.expectedLaxities) {
+			t.Errorf("%d. Got unexpected laxities %v, wanted %v", i, laxities, tt.expectedLaxities)
+		}
+		_, _, err = extractor.ExtractResponseWithOptions(strings.NewReader(tt.in), extractor.Options{Strict: true})
+		var strictErr *extractor.StrictError
+		if tt.expectedLaxities == nil && err != nil {
+			t.Errorf("%d. Got unexpected error in strict mode: %v", i, err)
+		} else if tt.expectedLaxities != nil && !errors.As(err, &strictErr) {
+			t.Errorf("%d. Expected StrictError, got: %v", i, err)
+		}
+	}
+}
+
+func TestConformance(t *testing.T) {
+	conformanceTests := []struct {
+		in                 string
+		expectedViolations []extractor.Violation
+	}{
+		{"HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nfoo", nil},
+		{"HTTP/1.1 200 OK\r\nFoo: a\r\n b\r\nContent-Length: 0\r\n\r\n", []extractor.Violation{{extractor.LaxObsFold, " b"}}},
+		{"HTTP/1.1 200 OK\r\nContent-Length: 3\r\nContent-Length: 3\r\n\r\nfoo", []extractor.Violation{{extractor.LaxDuplicateLength, "Content-Length: 3"}}},
+		{"HTTP/1.1 200 OK\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", []extractor.Violation{{extractor.LaxLengthWithEncoding, "Content-Length: 3"}}},
+		{"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nfoo\n\n0\r\n\r\n", []extractor.Violation{{extractor.LaxChunkDataEnding, "\n\n"}}},
+	}
+	for i, tt := range conformanceTests {
+		opts := extractor.Options{Conformance: true, Strict: true}
+		resp, err := extractor.ExtractResponseFull(strings.NewReader(tt.in), opts)
+		if err != nil {
+			t.Errorf("%d. Got unexpected error: %v", i, err)
+		} else if !slices.Equal(resp.Violations, tt.expectedViolations) {
+			t.Errorf("%d. Got unexpected violations %v, wanted %v", i, resp.Violations, tt.expectedViolations)
+		}
+	}
+}
+
+func TestFraming(t *testing.T) {
+	framingTests := []struct {
+		in          string
+		expectedOut string
+		framing     string
+		rule        string
+		expectedErr bool
+	}{
+		{"HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nfoobar", "HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nfoo", extractor.FramingLength, "", false},
+		{"HTTP/1.1 204 No Content\r\n\r\nfoo", "HTTP/1.1 204 No Content\r\n\r\n", extractor.FramingNone, "", false},
+		{"HTTP/1.1 200 OK\r\n\r\nfoo", "HTTP/1.1 200 OK\r\n\r\nfoo", extractor.FramingClose, "", false},
+		{"HTTP/1.1 200 OK\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nfoo\r\n0\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nfoo\r\n0\r\n\r\n", extractor.FramingChunked, extractor.RuleEncodingOverridesLength, false},
+		{"HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip\r\nContent-Length: 3\r\n\r\nfoobar", "HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip\r\nContent-Length: 3\r\n\r\nfoobar", extractor.FramingClose, extractor.RuleEncodingOverridesLength, false},
+		{"HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip,\r\n chunked\r\n\r\n3\r\nfoo\r\n0\r\n\r\n", "HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip,\r\n chunked\r\n\r\n3\r\nfoo\r\n0\r\n\r\n", extractor.FramingChunked, "", false},
+		{"HTTP/1.1 200 OK\r\nContent-Length: 3\r\nContent-Length: 3\r\n\r\nfoobar", "HTTP/1.1 200 OK\r\nContent-Length: 3\r\nContent-Length: 3\r\n\r\nfoo", extractor.FramingLength, extractor.RuleMergedLength, false},
+		{"HTTP/1.1 200 OK\r\nContent-Length: 3, 3\r\n\r\nfoobar", "HTTP/1.1 200 OK\r\nContent-Length: 3, 3\r\n\r\nfoo", extractor.FramingLength, extractor.RuleMergedLength, false},
+		{"HTTP/1.1 200 OK\r\nContent-Length: 3\r\nContent-Length: 4\r\n\r\nfoobar", "", "", "", true},
+		{"HTTP/1.1 200 OK\r\nContent-Length: -3\r\n\r\nfoobar", "", "", "", true},
+		{"HTTP/1.0 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nfoo\r\n0\r\n\r\n", "HTTP/1.0 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nfoo\r\n0\r\n\r\n", extractor.FramingClose, extractor.RuleHTTP10Encoding, false},
+	}
+	for i, tt := range framingTests {
+		resp, err := extractor.ExtractResponseFull(strings.NewReader(tt.in), extractor.Options{})
+		switch {
+		case tt.expectedErr && err == nil:
+			t.Errorf("%d. Expected error, got none", i)
+		case tt.expectedErr:
+		case err != nil:
+			t.Errorf("%d. Got unexpected error: %v", i, err)
+		case resp.Raw != tt.expectedOut:
+			t.Errorf("%d. Got unexpected extract.\nGot   : %s\nWanted: %s", i, resp.Raw, tt.expectedOut)
+		case resp.Framing != tt.framing || resp.FramingRule != tt.rule:
+			t.Errorf("%d. Got unexpected framing '%s' with rule '%s'", i, resp.Framing, resp.FramingRule)
+		}
+	}
+}
+
+func TestPersistent(t *testing.T) {
+	persistentTests := []struct {
+		in         string
+		persistent bool
+	}{
+		{"HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nfoo", true},
+		{"HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: 3\r\n\r\nfoo", false},
+		{"HTTP/1.1 200 OK\r\n\r\nfoo", false},
+		{"HTTP/1.0 200 OK\r\nContent-Length: 3\r\n\r\nfoo", false},
+		{"HTTP/1.0 200 OK\r\nConnection: Keep-Alive\r\nContent-Length: 3\r\n\r\nfoo", true},
+	}
+	for i, tt := range persistentTests {
+		resp, err := extractor.ExtractResponseFull(strings.NewReader(tt.in), extractor.Options{})
+		if err != nil {
+			t.Errorf("%d. Got unexpected error: %v", i, err)
+		} else if resp.Persistent != tt.persistent {
+			t.Errorf("%d. Got persistent %v, wanted %v", i, resp.Persistent, tt.persistent)
+		}
+	}
+}
+
+func TestMethod(t *testing.T) {
+	methodTests := []struct {
+		method      string
+		in          string
+		expectedOut string
+	}{
+		{"GET", "HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nfoo", "HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nfoo"},
+		{"HEAD", "HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nfoo", "HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\n"},
+		{"CONNECT", "HTTP/1.1 200 Connection established\r\nContent-Length: 3\r\n\r\nfoo", "HTTP/1.1 200 Connection established\r\nContent-Length: 3\r\n\r\n"},
+		{"CONNECT", "HTTP/1.1 200 Connection established\r\n\r\n\x16\x03\x01", "HTTP/1.1 200 Connection established\r\n\r\n"},
+		{"CONNECT", "HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 3\r\n\r\nfoo", "HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 3\r\n\r\nfoo"},
+	}
+	for i, tt := range methodTests {
+		opts := extractor.Options{Method: tt.method}
+		resp, _, err := extractor.ExtractResponseWithOptions(strings.NewReader(tt.in), opts)
+		if err != nil {
+			t.Errorf("%d. Got unexpected error: %v", i, err)
+		} else if resp != tt.expectedOut {
+			t.Errorf("%d. Got unexpected extract.\nGot   : %s\nWanted: %s", i, resp, tt.expectedOut)
+		}
+	}
+}
+
+func TestExtractResponseFull(t *testing.T) {
+	raw := "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nX-Foo: a\r\n  b\r\n\r\n3\r\nfoo\r\n3\r\nbar\r\n0\r\nChecksum: 123\r\n\r\n"
+	reader := bufio.NewReader(strings.NewReader(raw + "HTTP/1.1 204 No Content\r\n\r\n"))
+	resp, err := extractor.ExtractResponseFull(reader, extractor.Options{})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	expectedHeader := []extractor.Field{{"Transfer-Encoding", "chunked"}, {"X-Foo", "a b"}}
+	expectedTrailers := []extractor.Field{{"Checksum", "123"}}
+	switch {
+	case resp.Raw != raw:
+		t.Errorf("Got unexpected raw response: %s", resp.Raw)
+	case resp.StatusCode != 200:
+		t.Errorf("Got unexpected status code: %d", resp.StatusCode)
+	case !slices.Equal(resp.Header, expectedHeader):
+		t.Errorf("Got unexpected header: %v", resp.Header)
+	case resp.Body != "foobar":
+		t.Errorf("Got unexpected body: %s", resp.Body)
+	case !slices.Equal(resp.Trailers, expectedTrailers):
+		t.Errorf("Got unexpected trailers: %v", resp.Trailers)
+	case !resp.Chunked || !slices.Equal(resp.Chunks, []int64{3, 3}):
+		t.Errorf("Got unexpected chunks: %v", resp.Chunks)
+	case resp.Consumed != int64(len(raw)):
+		t.Errorf("Got unexpected consumed byte count: %d", resp.Consumed)
+	}
+	next, err := extractor.ExtractResponseFull(reader, extractor.Options{})
+	if err != nil || next.StatusCode != 204 {
+		t.Errorf("Could not extract following response: %v", err)
+	}
+}
+
+func TestExtractInterim(t *testing.T) {
+	interim := "HTTP/1.1 100 Continue\r\nX-Foo: bar\r\n\r\n"
+	final := "HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nfoo"
+	reader := bufio.NewReader(strings.NewReader(interim + final))
+	resp, err := extractor.ExtractResponseFull(reader, extractor.Options{Interim: true})
+	switch {
+	case err != nil:
+		t.Fatalf("Got unexpected error: %v", err)
+	case resp.Raw != interim:
+		t.Errorf("Got unexpected raw response: %s", resp.Raw)
+	case resp.StatusCode != 100:
+		t.Errorf("Got unexpected status code: %d", resp.StatusCode)
+	case !slices.Equal(resp.Header, []extractor.Field{{"X-Foo", "bar"}}):
+		t.Errorf("Got unexpected header: %v", resp.Header)
+	}
+	resp, err = extractor.ExtractResponseFull(reader, extractor.Options{Interim: true})
+	if err != nil || resp.Raw != final {
+		t.Errorf("Could not extract final response: %v", err)
+	}
+}
+
+func TestExtractResponses(t *testing.T) {
+	in := "HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nfoo" +
+		"HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\n" +
+		"HTTP/1.1 404 Not Found\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nbar\r\n0\r\n\r\n"
+	methods := []string{"GET", "HEAD", "GET"}
+	resps, err := extractor.ExtractResponses(strings.NewReader(in), 3, methods)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	expectedCodes := []int{200, 200, 404}
+	expectedBodies := []string{"foo", "", "bar"}
+	for i, resp := range resps {
+		if resp.StatusCode != expectedCodes[i] || resp.Body != expectedBodies[i] {
+			t.Errorf("%d. Got unexpected response: %s", i, resp.Raw)
+		}
+	}
+	resps, err = extractor.ExtractResponses(strings.NewReader(in), 4, append(methods, "GET"))
+	if err == nil || len(resps) != 4 {
+		t.Errorf("Expected error for missing response, got %d responses and error %v", len(resps), err)
+	}
+}
+
+func benchmarkExtraction(b *testing.B, raw string) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(raw)))
+	for i := 0; i < b.N; i++ {
+		if _, err := extractor.ExtractResponse(strings.NewReader(raw), false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkContentLength(b *testing.B) {
+	body := strings.Repeat("x", 1024*1024)
+	raw := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	benchmarkExtraction(b, raw)
+}
+
+func BenchmarkChunked(b *testing.B) {
+	chunk := strings.Repeat("x", 4096)
+	raw := "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		strings.Repeat(fmt.Sprintf("%x\r\n%s\r\n", len(chunk), chunk), 256) + "0\r\n\r\n"
+	benchmarkExtraction(b, raw)
+}
+
+func BenchmarkManyHeaders(b *testing.B) {
+	raw := "HTTP/1.1 200 OK\r\n" +
+		strings.Repeat("X-Some-Header: some value\r\n", 100) + "Content-Length: 0\r\n\r\n"
+	benchmarkExtraction(b, raw)
+}
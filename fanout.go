@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net"
+)
+
+// fanOut returns whether request shall be sent to every address of its
+// host.
+func fanOut(request httpline) bool {
+	return request.FanOut || fanOutFlag
+}
+
+// doFanOut makes request once for each address of its host, if it shall
+// be fanned out, recording the address in the "addr" field. Otherwise,
+// or if the host cannot be resolved, the request is made as usual.
+func doFanOut(ctx context.Context, request httpline) []httpline {
+	if !fanOut(request) {
+		return doRepeated(ctx, request)
+	}
+	addrs := request.Addresses
+	if len(addrs) == 0 {
+		addrs = lookupAll(ctx, request.Host)
+	}
+	if len(addrs) == 0 {
+		return doRepeated(ctx, request) // Reports the DNS error.
+	}
+	var lines []httpline
+	for _, addr := range addrs {
+		single := request
+		single.Addresses = []string{addr}
+		for _, line := range doRepeated(ctx, single) {
+			line.Addresses, line.Addr = request.Addresses, addr
+			lines = append(lines, line)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return lines
+}
+
+// lookupAll returns all IPv4 and IPv6 addresses of host or nil, if it
+// cannot be resolved.
+func lookupAll(ctx context.Context, host string) []string {
+	if net.ParseIP(host) != nil {
+		return []string{host}
+	}
+	if addrs := resolved.lookup(ctx, host); addrs != nil {
+		return addrs
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = ip.IP.String()
+	}
+	return addrs
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/codesoap/preq/filter"
+)
+
+// selectedByFilter returns true if line matches -filter. The defaults
+// of line are applied before.
+func selectedByFilter(line httpline) (bool, error) {
+	if lineFilter == nil {
+		return true, nil
+	}
+	line.SetDefaults()
+	b, err := json.Marshal(line)
+	if err != nil {
+		return false, err
+	}
+	var fields map[string]any
+	if err = json.Unmarshal(b, &fields); err != nil {
+		return false, err
+	}
+	return lineFilter.Matches(fields), nil
+}
+
+// lineFilter is the parsed -filter or nil.
+var lineFilter filter.Filter
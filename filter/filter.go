@@ -0,0 +1,259 @@
+// Package filter parses filter expressions like `port==8443 && tls==true`,
+// which decide whether the fields of a JSON object, e.g. an httpipe line,
+// match.
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A Filter decides, whether fields match. It is parsed from expressions
+// like `port==8443 && tls==true`, which compare fields with values. The
+// supported operators are ==, !=, <, <=, >, >= and =~, which matches a
+// regular expression. Comparisons can
+// be combined with &&, || and !, and grouped with parentheses. Values
+// are numbers, true, false, null or strings, which may be quoted with
+// double quotes.
+type Filter interface {
+	Matches(fields map[string]any) bool
+}
+
+type andFilter struct{ a, b Filter }
+type orFilter struct{ a, b Filter }
+type notFilter struct{ f Filter }
+
+func (f andFilter) Matches(fields map[string]any) bool {
+	return f.a.Matches(fields) && f.b.Matches(fields)
+}
+
+func (f orFilter) Matches(fields map[string]any) bool {
+	return f.a.Matches(fields) || f.b.Matches(fields)
+}
+
+func (f notFilter) Matches(fields map[string]any) bool {
+	return !f.f.Matches(fields)
+}
+
+// comparison compares the field with the given name with value.
+type comparison struct {
+	field string
+	op    string
+	value any // A float64, bool, string or nil.
+	re    *regexp.Regexp
+}
+
+func (c comparison) Matches(fields map[string]any) bool {
+	field := fields[c.field]
+	if c.op == "=~" {
+		return c.re.MatchString(filterString(field))
+	}
+	var cmp int
+	switch value := c.value.(type) {
+	case float64:
+		n, ok := field.(float64)
+		if !ok {
+			return c.op == "!="
+		}
+		cmp = compare(n, value)
+	case bool, nil:
+		if c.op != "==" && c.op != "!=" {
+			return false
+		}
+		return (field == value) == (c.op == "==")
+	case string:
+		cmp = strings.Compare(filterString(field), value)
+	}
+	switch c.op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+func compare(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// filterString returns the value of a field as a string. Values, that
+// are no strings, are returned in their JSON form.
+func filterString(field any) string {
+	if s, ok := field.(string); ok {
+		return s
+	}
+	if field == nil {
+		return ""
+	}
+	b, _ := json.Marshal(field)
+	return string(b)
+}
+
+// Parse parses the filter expression expr.
+func Parse(expr string) (Filter, error) {
+	p := filterParser{tokens: tokenizeFilter(expr)}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected '%s'", p.tokens[p.pos])
+	}
+	return f, nil
+}
+
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *filterParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *filterParser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *filterParser) parseOr() (Filter, error) {
+	f, err := p.parseAnd()
+	for err == nil && p.peek() == "||" {
+		p.next()
+		var b Filter
+		if b, err = p.parseAnd(); err == nil {
+			f = orFilter{f, b}
+		}
+	}
+	return f, err
+}
+
+func (p *filterParser) parseAnd() (Filter, error) {
+	f, err := p.parseUnary()
+	for err == nil && p.peek() == "&&" {
+		p.next()
+		var b Filter
+		if b, err = p.parseUnary(); err == nil {
+			f = andFilter{f, b}
+		}
+	}
+	return f, err
+}
+
+func (p *filterParser) parseUnary() (Filter, error) {
+	switch p.peek() {
+	case "!":
+		p.next()
+		f, err := p.parseUnary()
+		return notFilter{f}, err
+	case "(":
+		p.next()
+		f, err := p.parseOr()
+		if err == nil && p.next() != ")" {
+			err = fmt.Errorf("missing ')'")
+		}
+		return f, err
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (Filter, error) {
+	field, op, value := p.next(), p.next(), p.next()
+	if !isFilterWord(field) {
+		return nil, fmt.Errorf("expected field name instead of '%s'", field)
+	}
+	c := comparison{field: field, op: op}
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=", "=~":
+	default:
+		return nil, fmt.Errorf("expected operator after '%s' instead of '%s'", field, op)
+	}
+	if value == "" || strings.ContainsAny(value[:1], "()!&|=<>") {
+		return nil, fmt.Errorf("expected value after '%s'", op)
+	}
+	if value[0] == '"' {
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s", value)
+		}
+		c.value = unquoted
+	} else if n, err := strconv.ParseFloat(value, 64); err == nil {
+		c.value = n
+	} else if value == "true" || value == "false" {
+		c.value = value == "true"
+	} else if value != "null" {
+		c.value = value
+	}
+	if op == "=~" {
+		s, _ := c.value.(string)
+		var err error
+		if c.re, err = regexp.Compile(s); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// tokenizeFilter splits expr into operators, parentheses, quoted
+// strings and words.
+func tokenizeFilter(expr string) []string {
+	var tokens []string
+	for expr = strings.TrimSpace(expr); expr != ""; expr = strings.TrimSpace(expr) {
+		n := 1
+		switch {
+		case expr[0] == '"':
+			for n < len(expr) && expr[n] != '"' {
+				if expr[n] == '\\' {
+					n++
+				}
+				n++
+			}
+			n = min(n+1, len(expr))
+		case strings.HasPrefix(expr, "&&"), strings.HasPrefix(expr, "||"),
+			strings.HasPrefix(expr, "=="), strings.HasPrefix(expr, "!="),
+			strings.HasPrefix(expr, "<="), strings.HasPrefix(expr, ">="),
+			strings.HasPrefix(expr, "=~"):
+			n = 2
+		case strings.ContainsRune("()!<>", rune(expr[0])):
+		default:
+			n = strings.IndexFunc(expr, func(r rune) bool {
+				return unicode.IsSpace(r) || strings.ContainsRune("()!&|=<>\"", r)
+			})
+			if n < 0 {
+				n = len(expr)
+			} else if n == 0 {
+				n = 1
+			}
+		}
+		tokens = append(tokens, expr[:n])
+		expr = expr[n:]
+	}
+	return tokens
+}
+
+func isFilterWord(token string) bool {
+	return token != "" && strings.IndexFunc(token, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}) < 0
+}
@@ -0,0 +1,119 @@
+package filter_test
+
+import (
+	"testing"
+
+	"github.com/codesoap/preq/filter"
+)
+
+var fields = map[string]any{
+	"host": "x.com",
+	"port": float64(8443),
+	"tls":  true,
+	"req":  "GET /a\"b HTTP/1.1",
+}
+
+var tests = []struct {
+	expr    string
+	matches bool
+}{
+	{`port==8443`, true},
+	{`port==443`, false},
+	{`port!=443`, true},
+	{`port>8000 && port<9000`, true},
+	{`port>=8443 && port<=8443`, true},
+	{`port>8443`, false},
+	{`tls==true`, true},
+	{`tls==false`, false},
+	{`tls!=false`, true},
+
+	// && binds stronger than ||.
+	{`port==1 && tls==true || host==x.com`, true},
+	{`host==x.com || port==1 && tls==false`, true},
+	{`port==1 || tls==true && host==y.com`, false},
+	{`(port==1 || tls==true) && host==x.com`, true},
+	{`(port==1 || tls==true) && host==y.com`, false},
+	{`((port==8443))`, true},
+
+	// ! binds stronger than comparisons combined with && and ||.
+	{`!tls==true`, false},
+	{`!(port==1)`, true},
+	{`!!tls==true`, true},
+	{`!port==1 && host==x.com`, true},
+	{`!(port==1 || host==x.com)`, false},
+
+	// Quoted strings.
+	{`host=="x.com"`, true},
+	{`host=="x.co"`, false},
+	{`req=="GET /a\"b HTTP/1.1"`, true},
+	{`host=="x\\.com"`, false},
+	{`host=="a && b" || port==8443`, true},
+
+	// Regular expressions.
+	{`host=~"^x\\."`, true},
+	{`host=~com$`, true},
+	{`req=~"^POST"`, false},
+	{`port=~"^84"`, true},
+	{`tls=~true`, true},
+
+	// Numbers compared with strings and the other way round.
+	{`port=="8443"`, true},
+	{`port<"9"`, true},
+	{`port<9`, false},
+	{`host==1`, false},
+	{`host!=1`, true},
+	{`host>1`, false},
+	{`tls==1`, false},
+
+	// Missing fields.
+	{`status==200`, false},
+	{`status!=200`, true},
+	{`status>0`, false},
+	{`status==null`, true},
+	{`status!=null`, false},
+	{`status==""`, true},
+	{`status=~"^$"`, true},
+	{`host==null`, false},
+}
+
+func TestFilter(t *testing.T) {
+	for i, test := range tests {
+		f, err := filter.Parse(test.expr)
+		if err != nil {
+			t.Errorf("%d. Got unexpected error for '%s': %v", i, test.expr, err)
+			continue
+		}
+		if matches := f.Matches(fields); matches != test.matches {
+			t.Errorf("%d. Got unexpected result %v for '%s'.", i, matches, test.expr)
+		}
+	}
+}
+
+var invalid = []string{
+	``,
+	`port`,
+	`port==`,
+	`port 8443`,
+	`==8443`,
+	`"port"==8443`,
+	`port==8443 &&`,
+	`|| port==8443`,
+	`port==8443 port==1`,
+	`(port==8443`,
+	`port==8443)`,
+	`()`,
+	`!`,
+	`port===8443`,
+	`port==(8443)`,
+	`port==8443 & tls==true`,
+	`host=~"("`,
+	`host=="unterminated`,
+}
+
+func TestInvalidFilter(t *testing.T) {
+	for i, expr := range invalid {
+		if _, err := filter.Parse(expr); err == nil {
+			t.Errorf("%d. Got no error for '%s'.", i, expr)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// fixLine fixes the Host and Content-Length headers of the request of
+// line, if -fix-req is given, and lists the fixes in the "reqfixes"
+// field.
+func fixLine(line *httpline) {
+	defaults := *line
+	defaults.SetDefaults()
+	line.Req, line.ReqFixes = fixRequest(line.Req, hostHeader(defaults.Host, defaults.Port, *defaults.TLS))
+}
+
+// hostHeader returns the value of the Host header for the given server.
+func hostHeader(host string, port int, useTLS bool) string {
+	if port == 80 && !useTLS || port == 443 && useTLS {
+		if strings.Contains(host, ":") {
+			return "[" + host + "]"
+		}
+		return host
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// fixRequest adds a Host header with the value host to req, if it is
+// missing, and makes the Content-Length header match the length of the
+// body, unless the chunked encoding is used. The fixed request and a
+// description of each fix are returned. Requests with an unterminated
+// head are returned unchanged.
+func fixRequest(req, host string) (string, []string) {
+	requestLine, rest, found := strings.Cut(req, "\n")
+	if !found {
+		return req, nil
+	}
+	eol := "\n"
+	if strings.HasSuffix(requestLine, "\r") {
+		eol = "\r\n"
+	}
+	var headers []string
+	var body string
+	terminated := false
+	for rest != "" && !terminated {
+		var line string
+		line, rest, found = strings.Cut(rest, "\n")
+		line = strings.TrimRight(line, "\r")
+		if line == "" && found {
+			body, terminated = rest, true
+		} else {
+			headers = append(headers, line)
+		}
+	}
+	if !terminated {
+		return req, nil
+	}
+	hasHost, chunked, lengthIndex := false, false, -1
+	for i, header := range headers {
+		name, value, _ := strings.Cut(header, ":")
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "host":
+			hasHost = true
+		case "transfer-encoding":
+			chunked = chunked || strings.Contains(strings.ToLower(value), "chunked")
+		case "content-length":
+			lengthIndex = i
+		}
+	}
+	var fixes []string
+	length := strconv.Itoa(len(body))
+	if !chunked && lengthIndex >= 0 {
+		_, value, _ := strings.Cut(headers[lengthIndex], ":")
+		if value = strings.TrimSpace(value); value != length {
+			headers[lengthIndex] = "Content-Length: " + length
+			fixes = append(fixes, fmt.Sprintf("changed Content-Length from '%s' to %s", value, length))
+		}
+	} else if !chunked && body != "" {
+		headers = append(headers, "Content-Length: "+length)
+		fixes = append(fixes, "added Content-Length: "+length)
+	}
+	if !hasHost {
+		headers = append([]string{"Host: " + host}, headers...)
+		fixes = append([]string{"added Host: " + host}, fixes...)
+	}
+	if len(fixes) == 0 {
+		return req, nil
+	}
+	var out strings.Builder
+	out.WriteString(strings.TrimRight(requestLine, "\r") + eol)
+	for _, header := range headers {
+		out.WriteString(header + eol)
+	}
+	out.WriteString(eol + body)
+	return out.String(), fixes
+}
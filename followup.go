@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/codesoap/preq/client"
+	"github.com/codesoap/preq/httpipe"
+)
+
+// followUpOptions are the per-line options for a follow-up request,
+// that is made after the request of the line.
+type followUpOptions struct {
+	// Next is the template of the follow-up request. Placeholders like
+	// {{name}} are replaced by the values captured from the response.
+	Next string `json:"next,omitempty"`
+
+	// Capture maps the names of values to regular expressions, that
+	// are matched against the response. The first capture group or, if
+	// there is none, the whole match is used as the value.
+	Capture map[string]string `json:"capture,omitempty"`
+
+	// NextConn is "new" to make the follow-up request on a new
+	// connection or "same" to make it on the connection of the first
+	// request. "new" is the default.
+	NextConn string `json:"nextconn,omitempty"`
+}
+
+// followUp is the outcome of a follow-up request. It is stored in the
+// "followup" field.
+type followUp struct {
+	Req       string            `json:"req"`
+	Vars      map[string]string `json:"vars,omitempty"`
+	Reqat     *httpipe.Time     `json:"reqat,omitempty"`
+	Ping      int64             `json:"ping,omitempty"`
+	Resp      string            `json:"resp,omitempty"`
+	Err       string            `json:"err,omitempty"`
+	Errno     int               `json:"errno,omitempty"`
+	Errdetail string            `json:"errdetail,omitempty"`
+	OSErr     string            `json:"oserr,omitempty"`
+}
+
+var placeholderRegexp = regexp.MustCompile(`\{\{(\w+)\}\}`)
+
+// checkFollowUp validates the follow-up options of request.
+func checkFollowUp(request httpline) error {
+	opts := request.followUpOptions
+	if opts.Next == "" {
+		if opts.Capture != nil || opts.NextConn != "" {
+			return fmt.Errorf("capture and nextconn require next")
+		}
+		return nil
+	}
+	switch opts.NextConn {
+	case "", "new":
+	case "same":
+		if useHTTP2(request) || useHTTP3(request) {
+			return fmt.Errorf("nextconn 'same' is only supported for HTTP/1")
+		}
+	default:
+		return fmt.Errorf("invalid nextconn '%s'", opts.NextConn)
+	}
+	for name, expr := range opts.Capture {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("invalid capture '%s': %w", name, err)
+		}
+	}
+	for _, m := range placeholderRegexp.FindAllStringSubmatch(opts.Next, -1) {
+		if _, ok := opts.Capture[m[1]]; !ok {
+			return fmt.Errorf("next uses unknown capture '%s'", m[1])
+		}
+	}
+	return nil
+}
+
+// renderFollowUp returns the follow-up request of request for the
+// response resp and the captured values. If -fix-req is given, the
+// Host and Content-Length headers of the follow-up request are fixed.
+func renderFollowUp(request httpline, resp string) (string, map[string]string, error) {
+	vars := make(map[string]string, len(request.Capture))
+	for name, expr := range request.Capture {
+		m := regexp.MustCompile(expr).FindStringSubmatch(resp)
+		if m == nil {
+			return "", vars, fmt.Errorf("capture '%s' did not match", name)
+		}
+		vars[name] = m[min(len(m)-1, 1)]
+	}
+	raw := placeholderRegexp.ReplaceAllStringFunc(request.Next, func(placeholder string) string {
+		return vars[strings.Trim(placeholder, "{}")]
+	})
+	if fixReqFlag {
+		defaults := request
+		defaults.SetDefaults()
+		raw, _ = fixRequest(raw, hostHeader(defaults.Host, defaults.Port, *defaults.TLS))
+	}
+	return raw, vars, nil
+}
+
+// followUpFunc returns the function for client.Request.FollowUp, if the
+// follow-up request of request shall be made on the same connection.
+func followUpFunc(request httpline) func(string) (string, error) {
+	if request.Next == "" || request.NextConn != "same" {
+		return nil
+	}
+	return func(resp string) (string, error) {
+		raw, _, err := renderFollowUp(request, resp)
+		return raw, err
+	}
+}
+
+// applyFollowUp stores the outcome of a follow-up request, that was made
+// on the same connection, in request.
+func applyFollowUp(request *httpline, result client.Result) {
+	if result.FollowUp == nil && result.FollowUpErr == nil {
+		return
+	}
+	raw, vars, err := renderFollowUp(*request, result.Resp)
+	if err != nil {
+		request.FollowUp = &followUp{Req: request.Next, Vars: vars}
+		setFollowUpValidationErr(request.FollowUp, err)
+		return
+	}
+	var next httpline
+	if result.FollowUp != nil {
+		applyResult(&next, *result.FollowUp, result.FollowUpErr)
+	} else {
+		setErr(&next, result.FollowUpErr)
+	}
+	request.FollowUp = toFollowUp(next, raw, vars)
+}
+
+// makeFollowUp makes the follow-up request of request on a new
+// connection, after result was received for the first request.
+func makeFollowUp(ctx context.Context, request, result httpline) *followUp {
+	if request.Next == "" || request.NextConn == "same" || result.Err != "" {
+		return nil
+	}
+	raw, vars, err := renderFollowUp(request, result.Resp)
+	if err != nil {
+		f := &followUp{Req: request.Next, Vars: vars}
+		setFollowUpValidationErr(f, err)
+		return f
+	}
+	next := request
+	next.Req, next.followUpOptions = raw, followUpOptions{}
+	next.ReqBody, next.BodyDelay, next.pacingOptions = "", "", pacingOptions{}
+	next.SendAt, next.Repeat = "", 0
+	jar.inject(&next)
+	injectAuth(&next)
+	logf(1, request, "making follow-up request")
+	next, _ = attemptRequest(ctx, next)
+	jar.store(next)
+	return toFollowUp(next, raw, vars)
+}
+
+func toFollowUp(next httpline, raw string, vars map[string]string) *followUp {
+	return &followUp{
+		Req:       raw,
+		Vars:      vars,
+		Reqat:     next.Reqat,
+		Ping:      next.Ping,
+		Resp:      next.Resp,
+		Err:       next.Err,
+		Errno:     next.Errno,
+		Errdetail: next.Errdetail,
+		OSErr:     next.OSErr,
+	}
+}
+
+func setFollowUpValidationErr(f *followUp, err error) {
+	f.Err, f.Errno, f.Errdetail = err.Error(), 99, "validation"
+}
@@ -0,0 +1,110 @@
+package main
+
+import "strings"
+
+// extractHeaders returns the values of the given headers from the head
+// of the final response in resp. Header names are matched
+// case-insensitively and used in lower case as keys of the returned
+// map. If a header occurs multiple times, its values are joined with
+// ", ". nil is returned if none of the headers are found.
+func extractHeaders(resp string, names []string) map[string]string {
+	if len(names) == 0 {
+		return nil
+	}
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[strings.ToLower(name)] = true
+	}
+	var hdr map[string]string
+	head, _, _ := splitHead(finalResponse(resp))
+	lines := strings.Split(head, "\n")
+	for _, line := range lines[1:] { // Skip status line.
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			break
+		}
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !wanted[name] {
+			continue
+		}
+		if hdr == nil {
+			hdr = make(map[string]string)
+		}
+		value = strings.TrimSpace(value)
+		if prev, ok := hdr[name]; ok {
+			hdr[name] = prev + ", " + value
+		} else {
+			hdr[name] = value
+		}
+	}
+	return hdr
+}
+
+// headerValues returns the values of all header fields called name in
+// the head of the HTTP message msg or, if msg contains interim
+// responses, of the final response. The name is matched
+// case-insensitively.
+func headerValues(msg, name string) []string {
+	var values []string
+	head, _, _ := splitHead(finalResponse(msg))
+	lines := strings.Split(head, "\n")
+	for _, line := range lines[1:] {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			break
+		}
+		n, value, found := strings.Cut(line, ":")
+		if found && strings.EqualFold(strings.TrimSpace(n), name) {
+			values = append(values, strings.TrimSpace(value))
+		}
+	}
+	return values
+}
+
+// withHeader returns the raw request req with all header fields called
+// name replaced by a single one with the given value. If there is no
+// such field, it is added at the end of the head.
+func withHeader(req, name, value string) string {
+	requestLine, rest, found := strings.Cut(req, "\n")
+	if !found {
+		return req
+	}
+	eol := "\n"
+	if strings.HasSuffix(requestLine, "\r") {
+		eol = "\r\n"
+	}
+	var out strings.Builder
+	out.WriteString(requestLine + "\n")
+	replaced := false
+	for rest != "" {
+		var line string
+		line, rest, found = strings.Cut(rest, "\n")
+		trimmed := strings.TrimRight(line, "\r")
+		if trimmed == "" {
+			// End of head; copy the rest untouched.
+			if !replaced {
+				out.WriteString(name + ": " + value + eol)
+			}
+			out.WriteString(line)
+			if found {
+				out.WriteString("\n" + rest)
+			}
+			return out.String()
+		}
+		n, _, _ := strings.Cut(trimmed, ":")
+		if !strings.EqualFold(strings.TrimSpace(n), name) {
+			out.WriteString(line + "\n")
+		} else if !replaced {
+			out.WriteString(name + ": " + value + eol)
+			replaced = true
+		}
+	}
+	if !replaced {
+		out.WriteString(name + ": " + value + eol)
+	}
+	return out.String()
+}
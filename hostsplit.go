@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"container/list"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxOpenHostFiles limits the number of files of -split-by-host, that
+// are open at the same time.
+const maxOpenHostFiles = 64
+
+// hostSplit writes the result lines of each host to its own file in
+// the directory of -split-by-host. Only the most recently used files
+// are kept open; the others are closed and reopened for appending when
+// needed. With -z, every reopening starts a new gzip member, which gzip
+// readers handle transparently. A nil *hostSplit writes nothing.
+type hostSplit struct {
+	mu    sync.Mutex
+	dir   string
+	hosts map[string]*hostFile
+	open  *list.List // The open hostFiles, most recently used first.
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// hostFile is the output file of a single host.
+type hostFile struct {
+	split   *hostSplit
+	path    string
+	created bool          // Whether the file was created in this run.
+	elem    *list.Element // The element in split.open, if the file is open.
+	file    *os.File
+	buf     *bufio.Writer
+	gz      *gzip.Writer // nil without -z.
+}
+
+// hostOutputs is nil, if -split-by-host is not given.
+var hostOutputs *hostSplit
+
+func newHostSplit(dir string) (*hostSplit, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &hostSplit{
+		dir:   dir,
+		hosts: make(map[string]*hostFile),
+		open:  list.New(),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go s.flushPeriodically()
+	return s, nil
+}
+
+// writer returns the writer for the result lines of host.
+func (s *hostSplit) writer(host string) io.Writer {
+	name := hostFileName(host) + ".jsonl"
+	if formatFlag != "json" {
+		name = hostFileName(host) + "." + formatFlag
+	}
+	if zFlag {
+		name += ".gz"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.hosts[name]
+	if !ok {
+		f = &hostFile{split: s, path: filepath.Join(s.dir, name)}
+		s.hosts[name] = f
+	}
+	return f
+}
+
+// hostFileName returns a file name for host, which contains only lower
+// case letters, digits, dots, hyphens and underscores.
+func hostFileName(host string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '_'
+	}, host)
+	if name == "" || strings.Trim(name, ".") == "" {
+		name = "_" + name
+	}
+	return name
+}
+
+func (f *hostFile) Write(p []byte) (int, error) {
+	f.split.mu.Lock()
+	defer f.split.mu.Unlock()
+	if f.elem == nil {
+		if err := f.reopen(); err != nil {
+			return 0, err
+		}
+	}
+	f.split.open.MoveToFront(f.elem)
+	return f.buf.Write(p)
+}
+
+// reopen opens the file of f, closing the least recently used file if
+// too many are open. The file is truncated, if it is opened for the
+// first time in this run.
+func (f *hostFile) reopen() error {
+	if f.split.open.Len() >= maxOpenHostFiles {
+		if err := f.split.open.Back().Value.(*hostFile).close(); err != nil {
+			return err
+		}
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if !f.created {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	file, err := os.OpenFile(f.path, flags, 0644)
+	if err != nil {
+		return err
+	}
+	f.file, f.created = file, true
+	var w io.Writer = file
+	f.gz = nil
+	if zFlag {
+		f.gz = gzip.NewWriter(file)
+		w = f.gz
+	}
+	f.buf = bufio.NewWriterSize(w, 4*1024)
+	f.elem = f.split.open.PushFront(f)
+	return nil
+}
+
+// close writes the buffered data of f and closes its file.
+func (f *hostFile) close() error {
+	f.split.open.Remove(f.elem)
+	f.elem = nil
+	err := f.buf.Flush()
+	if err == nil && f.gz != nil {
+		err = f.gz.Close()
+	}
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (f *hostFile) flush() error {
+	if err := f.buf.Flush(); err != nil {
+		return err
+	}
+	if f.gz != nil {
+		return f.gz.Flush()
+	}
+	return nil
+}
+
+func (s *hostSplit) flushPeriodically() {
+	defer close(s.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			for e := s.open.Front(); e != nil; e = e.Next() {
+				if err := e.Value.(*hostFile).flush(); err != nil {
+					fmt.Fprintln(os.Stderr, "Error: Could not write result:", err)
+					os.Exit(1)
+				}
+			}
+			s.mu.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// close writes all buffered output and closes the files of s.
+func (s *hostSplit) close() {
+	if s == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	for s.open.Len() > 0 {
+		if err := s.open.Front().Value.(*hostFile).close(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: Could not close output:", err)
+			os.Exit(1)
+		}
+	}
+}
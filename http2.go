@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/codesoap/preq/client"
+	"github.com/codesoap/preq/extractor"
+)
+
+// h2cMode is the value of the "h2c" field.
+type h2cMode string
+
+const (
+	h2cPriorKnowledge h2cMode = "prior-knowledge"
+	h2cUpgrade        h2cMode = "upgrade"
+)
+
+func (m *h2cMode) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	switch h2cMode(s) {
+	case "", h2cPriorKnowledge, h2cUpgrade:
+		*m = h2cMode(s)
+		return nil
+	}
+	return fmt.Errorf("invalid h2c mode '%s'", s)
+}
+
+// h2Info is the content of the "h2info" field.
+type h2Info struct {
+	Stream   uint32            `json:"stream"`
+	Settings map[string]uint32 `json:"settings,omitempty"`
+	Frames   map[string]int    `json:"frames,omitempty"`
+	Trailers map[string]string `json:"trailers,omitempty"`
+	RST      string            `json:"rst,omitempty"`
+	GoAway   string            `json:"goaway,omitempty"`
+}
+
+// useHTTP2 returns whether request should be made using HTTP/2.
+func useHTTP2(request httpline) bool {
+	if request.H2C != "" {
+		return true
+	} else if request.H2 != nil {
+		return *request.H2
+	}
+	return http2Flag
+}
+
+func toH2Info(info *client.HTTP2Info) *h2Info {
+	return &h2Info{
+		Stream:   info.StreamID,
+		Settings: info.Settings,
+		Frames:   info.Frames,
+		RST:      info.RSTCode,
+		GoAway:   info.GoAwayCode,
+		Trailers: joinFields(info.Trailers),
+	}
+}
+
+// joinFields returns fields as map with the values of repeated fields
+// joined with ", ", or nil if fields is empty.
+func joinFields(fields []extractor.Field) map[string]string {
+	var joined map[string]string
+	for _, field := range fields {
+		if joined == nil {
+			joined = make(map[string]string)
+		}
+		if prev, ok := joined[field.Name]; ok {
+			joined[field.Name] = prev + ", " + field.Value
+		} else {
+			joined[field.Name] = field.Value
+		}
+	}
+	return joined
+}
@@ -0,0 +1,26 @@
+package main
+
+import "github.com/codesoap/preq/client"
+
+// h3Info is the content of the "h3info" field.
+type h3Info struct {
+	QUICVersion string            `json:"quicversion"`
+	Used0RTT    bool              `json:"used0rtt,omitempty"`
+	Trailers    map[string]string `json:"trailers,omitempty"`
+}
+
+// useHTTP3 returns whether request should be made using HTTP/3.
+func useHTTP3(request httpline) bool {
+	if request.H3 != nil {
+		return *request.H3
+	}
+	return http3Flag
+}
+
+func toH3Info(info *client.HTTP3Info) *h3Info {
+	return &h3Info{
+		QUICVersion: info.QUICVersion,
+		Used0RTT:    info.Used0RTT,
+		Trailers:    joinFields(info.Trailers),
+	}
+}
@@ -0,0 +1,81 @@
+// Package httpipe defines lines of the httpipe format, as documented at
+// https://github.com/codesoap/httpipe, and the rules for parsing them.
+//
+// Tools that add their own fields can embed Line in their own struct.
+package httpipe
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Line is a single line of httpipe.
+type Line struct {
+	Host string `json:"host"`
+	Port int    `json:"port,omitempty"`
+	TLS  *bool  `json:"tls,omitempty"`
+	Req  string `json:"req"`
+
+	Reqat *Time  `json:"reqat,omitempty"`
+	Ping  int64  `json:"ping,omitempty"`
+	Resp  string `json:"resp,omitempty"`
+	Err   string `json:"err,omitempty"`
+	Errno int    `json:"errno,omitempty"`
+}
+
+// Parse parses a line leniently: unknown fields are ignored and the
+// line is not validated.
+func Parse(data []byte) (Line, error) {
+	var line Line
+	err := json.Unmarshal(data, &line)
+	return line, err
+}
+
+// ParseStrict parses a line, rejecting unknown fields and lines that
+// are not valid according to Line.Validate.
+func ParseStrict(data []byte) (Line, error) {
+	var line Line
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&line); err != nil {
+		return line, err
+	}
+	if dec.More() {
+		return line, errors.New("unexpected data after line")
+	}
+	return line, line.Validate()
+}
+
+// Validate checks that the required fields are present and that the
+// port is valid.
+func (l *Line) Validate() error {
+	switch {
+	case l.Host == "":
+		return errors.New("missing host")
+	case l.Req == "":
+		return errors.New("missing req")
+	case l.Port < 0 || l.Port > 65535:
+		return fmt.Errorf("invalid port %d", l.Port)
+	}
+	return nil
+}
+
+// SetDefaults sets the missing optional fields that describe the
+// connection: If the "tls" field is missing, TLS will be used. If the
+// "port" field is missing, port 80 will be used if TLS is not used and
+// port 443 otherwise.
+func (l *Line) SetDefaults() {
+	if l.TLS == nil {
+		t := true
+		l.TLS = &t
+	}
+	if l.Port == 0 {
+		if !*l.TLS {
+			l.Port = 80
+		} else {
+			l.Port = 443
+		}
+	}
+}
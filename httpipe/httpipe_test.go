@@ -0,0 +1,70 @@
+package httpipe_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/codesoap/preq/httpipe"
+)
+
+type parseTest struct {
+	in           string
+	strictErr    bool
+	lenientErr   bool
+	expectedTLS  bool
+	expectedPort int
+}
+
+var parseTests = []parseTest{
+	{`{"host":"x.com","req":"GET / HTTP/1.1\r\n\r\n"}`, false, false, true, 443},
+	{`{"host":"x.com","tls":false,"req":"GET / HTTP/1.1\r\n\r\n"}`, false, false, false, 80},
+	{`{"host":"x.com","port":8080,"req":"GET / HTTP/1.1\r\n\r\n"}`, false, false, true, 8080},
+	{`{"host":"x.com","foo":1,"req":"GET / HTTP/1.1\r\n\r\n"}`, true, false, true, 443},
+	{`{"req":"GET / HTTP/1.1\r\n\r\n"}`, true, false, true, 443},
+	{`{"host":"x.com","port":70000,"req":"GET / HTTP/1.1\r\n\r\n"}`, true, false, true, 70000},
+	{`{"host":"x.com","req":"GET / HTTP/1.1\r\n\r\n"} {}`, true, true, true, 443},
+}
+
+func TestParse(t *testing.T) {
+	for i, tt := range parseTests {
+		_, err := httpipe.ParseStrict([]byte(tt.in))
+		if (err != nil) != tt.strictErr {
+			t.Errorf("%d. Got unexpected strict parsing result: %v", i, err)
+		}
+		line, err := httpipe.Parse([]byte(tt.in))
+		if (err != nil) != tt.lenientErr {
+			t.Errorf("%d. Got unexpected lenient parsing result: %v", i, err)
+		}
+		if err != nil {
+			continue
+		}
+		line.SetDefaults()
+		if *line.TLS != tt.expectedTLS || line.Port != tt.expectedPort {
+			t.Errorf("%d. Got unexpected defaults: tls=%v port=%d", i, *line.TLS, line.Port)
+		}
+	}
+}
+
+func TestTime(t *testing.T) {
+	defer func(layout string) { httpipe.TimeLayout = layout }(httpipe.TimeLayout)
+	tests := []struct {
+		layout string
+		in     string
+		out    string
+	}{
+		{time.RFC3339, `"2024-05-01T12:00:00.123456Z"`, `"2024-05-01T12:00:00Z"`},
+		{"2006-01-02T15:04:05.000Z07:00", `"2024-05-01T14:00:00.123456+02:00"`, `"2024-05-01T12:00:00.123Z"`},
+		{time.RFC3339Nano, `"2024-05-01T12:00:00Z"`, `"2024-05-01T12:00:00Z"`},
+	}
+	for i, tt := range tests {
+		httpipe.TimeLayout = tt.layout
+		var ts httpipe.Time
+		if err := ts.UnmarshalJSON([]byte(tt.in)); err != nil {
+			t.Errorf("%d. Could not unmarshal: %v", i, err)
+			continue
+		}
+		if out, _ := ts.MarshalJSON(); string(out) != tt.out {
+			t.Errorf("%d. Got %s, wanted %s", i, out, tt.out)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package httpipe
+
+import "time"
+
+// TimeLayout is the layout used to marshal Time. httpipe requires
+// RFC 3339 timestamps in UTC; fractional seconds may be added for more
+// precision, e.g. with "2006-01-02T15:04:05.000Z07:00". Unmarshaling
+// accepts timestamps with and without fractional seconds regardless of
+// TimeLayout.
+var TimeLayout = time.RFC3339
+
+// Time is a time.Time that is marshaled in the format used by httpipe.
+type Time time.Time
+
+func (t Time) MarshalJSON() ([]byte, error) {
+	return []byte(time.Time(t).UTC().Format(`"` + TimeLayout + `"`)), nil
+}
+
+func (t *Time) UnmarshalJSON(data []byte) error {
+	parsed, err := time.Parse(`"`+time.RFC3339Nano+`"`, string(data))
+	*t = Time(parsed)
+	return err
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/codesoap/preq/httpipe"
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// input is a source of input lines.
+type input struct {
+	name string
+	r    io.Reader
+}
+
+// openInputs opens the files of -in or standard input, if none are
+// given, and decompresses them according to -in-compression.
+func openInputs() ([]input, error) {
+	if len(inFlag) == 0 {
+		r, err := decompress(os.Stdin, inCompressionFlag)
+		return []input{{"standard input", r}}, err
+	}
+	inputs := make([]input, len(inFlag))
+	for i, path := range inFlag {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		if inputs[i].r, err = decompress(f, inCompressionFlag); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		inputs[i].name = path
+	}
+	return inputs, nil
+}
+
+// decompress returns a reader, that decompresses r according to
+// compression, which is "gzip", "zstd", "none" or "auto". With "auto",
+// the compression is detected by the magic number of the data.
+func decompress(r io.Reader, compression string) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if compression == "auto" {
+		compression = "none"
+		magic, _ := br.Peek(len(zstdMagic))
+		if bytes.HasPrefix(magic, gzipMagic) {
+			compression = "gzip"
+		} else if bytes.HasPrefix(magic, zstdMagic) {
+			compression = "zstd"
+		}
+	}
+	switch compression {
+	case "gzip":
+		return gzip.NewReader(br)
+	case "zstd":
+		return zstd.NewReader(br)
+	case "none":
+		return br, nil
+	}
+	return nil, fmt.Errorf("unknown compression '%s'", compression)
+}
+
+// clearResult removes the results of a previous run from line, so that
+// lines of an output, like those of -err-out, can be fed back in to
+// retry them. Only the fields, that describe the request, are kept.
+func clearResult(line *httpline) {
+	in := *line
+	*line = httpline{
+		Line:      httpipe.Line{Host: in.Host, Port: in.Port, TLS: in.TLS, Req: in.Req},
+		Addresses: in.Addresses,
+		H2:        in.H2,
+		H2C:       in.H2C,
+		H3:        in.H3,
+		ALPN:      in.ALPN,
+		Repeat:    in.Repeat,
+		SendAt:    in.SendAt,
+		ReqBody:   in.ReqBody,
+		BodyDelay: in.BodyDelay,
+		Auth:      in.Auth,
+		MaxBPS:    in.MaxBPS,
+		ReqFile:   in.ReqFile,
+		BodyFile:  in.BodyFile,
+		FanOut:    in.FanOut,
+		ID:        in.ID,
+		OrigReq:   in.OrigReq,
+
+		tlsOptions:      in.tlsOptions,
+		sourceOptions:   in.sourceOptions,
+		pacingOptions:   in.pacingOptions,
+		followUpOptions: in.followUpOptions,
+		proxyOptions:    in.proxyOptions,
+
+		N:     in.N,
+		input: in.input,
+	}
+}
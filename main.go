@@ -8,201 +8,1151 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"net"
+	"io"
+	"math/rand"
 	"os"
+	"os/signal"
 	"strings"
-	"sync"
 	"syscall"
 	"time"
 
-	"github.com/codesoap/preq/extractor"
+	"github.com/codesoap/preq/client"
+	"github.com/codesoap/preq/filter"
+	"github.com/codesoap/preq/httpipe"
 )
 
 // TODO: do not touch lines with already filled err or resp?!
 
 var usageDetails = `
-preq expects input via standard input in the httpipe format. At least
-the "host" and "req" fields must be present. If the "tls" field is
-missing, TLS (HTTPS) will be used. If the "port" field is missing, port
-80 will be used if TLS is not used and port 443 otherwise.
+preq expects input via standard input, or from the files given with
+-in, in the httpipe format. gzip or zstd compressed input is
+decompressed. At least the "host" and "req" fields must be present. If
+the "tls" field is missing, TLS (HTTPS) will be used. If the "port"
+field is missing, port 80 will be used if TLS is not used and port 443
+otherwise.
+
+If the optional "h2" field is true, the request is made using HTTP/2.
+See the -http2 flag. Without TLS, HTTP/2 is used with prior knowledge.
+The optional "h2c" field selects how cleartext HTTP/2 is started and
+implies HTTP/2: "prior-knowledge" or "upgrade", for an HTTP/1.1 request
+with an "Upgrade: h2c" header. If the optional "h3" field is true, the
+request is made using HTTP/3. See the -http3 flag. The optional "alpn"
+field is a list of protocols to offer via ALPN. See the -alpn flag.
+The optional "tlsmin", "tlsmax" and "tlsciphers" fields override the
+-tls-min, -tls-max and -tls-ciphers flags; "tlsciphers" is a list. The
+optional "hello" field overrides the -tls-hello flag. The optional
+"tlsopts" object with "insecure", "minversion", "sni", "alpn" and
+"clientcertref" overrides the TLS flags and fields. Likewise the
+optional "sourceip" and "interface" fields override the -source-ip and
+-interface flags. The optional "repeat" field overrides the -repeat
+flag. If the optional "sendat" field holds an RFC 3339 timestamp, the
+request is sent at that time. The optional "auth" field overrides the
+-basic and -bearer flags; its value is "basic user:pass" or
+"bearer token". The optional "writesize", "writedelay"
+and "finalpause" fields make preq write the request slowly: in chunks
+of "writesize" bytes, with a pause of "writedelay" between them and a
+pause of "finalpause" before the CRLFCRLF ending the head, e.g. "1s".
+If the optional "reqbody" field is set, it is written after "req" once
+the server answered with 100 Continue or the optional "bodydelay"
+elapsed. Interim responses received before are stored in "interim" and
+"bodysent" is set if the body was written.
+
+If the optional "addresses" field contains a list of IP addresses, no
+DNS lookup is done for the host. Instead the addresses are tried until
+a connection could be established. The optional "fanout" field
+overrides the -fan-out flag. The optional "proxy" and "proxybearer"
+fields override the -proxy and -proxy-bearer flags.
+
+The "n" field of output lines holds the number of their input line.
+The optional "id" field is copied to the output lines as is.
+
+When preq receives SIGINT or SIGTERM, it stops reading input, aborts
+the running requests and prints their lines before exiting. Send the
+signal again to exit immediately.
 
 preq will make requests in the order they arrived via standard input.
 However, if the value of the -p flag is greater than 1, the order of the
 output lines may not match the input.
 
+"preq convert" reads the output of preq via standard input and prints
+it with responses from the "resp_zstd64" field decompressed into the
+"resp" field.
+
 Example:
 echo '{"host":"x.com","req":"GET / HTTP/1.1\\r\\nHost: x.com\\r\\n\\r\\n"}' | preq
 `
 
 var timeout time.Duration
 var pFlag int
+var extractHeaderFlag stringList
+var extractFlag regexpList
+var tlsVerifyFlag string
+var clientCertFlag stringList
+var statsFlag bool
+var progressFlag bool
+var autoRecoverFlag bool
+var dryRunFlag bool
+var respCompressFlag int
+var maxFailuresFlag failureThreshold
+var dedupeFlag bool
+var errOutFlag string
+var okOutFlag string
+var runIDFlag string
+var maxLineSizeFlag int
+var respDirFlag string
+var strictFlag bool
+var pipelineFlag int
+var http2Flag bool
+var http3Flag bool
+var alpnFlag stringList
+var tlsMinFlag string
+var tlsMaxFlag string
+var tlsCiphersFlag string
+var tlsSessionCacheFlag bool
+var tlsWarmFlag stringList
+var utf8Flag bool
+var selftestFlag bool
+var tlsNoTicketsFlag bool
+var tlsHelloFlag string
+var sourceIPFlag string
+var interfaceFlag string
+var tcpNoDelayFlag bool
+var fallbackDelayFlag time.Duration
+var tcpKeepAliveFlag time.Duration
+var tcpSndBufFlag int
+var tcpRcvBufFlag int
+var ttlFlag int
+var delayFlag time.Duration
+var jitterFlag time.Duration
+var delayPerWorkerFlag bool
+var shuffleFlag int
+var interleaveFlag int
+var breakerFlag int
+var autoPFlag bool
+var repeatFlag int
+var repeatSummaryFlag bool
+var wsFramesFlag int
+var wsTimeFlag time.Duration
+var streamMaxBytesFlag int64
+var streamMaxTimeFlag time.Duration
+var fixReqFlag bool
+var cookiesFlag string
+var basicFlag string
+var bearerFlag string
+var cacheFlag bool
+var retryStatusFlag string
+var onlyStatusFlag string
+var excludeStatusFlag string
+var onlyErrnoFlag string
+var excludeErrnoFlag string
+var closeWaitFlag time.Duration
+var rawFlag bool
+var zFlag bool
+var sampleFlag samplePercentage
+var seedFlag int64
+var rangeFlag lineRange
+var unselectedFlag string
+var filterFlag string
+var stopAfterOKFlag int64
+var stopAfterTotalFlag int64
+var inFlag stringList
+var inCompressionFlag string
+var formatFlag string
+var fieldsFlag string
+var oFlag string
+var rotateSizeFlag int64
+var rotateTimeFlag time.Duration
+var maxBufferedBytesFlag int64
+var timePrecisionFlag string
+var captureDirFlag string
+var captureLinesFlag string
+var rawMaxFlag int
+var retryMaxFlag int
+var retryAfterMaxFlag time.Duration
+var controlSocketFlag string
+var metricsListenFlag string
+var maxBPSFlag int
+var maxBPSConnFlag int
+var proxyFlag string
+var proxyBearerFlag string
+var conformanceFlag bool
+var keepAliveProbeFlag time.Duration
+var prefetchDNSFlag int
+var fanOutFlag bool
+var baselineFlag string
+var runTimeoutFlag time.Duration
+var splitByHostFlag string
+
+var requester *client.Client
 
+// lineID is a user-provided value of any JSON type, that identifies an
+// input line. It is copied to the output lines as is.
+type lineID = json.RawMessage
+
+// httpline is a line of httpipe with the additional fields of preq.
 type httpline struct {
-	Host string `json:"host"`
-	Port int    `json:"port,omitempty"`
-	TLS  *bool  `json:"tls,omitempty"`
-	Req  string `json:"req"`
+	httpipe.Line
+	Addresses []string `json:"addresses,omitempty"`
+	H2        *bool    `json:"h2,omitempty"`
+	H2C       h2cMode  `json:"h2c,omitempty"`
+	H3        *bool    `json:"h3,omitempty"`
+	ALPN      []string `json:"alpn,omitempty"`
+	Repeat    int      `json:"repeat,omitempty"`
+	SendAt    string   `json:"sendat,omitempty"`
+	ReqBody   string   `json:"reqbody,omitempty"`
+	BodyDelay string   `json:"bodydelay,omitempty"`
+	Auth      string   `json:"auth,omitempty"`
+	MaxBPS    int      `json:"maxbps,omitempty"`
+	ReqFile   string   `json:"reqfile,omitempty"`
+	BodyFile  string   `json:"bodyfile,omitempty"`
+	FanOut    bool     `json:"fanout,omitempty"`
+	ID        lineID   `json:"id,omitempty"`
+	tlsOptions
+	sourceOptions
+	pacingOptions
+	followUpOptions
+	proxyOptions
+
+	N          int                   `json:"n,omitempty"`
+	ReqFixes   []string              `json:"reqfixes,omitempty"`
+	OrigReq    string                `json:"origreq,omitempty"`
+	ReqWarn    []string              `json:"reqwarn,omitempty"`
+	RunID      string                `json:"runid,omitempty"`
+	QueuedAt   *httpipe.Time         `json:"queued_at,omitempty"`
+	RespZstd64 string                `json:"resp_zstd64,omitempty"`
+	RespSHA256 string                `json:"respsha256,omitempty"`
+	RespFile   string                `json:"respfile,omitempty"`
+	Status     int                   `json:"status,omitempty"`
+	Reason     string                `json:"reason,omitempty"`
+	Hdr        map[string]string     `json:"hdr,omitempty"`
+	Matches    map[string][][]string `json:"matches,omitempty"`
+	Body       string                `json:"body,omitempty"`
+	Charset    string                `json:"charset,omitempty"`
+	BlockType  string                `json:"block_type,omitempty"`
+	Laxities   []string              `json:"laxities,omitempty"`
+	Violations []violation           `json:"violations,omitempty"`
+	Framing    string                `json:"framing,omitempty"`
+	FrameRule  string                `json:"framingrule,omitempty"`
+	Retried    bool                  `json:"retried,omitempty"`
+	Retries    int                   `json:"retries,omitempty"`
+	Errdetail  string                `json:"errdetail,omitempty"`
+	OSErr      string                `json:"oserr,omitempty"`
+	Certerr    *certProblem          `json:"certerr,omitempty"`
+	H2Info     *h2Info               `json:"h2info,omitempty"`
+	H3Info     *h3Info               `json:"h3info,omitempty"`
+	ALPNProto  string                `json:"alpnproto,omitempty"`
+	TLSVersion string                `json:"tlsversion,omitempty"`
+	TLSCipher  string                `json:"tlscipher,omitempty"`
+	TLSResumed bool                  `json:"tlsresumed,omitempty"`
+	Warm       bool                  `json:"warm,omitempty"`
+	Laddr      string                `json:"laddr,omitempty"`
+	Addr       string                `json:"addr,omitempty"`
+	Interim    string                `json:"interim,omitempty"`
+	BodySent   bool                  `json:"bodysent,omitempty"`
+	Frames     []wsFrame             `json:"frames,omitempty"`
+	Streamed   bool                  `json:"streamed,omitempty"`
+	Cached     bool                  `json:"cached,omitempty"`
+	Attempt    int                   `json:"attempt,omitempty"`
+	Bench      *bench                `json:"bench,omitempty"`
+	PingMS     float64               `json:"pingms,omitempty"`
+	DurMS      float64               `json:"durms,omitempty"`
+	ConnClose  *connClose            `json:"connclose,omitempty"`
+	KeepAlive  *keepAlive            `json:"keepalive,omitempty"`
+	Chunks     *chunks               `json:"chunks,omitempty"`
+	RawResp    []byte                `json:"rawresp,omitempty"`
+	Transcript string                `json:"transcript,omitempty"`
+	FollowUp   *followUp             `json:"followup,omitempty"`
+	Baseline   *baselineDiff         `json:"baseline,omitempty"`
+
+	transcript  []client.TranscriptEntry // Used for -capture-dir.
+	untouched   []byte                   // The input line, if it is passed to the output as is.
+	fileErr     error                    // The error that occurred while reading "reqfile" or "bodyfile".
+	fileBodyLen int                      // The length of the body read from "bodyfile".
+	input       []byte                   // The input line, used for -stop-after-ok and -stop-after-total.
+	inputReq    string                   // The request before cookies, credentials or fixes were added.
+}
+
+// hiddenFlags are left out of the usage message.
+var hiddenFlags = map[string]bool{"selftest": true}
 
-	Reqat *jtime `json:"reqat,omitempty"`
-	Ping  int64  `json:"ping,omitempty"`
-	Resp  string `json:"resp,omitempty"`
-	Err   string `json:"err,omitempty"`
-	Errno int    `json:"errno,omitempty"`
+// printDefaults is like flag.PrintDefaults, but leaves out hiddenFlags.
+func printDefaults() {
+	visible := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	visible.SetOutput(flag.CommandLine.Output())
+	flag.VisitAll(func(f *flag.Flag) {
+		if !hiddenFlags[f.Name] {
+			visible.Var(f.Value, f.Name, f.Usage)
+			visible.Lookup(f.Name).DefValue = f.DefValue
+		}
+	})
+	visible.PrintDefaults()
 }
 
 func init() {
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
-		flag.PrintDefaults()
+		printDefaults()
 		fmt.Fprint(flag.CommandLine.Output(), usageDetails)
 	}
 
 	flag.DurationVar(&timeout, "t", 5*time.Second, "Timeout for requests.")
+	flag.IntVar(&interleaveFlag, "interleave", 0, "Reorder the input within a window of `n` lines, so that the\nhosts of consecutive requests take turns. The order of requests to\nthe same host is kept.")
+	flag.BoolVar(&fixReqFlag, "fix-req", false, "Add a missing Host header to requests, derived from the \"host\"\nand \"port\" fields, and correct their Content-Length header to match\nthe body. The fixes are listed in the \"reqfixes\" field.")
+	flag.DurationVar(&jitterFlag, "jitter", 0, "Add a random pause below `duration` to the -delay between\nrequests.")
+	flag.Int64Var(&maxBufferedBytesFlag, "max-buffered-bytes", 0, "Pause making new requests while the responses, that have been\nreceived but not written yet, exceed `n` bytes in total. 0 means no\nlimit.")
+	flag.IntVar(&maxLineSizeFlag, "max-line-size", 0, "If an output line would be longer than `n` bytes, remove the\nresponse from it. Only its SHA-256 digest is kept in the\n\"respsha256\" field. 0 means no limit.")
+	flag.StringVar(&timePrecisionFlag, "time-precision", "s", "The `precision` of timestamps like \"reqat\" in the output: \"s\",\n\"ms\", \"us\" or \"ns\".")
 	flag.IntVar(&pFlag, "p", 1, "Number of parallel requests.")
+	flag.IntVar(&pipelineFlag, "pipeline", 0, "Send up to `n` consecutive requests with the same host, port and\nTLS setting back-to-back on a single connection, using HTTP/1.1\npipelining. Values below 2 disable pipelining.")
+	flag.StringVar(&oFlag, "o", "", "Write the output to `file` instead of standard output. If the\nname contains \"%d\", the output is rotated according to -rotate-size\nand -rotate-time and \"%d\" is replaced by a sequence number.")
+	flag.Int64Var(&rotateSizeFlag, "rotate-size", 0, "Continue with the next file of -o after `n` bytes of output.")
+	flag.DurationVar(&rotateTimeFlag, "rotate-time", 0, "Continue with the next file of -o after `duration`.")
+	flag.StringVar(&formatFlag, "format", "json", "The `format` of the output: \"json\" for httpipe or \"csv\" or \"tsv\"\nfor a table with the columns given by -fields.")
+	flag.StringVar(&fieldsFlag, "fields", "host,port,status,ping,errno,bodyhash", "The comma separated `list` of columns for -format csv and tsv.\nBesides the fields of the output lines, \"bodyhash\" (the SHA-256\ndigest of the response body) and \"bodylen\" can be used.")
+	flag.Var(&inFlag, "in", "Read the input from `file` instead of standard input. Can be given\nmultiple times to read several files one after another.")
+	flag.StringVar(&inCompressionFlag, "in-compression", "auto", "The `compression` of the input: \"gzip\", \"zstd\" or \"none\". With\n\"auto\", the compression of each input is detected.")
+	flag.Var(&sampleFlag, "sample", "Request only a random sample of `percent` of the input lines,\ne.g. \"10%\". See also -seed and -unselected.")
+	flag.Int64Var(&seedFlag, "seed", 0, "The `seed` for -sample. 0 means a random seed.")
+	flag.Var(&rangeFlag, "range", "Request only the input lines with numbers in the `range` N:M,\nincluding N and M. N or M may be omitted. See also -unselected.")
+	flag.Int64Var(&stopAfterOKFlag, "stop-after-ok", 0, "Stop making requests after `n` successful requests. The remaining\ninput lines are written to the output untouched.")
+	flag.Int64Var(&stopAfterTotalFlag, "stop-after-total", 0, "Stop making requests after `n` requests. The remaining input lines\nare written to the output untouched.")
+	flag.StringVar(&filterFlag, "filter", "", "Request only the input lines matching `expr`, like\n'port==8443 && tls==true'. See also -unselected.")
+	flag.StringVar(&unselectedFlag, "unselected", "drop", "What to do with input lines, that are not selected for requests:\n\"drop\" them or \"pass\" them to the output untouched.")
+	flag.BoolVar(&selftestFlag, "selftest", false, "Make requests to local test servers and check the results.")
+	flag.BoolVar(&utf8Flag, "utf8", false, "Store the body of text responses converted to UTF-8 in the \"body\"\nfield and the charset it was converted from in \"charset\". \"resp\"\nkeeps the raw response.")
+	flag.BoolVar(&zFlag, "z", false, "Compress the output with gzip.")
+	flag.StringVar(&errOutFlag, "err-out", "", "Write lines of failed requests to `file` instead of standard\noutput.")
+	flag.Var(&extractFlag, "extract", "Store matches of the regular expression `regex` on the response\nbody in the \"matches\" field. If the expression contains capture\ngroups, only the captured groups are stored. Can be given multiple\ntimes.")
+	flag.BoolVar(&http2Flag, "http2", false, "Use HTTP/2 for requests without the \"h2\" field. The raw requests\nare translated to HTTP/2 and the responses are stored in an\nequivalent textual form. Details about the HTTP/2 stream are stored\nin the \"h2info\" field.")
+	flag.BoolVar(&http3Flag, "http3", false, "Experimental: Use HTTP/3 over QUIC for requests without the \"h3\"\nfield. Like with -http2, the raw requests are translated. Details\nabout the QUIC connection are stored in the \"h3info\" field.")
+	flag.Var(&extractHeaderFlag, "extract-header", "Store the value of the response header `name` in the \"hdr\"\nfield. Can be given multiple times.")
+	flag.Var(&alpnFlag, "alpn", "Offer the protocol `proto` via ALPN in TLS handshakes of requests\nwithout the \"alpn\" field. Can be given multiple times. The protocol\nselected by the server is stored in the \"alpnproto\" field.")
+	flag.BoolVar(&autoPFlag, "auto-p", false, "Adjust the number of parallel requests automatically, starting\nwith 1. It grows while requests succeed and is halved after\ntimeouts. The value of -p is used as the maximum.")
+	flag.BoolVar(&autoRecoverFlag, "auto-recover", false, "If the server closes the connection while the response body is\nread, retry the request once with a \"Connection: close\" header and\nset the \"retried\" field. Cannot be combined with -pipeline.")
+	flag.BoolVar(&dedupeFlag, "dedupe", false, "Skip lines with requests equivalent to those of previous lines, that\nuse the same connection options, like \"h2\", \"addresses\" or\n\"tlsopts\". Host case, default ports and trailing slashes are ignored\nwhen comparing, also in the Host header. The number of skipped lines\nis reported to standard error at the end.")
+	flag.StringVar(&basicFlag, "basic", "", "Add an Authorization header for basic authentication with the\n`user:pass` to requests without one. Overridden by the \"auth\" field.")
+	flag.StringVar(&bearerFlag, "bearer", "", "Add an Authorization header with the bearer `token` to requests\nwithout one. Overridden by the \"auth\" field.")
+	flag.IntVar(&breakerFlag, "breaker", 0, "Skip the remaining lines for a host after `n` consecutive\nrefused connections or timeouts. Skipped lines get the errno 40.\n0 disables the circuit breaker.")
+	flag.BoolVar(&cacheFlag, "cache", false, "Make identical requests only once. Later lines with the same\nrequest get the result of the first one and the \"cached\" field.\nCannot be combined with -pipeline.")
+	flag.BoolVar(&conformanceFlag, "conformance", false, "Record every deviation of responses from RFC 7230 in the\n\"violations\" field, including those that clients may tolerate.\nCannot be combined with -strict.")
+	flag.DurationVar(&keepAliveProbeFlag, "keepalive-probe", 0, "Wait up to `duration` after a response for the server to close the\nidle connection and store whether keep-alive is supported and when\nthe connection was closed in the \"keepalive\" field.")
+	flag.StringVar(&splitByHostFlag, "split-by-host", "", "Additionally write the output lines of each host to its own file\nin `dir`, e.g. \"dir/example.com.jsonl\".")
+	flag.DurationVar(&runTimeoutFlag, "run-timeout", 0, "Limit the duration of the whole run. When `duration` expires,\nrequests in flight are cancelled and the remaining input lines are\nwritten to the output untouched, but with the field \"skipped\". 0\nmeans no limit.")
+	flag.StringVar(&baselineFlag, "baseline", "", "Compare the responses with those of the corresponding lines in\n`file`, the output of a previous run, and store whether the status,\nheaders or body changed in the \"baseline\" field. Lines are matched\nby their \"id\" field or by their request.")
+	flag.BoolVar(&fanOutFlag, "fan-out", false, "Send each request to every address of its host, or to those in\nthe \"addresses\" field, and output a line for each address with the\naddress in the \"addr\" field.")
+	flag.IntVar(&prefetchDNSFlag, "prefetch-dns", 0, "Resolve the hosts of up to `n` lines ahead of the requests\nconcurrently and cache the addresses for the whole run. 0 disables\nprefetching.")
+	flag.StringVar(&controlSocketFlag, "control-socket", "", "Listen for commands on the Unix socket at `path`, which allow\nchanging -p and -delay while preq runs. See the README.")
+	flag.IntVar(&maxBPSFlag, "max-bps", 0, "Limit the total throughput of all connections to `n` bytes per\nsecond for reading and for writing. 0 means no limit.")
+	flag.IntVar(&maxBPSConnFlag, "max-bps-conn", 0, "Limit the throughput of each connection to `n` bytes per second\nfor reading and for writing. Overridden by the \"maxbps\" field. 0\nmeans no limit.")
+	flag.StringVar(&proxyFlag, "proxy", "", "Tunnel connections through the HTTP proxy at `url` using CONNECT.\nThe scheme \"https\" makes the connection to the proxy use TLS.\nCredentials in url are sent using basic authentication. Overridden\nby the \"proxy\" field. Ignored for HTTP/3.")
+	flag.StringVar(&proxyBearerFlag, "proxy-bearer", "", "Authenticate to the proxy with the bearer `token` instead of the\ncredentials in the proxy URL. Overridden by the \"proxybearer\"\nfield.")
+	flag.StringVar(&metricsListenFlag, "metrics-listen", "", "Serve metrics in the Prometheus format at /metrics of `addr`, e.g.\n\":9090\", while preq runs.")
+	flag.DurationVar(&closeWaitFlag, "close-wait", 0, "Wait up to `duration` after a response for the server to close the\nconnection. Whether it did is stored in \"closed\" of the \"connclose\"\nfield.")
+	flag.StringVar(&captureDirFlag, "capture-dir", "", "Write transcripts of the bytes sent and received with timestamps\nto files in `dir` and store their path in the \"transcript\" field.\nSee also -capture-lines.")
+	flag.StringVar(&captureLinesFlag, "capture-lines", "failed", "Write transcripts for -capture-dir only for \"failed\" requests or\nfor \"all\" requests.")
+	flag.StringVar(&cookiesFlag, "cookies", "", "Store cookies set by responses and send them with later requests.\nWith the `scope` \"host\", cookies are only sent to the host, that\nset them, with \"run\" to all hosts.")
+	flag.DurationVar(&delayFlag, "delay", 0, "Pause for `duration` between dispatching requests. See also\n-jitter and -delay-per-worker.")
+	flag.BoolVar(&delayPerWorkerFlag, "delay-per-worker", false, "Apply -delay and -jitter between the requests of each worker,\ninstead of between all dispatched requests.")
+	flag.BoolVar(&dryRunFlag, "dry-run", false, "Do not make any requests, but only validate the input and print\nthe normalized lines. Invalid requests get an \"err\" with the\n\"errdetail\" \"validation\".")
+	flag.Var(&maxFailuresFlag, "max-failures", "Exit with status 3 if more than `n` requests failed. If suffixed\nwith \"%\", n is a percentage of all requests. Use 0 to exit with\nstatus 3 if any request failed.")
+	flag.StringVar(&okOutFlag, "ok-out", "", "Write lines of successful requests to `file` instead of standard\noutput.")
+	flag.StringVar(&interfaceFlag, "interface", "", "Bind connections to the network interface `name`. Only supported\non Linux. Overridden by the \"interface\" field.")
+	flag.BoolVar(&progressFlag, "progress", false, "Periodically report the progress to standard error.")
+	flag.StringVar(&respDirFlag, "resp-dir", "", "Write responses removed due to -max-line-size to files in `dir`\nand store their path in the \"respfile\" field.")
+	flag.BoolVar(&rawFlag, "raw", false, "Store the exact bytes received on the connection base64 encoded\nin the \"rawresp\" field, including data following the response.\nSee also -raw-max and -close-wait.")
+	flag.IntVar(&rawMaxFlag, "raw-max", 1024*1024, "The maximum number of bytes stored in the \"rawresp\" field for\n-raw.")
+	flag.IntVar(&repeatFlag, "repeat", 1, "Send each request `n` times. Each attempt is printed with its\nnumber in the \"attempt\" field, unless -repeat-summary is given.\nOverridden by the \"repeat\" field.")
+	flag.BoolVar(&repeatSummaryFlag, "repeat-summary", false, "Print only a single line for repeated requests. Its \"bench\"\nfield holds the number of attempts, successes, the min/avg/p95/max\nping, the counts of the status codes and whether all attempts\nsucceeded with the same status code.")
+	flag.IntVar(&respCompressFlag, "resp-compress", -1, "Store responses longer than `n` bytes zstd-compressed and base64\nencoded in the \"resp_zstd64\" field instead of \"resp\". Use\n\"preq convert\" to decompress them again. A negative value disables\ncompression.")
+	flag.StringVar(&retryStatusFlag, "retry-status", "", "Retry requests, whose responses have one of the status codes in\nthe comma separated `list`, e.g. \"429,503\". The Retry-After header\nis honored. The number of retries is stored in the \"retries\" field.\nCannot be combined with -pipeline.")
+	flag.StringVar(&onlyStatusFlag, "only-status", "", "Only output lines, whose responses have one of the status codes in\nthe comma separated `list`, e.g. \"200,301\". The number of suppressed\nlines is reported to standard error at the end.")
+	flag.StringVar(&excludeStatusFlag, "exclude-status", "", "Don't output lines, whose responses have one of the status codes in\nthe comma separated `list`. See also -only-status.")
+	flag.StringVar(&onlyErrnoFlag, "only-errno", "", "Only output lines with one of the errnos in the comma separated\n`list`, where 0 means success. See also -only-status.")
+	flag.StringVar(&excludeErrnoFlag, "exclude-errno", "", "Don't output lines with one of the errnos in the comma separated\n`list`, e.g. \"10\" to drop unknown hosts. See also -only-status.")
+	flag.IntVar(&retryMaxFlag, "retry-max", 3, "The maximum number of retries per request for -retry-status.")
+	flag.DurationVar(&retryAfterMaxFlag, "retry-after-max", 30*time.Second, "The maximum time to wait before a retry for -retry-status.")
+	flag.StringVar(&runIDFlag, "run-id", "", "Store `id` in the \"runid\" field of every output line. By default\na random UUID is used.")
+	flag.IntVar(&shuffleFlag, "shuffle", 0, "Shuffle the input within a window of `n` lines.")
+	flag.Int64Var(&streamMaxBytesFlag, "stream-max-bytes", 0, "Read at most `n` bytes of each response. If the body is cut\nshort, the request is not failed, but the \"streamed\" field is set.\nMeant for streaming endpoints, like Server-Sent Events.")
+	flag.DurationVar(&streamMaxTimeFlag, "stream-max-time", 0, "Read responses for at most `duration` after sending the request.\nIf the body is cut short, the request is not failed, but the\n\"streamed\" field is set.")
+	flag.StringVar(&sourceIPFlag, "source-ip", "", "Use `ip` as local address of connections. Overridden by the\n\"sourceip\" field.")
+	flag.BoolVar(&statsFlag, "stats", false, "Print summary statistics to standard error when done.")
+	flag.BoolVar(&strictFlag, "strict", false, "Fail requests, whose responses deviate from RFC 7230. By default\ntolerated deviations are listed in the \"laxities\" field. Also\nreject requests with problems, which are otherwise only listed in\nthe \"reqwarn\" field.")
+	flag.DurationVar(&tcpKeepAliveFlag, "tcp-keepalive", 0, "Send TCP keep-alive probes every `interval`. A negative value\ndisables keep-alive probes. By default Go's default of 15s is used.")
+	flag.DurationVar(&fallbackDelayFlag, "fallback-delay", 250*time.Millisecond, "If a host has IPv4 and IPv6 addresses, start a connection attempt to\nthe next address after `duration`, while the previous one is still\nrunning (Happy Eyeballs). A negative value makes the attempts one\nafter another.")
+	flag.BoolVar(&tcpNoDelayFlag, "tcp-nodelay", true, "Set TCP_NODELAY on connections, disabling Nagle's algorithm. Use\n-tcp-nodelay=false to enable Nagle's algorithm.")
+	flag.IntVar(&tcpRcvBufFlag, "tcp-rcvbuf", 0, "Set the size of the socket receive buffer to `n` bytes. 0 keeps\nthe default of the operating system.")
+	flag.IntVar(&tcpSndBufFlag, "tcp-sndbuf", 0, "Set the size of the socket send buffer to `n` bytes. 0 keeps the\ndefault of the operating system.")
+	flag.StringVar(&tlsCiphersFlag, "tls-ciphers", "", "Offer only the cipher suites in the comma separated `list` for TLS\n1.2 and below, e.g. \"TLS_RSA_WITH_AES_128_CBC_SHA\". Insecure suites\nare allowed. Overridden by the \"tlsciphers\" field.")
+	flag.StringVar(&tlsMaxFlag, "tls-max", "", "The maximum TLS `version`: 1.0, 1.1, 1.2 or 1.3. Overridden by\nthe \"tlsmax\" field.")
+	flag.StringVar(&tlsMinFlag, "tls-min", "", "The minimum TLS `version`: 1.0, 1.1, 1.2 or 1.3. Overridden by\nthe \"tlsmin\" field.")
+	flag.StringVar(&tlsHelloFlag, "tls-hello", "", "Mimic the TLS ClientHello of `browser` using uTLS. One of\n"+strings.Join(client.Hellos(), ", ")+".\nOverridden by the \"hello\" field.")
+	flag.BoolVar(&tlsNoTicketsFlag, "tls-no-tickets", false, "Disable TLS session tickets and thus session resumption.")
+	flag.BoolVar(&tlsSessionCacheFlag, "tls-session-cache", false, "Share a TLS session cache between all requests, so that sessions\ncan be resumed by later requests to the same host. Resumed sessions\nare marked with the \"tlsresumed\" field.")
+	flag.Var(&tlsWarmFlag, "tls-warm", "Establish up to `n` TLS connections per host ahead of the lines,\nthat use them, while other requests are running. With \"host=n\",\nthe number is set for a single host. Can be given multiple times.")
+	flag.IntVar(&ttlFlag, "ttl", 0, "Set the IP TTL, or the hop limit for IPv6, of outgoing packets\nto `n`. 0 keeps the default of the operating system.")
+	flag.Var(&clientCertFlag, "client-cert", "Authenticate with the client certificate in the PEM `files`\n\"cert.pem,key.pem\". With \"name=cert.pem,key.pem\", the certificate is\nonly used for lines, whose \"tlsopts\" field references it with\n\"clientcertref\". Can be given multiple times.")
+	flag.StringVar(&tlsVerifyFlag, "tls-verify", "verify", "TLS certificate verification `mode`. With \"verify\", requests to\nservers with invalid certificates fail. With \"report\", such requests\nare made anyway and the problem is stored in the \"certerr\" field.\nWith \"none\", certificates are not verified. Overridden by\n\"insecure\" of the \"tlsopts\" field.")
+	flag.IntVar(&wsFramesFlag, "ws-frames", 0, "After a WebSocket upgrade, read up to `n` frames and store them in\nthe \"frames\" field. See also -ws-time.")
+	flag.DurationVar(&wsTimeFlag, "ws-time", 0, "After a WebSocket upgrade, read frames for `duration` and store\nthem in the \"frames\" field. See also -ws-frames.")
+	v := flag.Bool("v", false, "Log the connection lifecycle of each request to standard error.")
+	vv := flag.Bool("vv", false, "Like -v, but log more details.")
 	flag.Parse()
+	if *vv {
+		verbosity = 2
+	} else if *v {
+		verbosity = 1
+	}
+	if tlsVerifyFlag != "verify" && tlsVerifyFlag != "report" && tlsVerifyFlag != "none" {
+		fmt.Fprintf(os.Stderr, "Error: Invalid value '%s' for -tls-verify.\n", tlsVerifyFlag)
+		os.Exit(2)
+	}
+	if tlsSessionCacheFlag {
+		sessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	for _, value := range clientCertFlag {
+		if err := addClientCert(value); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: Invalid client certificate:", err)
+			os.Exit(2)
+		}
+	}
+	tlsConf, err := tlsConfig(tlsOptions{})
+	if err == nil {
+		_, err = hello(tlsOptions{})
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: Invalid TLS options:", err)
+		os.Exit(2)
+	}
+	d, err := dialer(sourceOptions{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: Invalid connection options:", err)
+		os.Exit(2)
+	}
+	proxyConf, err := proxy(proxyOptions{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: Invalid proxy options:", err)
+		os.Exit(2)
+	}
+	if basicFlag != "" && bearerFlag != "" {
+		fmt.Fprintln(os.Stderr, "Error: -basic and -bearer cannot be combined.")
+		os.Exit(2)
+	}
+	if _, err := authorization(httpline{}); err != nil {
+		fmt.Fprintln(os.Stderr, "Error: Invalid credentials:", err)
+		os.Exit(2)
+	}
+	if layout, ok := timeLayouts[timePrecisionFlag]; ok {
+		httpipe.TimeLayout = layout
+	} else {
+		fmt.Fprintln(os.Stderr, "Error: Invalid value for -time-precision:", timePrecisionFlag)
+		os.Exit(2)
+	}
+	if (rotateSizeFlag > 0 || rotateTimeFlag > 0) && !strings.Contains(oFlag, "%d") {
+		fmt.Fprintf(os.Stderr, "Error: -rotate-size and -rotate-time require %q in the file name of -o.\n", "%d")
+		os.Exit(2)
+	}
+	switch inCompressionFlag {
+	case "auto", "gzip", "zstd", "none":
+	default:
+		fmt.Fprintln(os.Stderr, "Error: Invalid value for -in-compression:", inCompressionFlag)
+		os.Exit(2)
+	}
+	if filterFlag != "" {
+		var err error
+		if lineFilter, err = filter.Parse(filterFlag); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: Invalid value for -filter:", err)
+			os.Exit(2)
+		}
+	}
+	if unselectedFlag != "drop" && unselectedFlag != "pass" {
+		fmt.Fprintln(os.Stderr, "Error: Invalid value for -unselected:", unselectedFlag)
+		os.Exit(2)
+	}
+	if seedFlag == 0 {
+		seedFlag = time.Now().UnixNano()
+	}
+	sampler = rand.New(rand.NewSource(seedFlag))
+	if err := checkFormat(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error: Invalid value for -format or -fields:", err)
+		os.Exit(2)
+	}
+	if captureLinesFlag != "failed" && captureLinesFlag != "all" {
+		fmt.Fprintln(os.Stderr, "Error: Invalid value for -capture-lines:", captureLinesFlag)
+		os.Exit(2)
+	}
+	if retryStatusFlag != "" {
+		var err error
+		if retryStatuses, err = parseStatusList(retryStatusFlag); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: Invalid value for -retry-status:", err)
+			os.Exit(2)
+		}
+	}
+	if outFilter, err = newResultFilter(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error: Invalid output filter:", err)
+		os.Exit(2)
+	}
+	if conformanceFlag && strictFlag {
+		fmt.Fprintln(os.Stderr, "Error: -conformance and -strict cannot be combined.")
+		os.Exit(2)
+	}
+	if autoRecoverFlag && pipelineFlag > 1 {
+		fmt.Fprintln(os.Stderr, "Error: -auto-recover and -pipeline cannot be combined.")
+		os.Exit(2)
+	}
+	if retryStatusFlag != "" && pipelineFlag > 1 {
+		fmt.Fprintln(os.Stderr, "Error: -retry-status and -pipeline cannot be combined.")
+		os.Exit(2)
+	}
+	if cacheFlag && pipelineFlag > 1 {
+		fmt.Fprintln(os.Stderr, "Error: -cache and -pipeline cannot be combined.")
+		os.Exit(2)
+	} else if cacheFlag {
+		responses = newResponseCache()
+	}
+	switch cookiesFlag {
+	case "":
+	case "host", "run":
+		jar = newCookieJar(cookiesFlag == "host")
+	default:
+		fmt.Fprintf(os.Stderr, "Error: Invalid value '%s' for -cookies.\n", cookiesFlag)
+		os.Exit(2)
+	}
+	if autoPFlag {
+		concurrency = newLimiter(pFlag)
+	} else {
+		concurrency = newFixedLimiter(pFlag)
+	}
+	delay.Store(int64(delayFlag))
+	if breakerFlag > 0 {
+		circuitBreaker = newBreaker(breakerFlag)
+	}
+	if maxBufferedBytesFlag > 0 {
+		buffered = newByteBudget(maxBufferedBytesFlag)
+	}
+	if runIDFlag == "" {
+		runIDFlag = newUUID()
+	}
+	var bandwidth *client.Bandwidth
+	if maxBPSFlag > 0 {
+		bandwidth = client.NewBandwidth(maxBPSFlag)
+	}
+	requester = &client.Client{
+		Timeout:            timeout,
+		TLSConfig:          tlsConf,
+		Dialer:             d,
+		ReportCertProblems: tlsVerifyFlag == "report",
+		Strict:             strictFlag,
+		Conformance:        conformanceFlag,
+		Nagle:              !tcpNoDelayFlag,
+		FallbackDelay:      fallbackDelayFlag,
+		CloseWait:          max(closeWaitFlag, keepAliveProbeFlag),
+		CaptureRaw:         rawFlag,
+		Transcript:         captureDirFlag != "",
+		Bandwidth:          bandwidth,
+		ConnBandwidth:      maxBPSConnFlag,
+		Proxy:              proxyConf,
+		Logf:               clientLogf,
+	}
 }
 
 func main() {
-	requests := make(chan httpline)
-	go readLines(requests)
+	switch flag.Arg(0) {
+	case "":
+	case "convert":
+		convert()
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "Error: Unknown command '%s'.\n", flag.Arg(0))
+		os.Exit(2)
+	}
+	if selftestFlag {
+		selftest()
+		return
+	}
 
-	results := make(chan httpline)
-	var wg sync.WaitGroup
-	for i := 0; i < pFlag; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			doRequests(requests, results)
-		}()
+	if baselineFlag != "" {
+		var err error
+		if baseline, err = loadBaseline(baselineFlag); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: Could not read baseline:", err)
+			os.Exit(1)
+		}
+	}
+	requests, results := make(chan httpline), make(chan httpline)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		// Restore the default behavior, so that a second signal
+		// terminates preq immediately.
+		<-ctx.Done()
+		stop()
+	}()
+	if runTimeoutFlag > 0 {
+		startRunTimeout()
+	}
+	go readLines(ctx, requests, results)
+	if prefetchDNSFlag > 0 {
+		resolved = newDNSCache()
+		prefetched := make(chan httpline, prefetchDNSFlag)
+		go prefetchDNS(ctx, requests, prefetched)
+		requests = prefetched
+	}
+	if len(tlsWarmFlag) > 0 {
+		var err error
+		if warmConns, err = newWarmPool(ctx, tlsWarmFlag); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: Invalid value for -tls-warm:", err)
+			os.Exit(2)
+		}
+		warmed := make(chan httpline, max(pFlag, 1))
+		go warmUp(ctx, requests, warmed)
+		requests = warmed
+	}
+	if shuffleFlag > 1 {
+		shuffled := make(chan httpline)
+		go shuffleLines(ctx, requests, shuffled, shuffleFlag)
+		requests = shuffled
 	}
+	if interleaveFlag > 1 {
+		interleaved := make(chan httpline)
+		go interleaveLines(ctx, requests, interleaved, interleaveFlag)
+		requests = interleaved
+	}
+	if (delayFlag > 0 || jitterFlag > 0 || controlSocketFlag != "") && !delayPerWorkerFlag {
+		delayed := make(chan httpline)
+		go delayLines(ctx, requests, delayed)
+		requests = delayed
+	}
+
+	var pipelines chan []httpline
+	if pipelineFlag > 1 {
+		pipelines = make(chan []httpline)
+		go groupLines(ctx, requests, pipelines, pipelineFlag)
+	}
+	workers = newWorkerPool(func() {
+		if pipelines != nil {
+			doPipelines(ctx, pipelines, results)
+		} else {
+			doRequests(ctx, requests, results)
+		}
+	})
+	workers.grow(pFlag)
 	go func() {
-		wg.Wait()
+		<-workers.done
 		close(results)
 	}()
-	printResults(results)
+	go handleControlSignals()
+	if metricsListenFlag != "" {
+		metrics = newMetricSet()
+		if err := serveMetrics(metricsListenFlag); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: Could not serve metrics:", err)
+			os.Exit(1)
+		}
+	}
+	if controlSocketFlag != "" {
+		if err := serveControl(controlSocketFlag); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: Could not listen on control socket:", err)
+			os.Exit(1)
+		}
+	}
+	done := make(chan struct{})
+	reported := make(chan struct{})
+	if progressFlag {
+		go func() {
+			reportProgress(done)
+			close(reported)
+		}()
+	} else {
+		close(reported)
+	}
+	if okOutFlag == "" {
+		okOutFlag = oFlag
+	}
+	if errOutFlag == "" {
+		errOutFlag = oFlag
+	}
+	okOut, errOut := openOutput(okOutFlag), openOutput(errOutFlag)
+	if splitByHostFlag != "" {
+		var err error
+		if hostOutputs, err = newHostSplit(splitByHostFlag); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: Could not create output directory:", err)
+			os.Exit(1)
+		}
+	}
+	printResults(results, okOut, errOut)
+	closeOutputs()
+	hostOutputs.close()
+	warmConns.close()
+	closeControl()
+	close(done)
+	<-reported
+	if maxFailuresFlag.exceeded(prog.failed.Load(), prog.completed.Load()) {
+		os.Exit(3)
+	}
 }
 
-func readLines(lines chan httpline) {
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		rawLine := scanner.Bytes()
-		var line httpline
-		err := json.Unmarshal(rawLine, &line)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: Could not parse line '%s': %v\n", rawLine, err)
+// readLines reads lines from the inputs and sends them to lines until
+// the inputs end or ctx is done. Lines, that are not selected for
+// requests, are sent to unselected, if -unselected is "pass".
+func readLines(ctx context.Context, lines, unselected chan httpline) {
+	defer close(lines)
+	var d *deduper
+	if dedupeFlag {
+		d = newDeduper()
+		defer func() {
+			fmt.Fprintf(os.Stderr, "Skipped %d duplicate lines.\n", d.duplicates)
+		}()
+	}
+	inputs, err := openInputs()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: Could not open input:", err)
+		os.Exit(1)
+	}
+	lineno := 0
+	for _, in := range inputs {
+		scanner := bufio.NewScanner(in.r)
+		for scanner.Scan() {
+			lineno++
+			rawLine := scanner.Bytes()
+			if stopped.Load() {
+				if !passLine(ctx, markSkipped(rawLine), unselected) {
+					return
+				}
+				continue
+			}
+			if !selected(lineno) {
+				if unselectedFlag == "drop" && beyondRange(lineno) {
+					return
+				} else if unselectedFlag == "pass" && !passLine(ctx, rawLine, unselected) {
+					return
+				}
+				continue
+			}
+			var line httpline
+			err := json.Unmarshal(rawLine, &line)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: Could not parse line '%s': %v\n", rawLine, err)
+				os.Exit(1)
+			}
+			line.N = lineno
+			keepInput(&line, rawLine)
+			if ok, err := selectedByFilter(line); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: Could not filter line '%s': %v\n", rawLine, err)
+				os.Exit(1)
+			} else if !ok {
+				if unselectedFlag == "pass" && !passLine(ctx, rawLine, unselected) {
+					return
+				}
+				continue
+			}
+			clearResult(&line)
+			loadReqFiles(&line)
+			line.inputReq = line.Req
+			if line.OrigReq != "" {
+				line.inputReq = line.OrigReq
+			}
+			if fixReqFlag {
+				fixLine(&line)
+			}
+			if d != nil {
+				line.SetDefaults()
+				if d.isDuplicate(line) {
+					continue
+				}
+			}
+			prog.read.Add(1)
+			line.RunID = runIDFlag
+			queuedAt := httpipe.Time(time.Now())
+			line.QueuedAt = &queuedAt
+			select {
+			case lines <- line:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Could not read %s: %v\n", in.name, err)
 			os.Exit(1)
 		}
-		lines <- line
 	}
-	if err := scanner.Err(); err != nil {
-		fmt.Fprintln(os.Stderr, "Error: Could not read standard input:", err)
-		os.Exit(1)
+}
+
+func doRequests(ctx context.Context, requests, results chan httpline) {
+	var p pacer
+	for {
+		if !buffered.wait(ctx) || !concurrency.acquire(ctx) {
+			return
+		}
+		select {
+		case request, ok := <-requests:
+			if !ok {
+				concurrency.release()
+				return
+			}
+			if skipped, ok := skipAfterStop(request); ok {
+				concurrency.release()
+				results <- skipped
+				continue
+			}
+			p.wait(ctx)
+			reqCtx, cancel := withRunDeadline(ctx)
+			lines := doFanOut(reqCtx, request)
+			cancel()
+			if len(lines) == 0 {
+				// -stop-after-total was reached in the meantime.
+				skipped, _ := skipAfterStop(request)
+				lines = []httpline{skipped}
+			}
+			concurrency.release(lines...)
+			buffered.add(lines...)
+			for _, result := range lines {
+				results <- result
+			}
+		case <-ctx.Done():
+			return
+		}
 	}
-	close(lines)
 }
 
-func doRequests(requests, results chan httpline) {
-	for request := range requests {
-		results <- doRequest(request)
+func doRequest(ctx context.Context, request httpline) httpline {
+	if dryRunFlag {
+		return dryRun(request)
+	} else if responses != nil && repeats(request) == 1 {
+		return responses.do(ctx, request)
 	}
+	return makeRequest(ctx, request)
 }
 
-func doRequest(request httpline) httpline {
-	setDefaultTLSAndPortIfNecessary(&request)
-	deadline := time.Now().Add(timeout)
-	conn, err := getConn(request, deadline)
-	if err != nil {
-		request.Errno, request.Err = toErrno(err), err.Error()
+// makeRequest makes request, applying the options that affect single
+// requests.
+func makeRequest(ctx context.Context, request httpline) httpline {
+	if !lint(&request) {
 		return request
 	}
-	defer conn.Close()
-	if err = conn.SetDeadline(deadline); err != nil {
-		request.Errno, request.Err = 99, err.Error()
+	if circuitBreaker.skip(&request) {
 		return request
 	}
-	_, err = fmt.Fprint(conn, request.Req)
-	if err != nil {
-		// FIXME: errno 30 may not be ideal.
-		request.Errno, request.Err = 30, err.Error()
-		return request
+	jar.inject(&request)
+	injectAuth(&request)
+	result, err := attemptRequest(ctx, request)
+	if autoRecoverFlag && isEarlyClose(err) {
+		retry := request
+		retry.Req = withConnectionClose(request.Req)
+		logf(1, request, "retrying after early close: %v", err)
+		result, _ = attemptRequest(ctx, retry)
+		result.Req = request.Req
+		result.Retried = true
+	}
+	for shouldRetry(result) {
+		delay := retryDelay(result)
+		logf(1, request, "retrying with status %d after %v", statusCode(result.Resp), delay)
+		if !sleep(ctx, delay) {
+			break
+		}
+		retries, retried := result.Retries+1, result.Retried
+		result, _ = attemptRequest(ctx, request)
+		result.Retries, result.Retried = retries, retried
 	}
-	now := jtime(time.Now())
-	request.Reqat = &now
-	timedConn := &timedReader{r: conn}
-	resp, err := extractor.ExtractResponse(timedConn, isHEAD(request.Req))
-	request.Resp = resp
-	if !timedConn.readAt.IsZero() {
-		request.Ping = timedConn.readAt.Sub(time.Time(*request.Reqat)).Milliseconds()
+	circuitBreaker.record(result)
+	jar.store(result)
+	if result.FollowUp == nil {
+		result.FollowUp = makeFollowUp(ctx, request, result)
 	}
+	return result
+}
+
+// attemptRequest makes a single attempt at request. The returned error
+// is the one that has been stored in the result.
+func attemptRequest(ctx context.Context, request httpline) (httpline, error) {
+	request.SetDefaults()
+	ctx = context.WithValue(ctx, linenoKey{}, request.N)
+	req, err := toClientRequest(request)
 	if err != nil {
-		request.Errno, request.Err = 99, err.Error()
-		return request
+		setValidationErr(&request, []string{err.Error()})
+		return request, err
 	}
-	return request
+	resolved.apply(ctx, &req)
+	req.Warm = warmConns.take(ctx, request)
+	result, err := requester.Do(ctx, req)
+	applyResult(&request, result, err)
+	return request, err
 }
 
-func toErrno(err error) int {
-	if errors.Is(err, context.DeadlineExceeded) {
-		return 31
+// alpn returns the protocols to offer via ALPN for request.
+func alpn(request httpline) []string {
+	if request.TLSOpts != nil && request.TLSOpts.ALPN != nil {
+		return request.TLSOpts.ALPN
 	}
-	switch err2 := err.(type) {
-	case *net.OpError:
-		switch err3 := err2.Err.(type) {
-		case *net.DNSError:
-			if err3.IsNotFound {
-				return 10
-			} else if err3.IsTimeout {
-				return 11
-			}
-		case *os.SyscallError:
-			if err3.Err == syscall.ECONNREFUSED {
-				return 30
-			}
-		case net.Error:
-			if err3.Timeout() {
-				return 31
-			}
+	if request.ALPN != nil {
+		return request.ALPN
+	}
+	return alpnFlag
+}
+
+// toClientRequest converts request. An error is returned, if the TLS or
+// source options, the pacing options or the sendat, reqbody, bodydelay
+// or auth fields of request are invalid or if its files could not be
+// read.
+func toClientRequest(request httpline) (client.Request, error) {
+	if request.fileErr != nil {
+		return client.Request{}, request.fileErr
+	}
+	var sendAt time.Time
+	if request.SendAt != "" {
+		var err error
+		if sendAt, err = time.Parse(time.RFC3339Nano, request.SendAt); err != nil {
+			return client.Request{}, fmt.Errorf("invalid sendat: %w", err)
 		}
-	case *tls.CertificateVerificationError:
-		return 20 // FIXME: Cannot distinguish different TLS errors.
 	}
-	return 99 // Undefined errno for unknown error.
+	tlsConf, err := tlsConfig(request.tlsOptions)
+	if err != nil {
+		return client.Request{}, err
+	}
+	helloName, err := hello(request.tlsOptions)
+	if err != nil {
+		return client.Request{}, err
+	}
+	d, err := dialer(request.sourceOptions)
+	if err != nil {
+		return client.Request{}, err
+	}
+	p, err := pacing(request.pacingOptions)
+	if err != nil {
+		return client.Request{}, err
+	}
+	bodyDelay, err := parseDuration("bodydelay", request.BodyDelay)
+	if err != nil {
+		return client.Request{}, err
+	}
+	if _, err = authorization(request); err != nil {
+		return client.Request{}, err
+	}
+	if request.Proxy != "" && useHTTP3(request) {
+		return client.Request{}, errors.New("proxy is not supported for HTTP/3")
+	}
+	if request.ReqBody != "" && (useHTTP2(request) || useHTTP3(request)) {
+		return client.Request{}, errors.New("reqbody is only supported for HTTP/1")
+	}
+	if err = checkFollowUp(request); err != nil {
+		return client.Request{}, err
+	}
+	if request.MaxBPS < 0 {
+		return client.Request{}, fmt.Errorf("invalid maxbps %d", request.MaxBPS)
+	}
+	proxyConf, err := proxy(request.proxyOptions)
+	if err != nil {
+		return client.Request{}, err
+	}
+	return client.Request{
+		Host:          request.Host,
+		Port:          request.Port,
+		TLS:           *request.TLS,
+		Raw:           request.Req,
+		Addresses:     request.Addresses,
+		HTTP2:         useHTTP2(request),
+		H2CUpgrade:    request.H2C == h2cUpgrade,
+		HTTP3:         useHTTP3(request),
+		ALPN:          alpn(request),
+		TLSConfig:     tlsConf,
+		Hello:         helloName,
+		SendAt:        sendAt,
+		Pacing:        p,
+		Body:          request.ReqBody,
+		BodyDelay:     bodyDelay,
+		WebSocket:     webSocketCapture(),
+		Stream:        streamWindow(),
+		FollowUp:      followUpFunc(request),
+		Dialer:        d,
+		ConnBandwidth: request.MaxBPS,
+		Proxy:         proxyConf,
+	}, nil
 }
 
-func setDefaultTLSAndPortIfNecessary(request *httpline) {
-	if request.TLS == nil {
-		t := true
-		request.TLS = &t
+// streamWindow returns the stream window of the flags or nil, if none
+// is set.
+func streamWindow() *client.StreamWindow {
+	if streamMaxBytesFlag <= 0 && streamMaxTimeFlag <= 0 {
+		return nil
 	}
-	if request.Port == 0 {
-		if !*request.TLS {
-			request.Port = 80
-		} else {
-			request.Port = 443
+	return &client.StreamWindow{MaxBytes: streamMaxBytesFlag, MaxTime: streamMaxTimeFlag}
+}
+
+// timeLayouts maps the values of -time-precision to the layouts of
+// timestamps.
+var timeLayouts = map[string]string{
+	"s":  "2006-01-02T15:04:05Z07:00",
+	"ms": "2006-01-02T15:04:05.000Z07:00",
+	"us": "2006-01-02T15:04:05.000000Z07:00",
+	"ns": "2006-01-02T15:04:05.000000000Z07:00",
+}
+
+// toMillis returns d in milliseconds with microsecond precision.
+func toMillis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// applyResult stores result and err in the fields of request.
+func applyResult(request *httpline, result client.Result, err error) {
+	if result.CertProblem != nil {
+		request.Certerr = &certProblem{result.CertProblem.Reason, result.CertProblem.Err.Error()}
+	}
+	if result.HTTP2 != nil {
+		request.H2Info = toH2Info(result.HTTP2)
+	}
+	if result.HTTP3 != nil {
+		request.H3Info = toH3Info(result.HTTP3)
+	}
+	if result.Conn != nil {
+		request.Laddr = result.Conn.LocalAddr.String()
+	}
+	request.Interim, request.BodySent = result.Interim, result.BodySent
+	request.Frames = toWSFrames(result.Frames)
+	request.Streamed = result.Streamed
+	request.DurMS = toMillis(result.Duration)
+	request.ConnClose = toConnClose(result.Close)
+	request.KeepAlive = toKeepAlive(result.Close)
+	request.transcript = result.Transcript
+	request.RawResp = result.RawResponse[:min(len(result.RawResponse), max(rawMaxFlag, 0))]
+	applyFollowUp(request, result)
+	if result.Conn != nil && result.Conn.TLS != nil {
+		state := result.Conn.TLS
+		request.ALPNProto = state.NegotiatedProtocol
+		request.TLSVersion = tls.VersionName(state.Version)
+		request.TLSCipher = tls.CipherSuiteName(state.CipherSuite)
+		request.TLSResumed = state.DidResume
+		request.Warm = result.Conn.Warm
+	}
+	if !result.ReqAt.IsZero() {
+		reqat := httpipe.Time(result.ReqAt)
+		request.Reqat = &reqat
+		request.Resp = result.Resp
+		request.Status, request.Reason = statusAndReason(result.Resp)
+		request.Hdr = extractHeaders(result.Resp, extractHeaderFlag)
+		request.Matches = extractMatches(result.Resp, extractFlag)
+		if utf8Flag {
+			request.Body, request.Charset = utf8Body(result.Resp)
 		}
+		request.BlockType = blockType(result.Resp)
+		request.Ping = result.Ping.Milliseconds()
+		request.PingMS = toMillis(result.Ping)
+		request.Laxities = result.Laxities
+		request.Violations = toViolations(result.Violations)
+		request.Framing, request.FrameRule = result.Framing, result.FramingRule
+		request.Chunks = toChunks(result.Chunks)
+	}
+	if err != nil {
+		setErr(request, err)
+		if runExpired() {
+			request.Errdetail = "run timeout"
+		}
+	}
+}
+
+// writeResult writes the output line out, whose response is resp, to w
+// in the format of -format.
+func writeResult(w io.Writer, out []byte, resp string) error {
+	if formatFlag != "json" {
+		return writeRow(w, out, resp)
 	}
+	_, err := fmt.Fprintln(w, string(out))
+	return err
 }
 
-func getConn(request httpline, deadline time.Time) (net.Conn, error) {
-	dialer := net.Dialer{Deadline: deadline}
-	addr := fmt.Sprintf("%s:%d", request.Host, request.Port)
-	if request.TLS != nil && !*request.TLS {
-		return dialer.Dial("tcp", addr)
+// lint stores the problems of the request of request in the "reqwarn"
+// field. With -strict, requests with problems are rejected, in which
+// case false is returned.
+func lint(request *httpline) bool {
+	if request.fileErr != nil {
+		return true // The error is reported when making the request.
+	}
+	request.ReqWarn = validateRequest(request.Req)
+	if strictFlag && len(request.ReqWarn) > 0 {
+		request.SetDefaults()
+		setValidationErr(request, request.ReqWarn)
+		return false
 	}
-	return tls.DialWithDialer(&dialer, "tcp", addr, nil)
+	return true
 }
 
-func isHEAD(req string) bool {
-	return len(req) >= len("HEAD") && strings.ToLower(req[:len("HEAD")]) == "head"
+// dryRun applies the defaults to request and validates it without
+// making the request.
+func dryRun(request httpline) httpline {
+	request.SetDefaults()
+	injectAuth(&request)
+	problems := validateRequest(request.Req)
+	if _, err := toClientRequest(request); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(problems) > 0 {
+		setValidationErr(&request, problems)
+	}
+	return request
 }
 
-func printResults(results chan httpline) {
+// printResults writes the results of successful requests to okOut and
+// those of failed requests to errOut.
+func printResults(results chan httpline, okOut, errOut io.Writer) {
+	var stop stopAfter
+	var s *stats
+	if statsFlag {
+		s = newStats()
+		defer s.print(os.Stderr)
+	}
+	defer outFilter.report()
+	defer baseline.report()
 	for result := range results {
+		if result.untouched != nil {
+			writeUntouched(okOut, result.untouched)
+			continue
+		}
+		size, resp := responseSize(result), result.Resp
+		stop.add(result)
+		prog.completed.Add(1)
+		if result.Errno != 0 {
+			prog.failed.Add(1)
+		}
+		if s != nil {
+			s.add(result)
+		}
+		metrics.add(result)
+		if result.inputReq != "" && result.Req != result.inputReq {
+			result.OrigReq = result.inputReq
+		}
+		result.Baseline = baseline.compare(result)
+		if !outFilter.passes(result) {
+			buffered.release(size)
+			continue
+		}
+		if captureSelected(result) {
+			if err := writeTranscript(&result, captureDirFlag); err != nil {
+				fmt.Fprintln(os.Stderr, "Error: Could not write transcript:", err)
+				os.Exit(1)
+			}
+		}
+		unloadReqFiles(&result)
+		if respCompressFlag >= 0 {
+			compressResp(&result, respCompressFlag)
+		}
 		out, err := json.Marshal(result)
+		if err == nil && maxLineSizeFlag > 0 && len(out) > maxLineSizeFlag {
+			if err = externalizeResp(&result, respDirFlag); err == nil {
+				out, err = json.Marshal(result)
+			}
+		}
 		if err != nil {
 			fmt.Fprintln(os.Stderr, "Error: Could not generate result:", err)
 			os.Exit(1)
 		}
-		fmt.Println(string(out))
+		w := okOut
+		if result.Err != "" {
+			w = errOut
+		}
+		err = writeResult(w, out, resp)
+		if err == nil && hostOutputs != nil {
+			err = writeResult(hostOutputs.writer(result.Host), out, resp)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error: Could not write result:", err)
+			os.Exit(1)
+		}
+		buffered.release(size)
 	}
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"net/http/httputil"
+	"regexp"
+	"strings"
+)
+
+// extractMatches runs the given regular expressions against the body of
+// resp. The result maps each expression to its matches. A match is
+// represented by its capture groups or, if the expression has no
+// capture groups, by the whole match. nil is returned if nothing
+// matched.
+func extractMatches(resp string, exprs []*regexp.Regexp) map[string][][]string {
+	if len(exprs) == 0 {
+		return nil
+	}
+	body := responseBody(resp)
+	var matches map[string][][]string
+	for _, re := range exprs {
+		for _, submatches := range re.FindAllStringSubmatch(body, -1) {
+			if matches == nil {
+				matches = make(map[string][][]string)
+			}
+			if len(submatches) > 1 {
+				submatches = submatches[1:]
+			}
+			matches[re.String()] = append(matches[re.String()], submatches)
+		}
+	}
+	return matches
+}
+
+// responseBody returns the body of the final response in resp. A
+// chunked body is decoded; if it is truncated, the chunks up to that
+// point are returned.
+func responseBody(resp string) string {
+	resp = finalResponse(resp)
+	_, body, _ := splitHead(resp)
+	if isChunked(resp) {
+		decoded, _ := io.ReadAll(httputil.NewChunkedReader(strings.NewReader(body)))
+		return string(decoded)
+	}
+	return body
+}
+
+// isChunked reports whether the body of resp uses the chunked transfer
+// coding.
+func isChunked(resp string) bool {
+	values := headerValues(resp, "Transfer-Encoding")
+	if len(values) == 0 {
+		return false
+	}
+	codings := strings.Split(values[len(values)-1], ",")
+	return strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked")
+}
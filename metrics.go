@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// metrics collects the metrics exposed via -metrics-listen. A nil
+// *metricSet collects nothing.
+var metrics *metricSet
+
+// latencyBuckets are the upper bounds of the latency histograms in
+// seconds.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// metricSet holds the counters and histograms of a run.
+type metricSet struct {
+	mu       sync.Mutex
+	requests int
+	errnos   map[int]int
+	classes  map[string]int
+	ping     *histogram
+	duration *histogram
+}
+
+func newMetricSet() *metricSet {
+	return &metricSet{
+		errnos:   make(map[int]int),
+		classes:  make(map[string]int),
+		ping:     newHistogram(latencyBuckets),
+		duration: newHistogram(latencyBuckets),
+	}
+}
+
+func (m *metricSet) add(result httpline) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests++
+	if result.Errno != 0 {
+		m.errnos[result.Errno]++
+	}
+	if code := statusCode(result.Resp); code != 0 {
+		m.classes[fmt.Sprintf("%dxx", code/100)]++
+	}
+	if result.Reqat != nil && result.Resp != "" {
+		m.ping.observe(result.PingMS / 1000)
+	}
+	if result.DurMS > 0 {
+		m.duration.observe(result.DurMS / 1000)
+	}
+}
+
+// write writes the metrics in the Prometheus text format to w.
+func (m *metricSet) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintln(w, "# HELP preq_requests_total Completed requests.")
+	fmt.Fprintln(w, "# TYPE preq_requests_total counter")
+	fmt.Fprintf(w, "preq_requests_total %d\n", m.requests)
+	fmt.Fprintln(w, "# HELP preq_errors_total Failed requests by errno.")
+	fmt.Fprintln(w, "# TYPE preq_errors_total counter")
+	for _, errno := range sortedKeys(m.errnos) {
+		fmt.Fprintf(w, "preq_errors_total{errno=\"%d\"} %d\n", errno, m.errnos[errno])
+	}
+	fmt.Fprintln(w, "# HELP preq_responses_total Responses by status class.")
+	fmt.Fprintln(w, "# TYPE preq_responses_total counter")
+	for _, class := range []string{"1xx", "2xx", "3xx", "4xx", "5xx"} {
+		fmt.Fprintf(w, "preq_responses_total{class=\"%s\"} %d\n", class, m.classes[class])
+	}
+	fmt.Fprintln(w, "# HELP preq_in_flight Lines read, but not completed yet.")
+	fmt.Fprintln(w, "# TYPE preq_in_flight gauge")
+	fmt.Fprintf(w, "preq_in_flight %d\n", prog.read.Load()-prog.completed.Load())
+	fmt.Fprintln(w, "# HELP preq_workers Current limit of parallel requests.")
+	fmt.Fprintln(w, "# TYPE preq_workers gauge")
+	fmt.Fprintf(w, "preq_workers %d\n", concurrency.current())
+	m.ping.write(w, "preq_ping_seconds", "Time from sending the request to the first byte of the response.")
+	m.duration.write(w, "preq_duration_seconds", "Total duration of requests, including connecting.")
+}
+
+// histogram counts observations in cumulative buckets.
+type histogram struct {
+	bounds []float64
+	counts []int // counts[i] holds the observations <= bounds[i].
+	count  int
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]int, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) write(w io.Writer, name, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	for i, bound := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, bound, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n", name, h.sum)
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+// serveMetrics serves the metrics at /metrics of addr.
+func serveMetrics(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metrics.write(w)
+	})
+	go func() {
+		err := http.Serve(l, mux)
+		fmt.Fprintln(os.Stderr, "Error: Could not serve metrics:", err)
+	}()
+	return nil
+}
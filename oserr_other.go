@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import (
+	"strconv"
+	"syscall"
+)
+
+// errnoName returns the number of errno, since there are no symbolic
+// names on this platform.
+func errnoName(errno syscall.Errno) string {
+	return "errno " + strconv.FormatUint(uint64(errno), 10)
+}
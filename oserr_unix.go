@@ -0,0 +1,18 @@
+//go:build unix
+
+package main
+
+import (
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// errnoName returns the symbolic name of errno, like "ECONNRESET".
+func errnoName(errno syscall.Errno) string {
+	if name := unix.ErrnoName(errno); name != "" {
+		return name
+	}
+	return "errno " + strconv.Itoa(int(errno))
+}
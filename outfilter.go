@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// resultFilter decides, which result lines are written, according to
+// the -only-status, -exclude-status, -only-errno and -exclude-errno
+// flags. A nil *resultFilter lets all lines pass.
+type resultFilter struct {
+	onlyStatus, excludeStatus map[int]bool
+	onlyErrno, excludeErrno   map[int]bool
+	suppressed                int
+}
+
+// outFilter is nil, if no output filter is given.
+var outFilter *resultFilter
+
+// newResultFilter returns the filter for the flags or nil, if none of
+// them is given.
+func newResultFilter() (*resultFilter, error) {
+	if onlyStatusFlag == "" && excludeStatusFlag == "" && onlyErrnoFlag == "" && excludeErrnoFlag == "" {
+		return nil, nil
+	}
+	var f resultFilter
+	var err error
+	if onlyStatusFlag != "" {
+		if f.onlyStatus, err = parseStatusList(onlyStatusFlag); err != nil {
+			return nil, fmt.Errorf("-only-status: %w", err)
+		}
+	}
+	if excludeStatusFlag != "" {
+		if f.excludeStatus, err = parseStatusList(excludeStatusFlag); err != nil {
+			return nil, fmt.Errorf("-exclude-status: %w", err)
+		}
+	}
+	if onlyErrnoFlag != "" {
+		if f.onlyErrno, err = parseErrnoList(onlyErrnoFlag); err != nil {
+			return nil, fmt.Errorf("-only-errno: %w", err)
+		}
+	}
+	if excludeErrnoFlag != "" {
+		if f.excludeErrno, err = parseErrnoList(excludeErrnoFlag); err != nil {
+			return nil, fmt.Errorf("-exclude-errno: %w", err)
+		}
+	}
+	return &f, nil
+}
+
+// parseErrnoList parses a comma separated list of errnos.
+func parseErrnoList(list string) (map[int]bool, error) {
+	errnos := make(map[int]bool)
+	for _, s := range strings.Split(list, ",") {
+		errno, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || errno < 0 {
+			return nil, fmt.Errorf("invalid errno '%s'", s)
+		}
+		errnos[errno] = true
+	}
+	return errnos, nil
+}
+
+// passes returns true if result shall be written. Otherwise the line is
+// counted as suppressed. Lines without response have no status code, so
+// they never pass -only-status.
+func (f *resultFilter) passes(result httpline) bool {
+	if f == nil {
+		return true
+	}
+	pass := (f.onlyStatus == nil || f.onlyStatus[result.Status]) && !f.excludeStatus[result.Status] &&
+		(f.onlyErrno == nil || f.onlyErrno[result.Errno]) && !f.excludeErrno[result.Errno]
+	if !pass {
+		f.suppressed++
+	}
+	return pass
+}
+
+// report writes the number of suppressed lines to standard error.
+func (f *resultFilter) report() {
+	if f != nil {
+		fmt.Fprintf(os.Stderr, "Suppressed %d lines.\n", f.suppressed)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// flushInterval is the interval at which buffered output is written.
+const flushInterval = time.Second
+
+// output is a destination for result lines. Writes are buffered and
+// flushed periodically. With -z, the output is gzip compressed.
+//
+// If the path of an output contains "%d", the output is rotated
+// according to -rotate-size and -rotate-time: "%d" is replaced by a
+// sequence number, starting with 1. The current file gets the suffix
+// ".part" until it is complete, so that complete files appear
+// atomically.
+type output struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	buf     *bufio.Writer
+	gz      *gzip.Writer // nil without -z.
+	seq     int          // The sequence number of the current file.
+	written int64        // The number of bytes written to the current file.
+	opened  time.Time
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// outputs holds the outputs opened so far by path, so that -ok-out,
+// -err-out and -o can share one.
+var outputs = make(map[string]*output)
+
+// openOutput opens the output for the file at path or for standard
+// output, if path is empty. If the output was already opened, it is
+// returned again.
+func openOutput(path string) *output {
+	if o, ok := outputs[path]; ok {
+		return o
+	}
+	o := &output{path: path, stop: make(chan struct{}), done: make(chan struct{})}
+	if err := o.open(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error: Could not create output file:", err)
+		os.Exit(1)
+	}
+	outputs[path] = o
+	go o.flushPeriodically()
+	return o
+}
+
+func (o *output) rotating() bool {
+	return strings.Contains(o.path, "%d")
+}
+
+// open opens the next file of o.
+func (o *output) open() error {
+	o.file = os.Stdout
+	if o.rotating() {
+		o.seq++
+		var err error
+		if o.file, err = os.Create(o.currentPath() + ".part"); err != nil {
+			return err
+		}
+	} else if o.path != "" {
+		var err error
+		if o.file, err = os.Create(o.path); err != nil {
+			return err
+		}
+	}
+	var w io.Writer = o.file
+	o.gz = nil
+	if zFlag {
+		o.gz = gzip.NewWriter(o.file)
+		w = o.gz
+	}
+	o.buf = bufio.NewWriterSize(w, 64*1024)
+	o.written, o.opened = 0, time.Now()
+	return nil
+}
+
+// currentPath returns the path of the current file of a rotating
+// output.
+func (o *output) currentPath() string {
+	return strings.ReplaceAll(o.path, "%d", fmt.Sprint(o.seq))
+}
+
+// closeFile writes all buffered data and closes the current file of o.
+// The file of a rotating output is renamed to its final name.
+func (o *output) closeFile() error {
+	err := o.buf.Flush()
+	if err == nil && o.gz != nil {
+		err = o.gz.Close()
+	}
+	if err != nil || o.file == os.Stdout {
+		return err
+	}
+	if err = o.file.Close(); err == nil && o.rotating() {
+		err = os.Rename(o.currentPath()+".part", o.currentPath())
+	}
+	return err
+}
+
+// rotateIfDue continues with the next file, if the current one of a
+// rotating output is large or old enough. Empty files are not rotated.
+func (o *output) rotateIfDue() error {
+	if !o.rotating() || o.written == 0 {
+		return nil
+	}
+	if (rotateSizeFlag <= 0 || o.written < rotateSizeFlag) &&
+		(rotateTimeFlag <= 0 || time.Since(o.opened) < rotateTimeFlag) {
+		return nil
+	}
+	if err := o.closeFile(); err != nil {
+		return err
+	}
+	return o.open()
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.rotateIfDue(); err != nil {
+		return 0, err
+	}
+	n, err := o.buf.Write(p)
+	o.written += int64(n)
+	return n, err
+}
+
+func (o *output) flush() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.rotateIfDue(); err != nil {
+		return err
+	}
+	if err := o.buf.Flush(); err != nil {
+		return err
+	}
+	if o.gz != nil {
+		return o.gz.Flush()
+	}
+	return nil
+}
+
+func (o *output) flushPeriodically() {
+	defer close(o.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := o.flush(); err != nil {
+				fmt.Fprintln(os.Stderr, "Error: Could not write result:", err)
+				os.Exit(1)
+			}
+		case <-o.stop:
+			return
+		}
+	}
+}
+
+// closeOutputs writes all buffered output and closes the output files.
+func closeOutputs() {
+	for _, o := range outputs {
+		close(o.stop)
+		<-o.done
+		if err := o.closeFile(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: Could not close output:", err)
+			os.Exit(1)
+		}
+	}
+}
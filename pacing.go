@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/codesoap/preq/client"
+)
+
+// pacingOptions are the per-line options that control how the request
+// is written.
+type pacingOptions struct {
+	WriteSize  int    `json:"writesize,omitempty"`
+	WriteDelay string `json:"writedelay,omitempty"`
+	FinalPause string `json:"finalpause,omitempty"`
+}
+
+// pacing returns the client.Pacing for opts. nil is returned, if no
+// options are set.
+func pacing(opts pacingOptions) (*client.Pacing, error) {
+	if opts == (pacingOptions{}) {
+		return nil, nil
+	}
+	if opts.WriteSize < 0 {
+		return nil, fmt.Errorf("invalid writesize %d", opts.WriteSize)
+	}
+	p := &client.Pacing{ChunkSize: opts.WriteSize}
+	var err error
+	if p.Delay, err = parseDuration("writedelay", opts.WriteDelay); err != nil {
+		return nil, err
+	}
+	if p.FinalPause, err = parseDuration("finalpause", opts.FinalPause); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// parseDuration parses the value of the field name. An empty value
+// means zero.
+func parseDuration(name, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s '%s'", name, value)
+	}
+	return d, nil
+}
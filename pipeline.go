@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"slices"
+
+	"github.com/codesoap/preq/client"
+)
+
+// groupLines sends consecutive lines from lines, that target the same
+// server, to pipelines in groups of at most n lines. HTTP/2 and HTTP/3
+// requests, repeated and paced requests and requests with a "sendat"
+// time or a "reqbody" are not grouped.
+func groupLines(ctx context.Context, lines chan httpline, pipelines chan []httpline, n int) {
+	defer close(pipelines)
+	var group []httpline
+	send := func() bool {
+		select {
+		case pipelines <- group:
+			group = nil
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	for line := range lines {
+		line.SetDefaults()
+		if len(group) > 0 && (len(group) == n || !sameServer(group[0], line) || !useHTTP1(line)) {
+			if !send() {
+				return
+			}
+		}
+		group = append(group, line)
+	}
+	if len(group) > 0 {
+		send()
+	}
+}
+
+func useHTTP1(line httpline) bool {
+	return !useHTTP2(line) && !useHTTP3(line)
+}
+
+// pipelinable reports whether the request of line can be pipelined.
+func pipelinable(line httpline) bool {
+	switch {
+	case repeats(line) != 1 || fanOut(line) || line.Next != "":
+		return false // Makes more than one request.
+	case line.SendAt != "" || line.ReqBody != "" || line.pacingOptions != (pacingOptions{}):
+		return false // Controls how the request is written.
+	}
+	return useHTTP1(line)
+}
+
+// sameServer reports whether the requests of a and b can be pipelined
+// on the same connection.
+func sameServer(a, b httpline) bool {
+	if !pipelinable(a) || !pipelinable(b) {
+		return false
+	}
+	sameTarget := a.Host == b.Host && a.Port == b.Port && *a.TLS == *b.TLS && slices.Equal(a.Addresses, b.Addresses)
+	sameTLS := slices.Equal(a.ALPN, b.ALPN) && a.tlsOptions.equal(b.tlsOptions)
+	sameRoute := a.proxyOptions == b.proxyOptions && a.sourceOptions == b.sourceOptions && a.MaxBPS == b.MaxBPS
+	return sameTarget && sameTLS && sameRoute
+}
+
+func doPipelines(ctx context.Context, pipelines chan []httpline, results chan httpline) {
+	var p pacer
+	for {
+		if !buffered.wait(ctx) || !concurrency.acquire(ctx) {
+			return
+		}
+		select {
+		case pipeline, ok := <-pipelines:
+			if !ok {
+				concurrency.release()
+				return
+			}
+			if isStopped() {
+				concurrency.release()
+				for _, line := range pipeline {
+					skipped, _ := skipAfterStop(line)
+					results <- skipped
+				}
+				continue
+			}
+			p.wait(ctx)
+			reqCtx, cancel := withRunDeadline(ctx)
+			lines := doPipeline(reqCtx, pipeline)
+			cancel()
+			concurrency.release(lines...)
+			buffered.add(lines...)
+			for _, result := range lines {
+				results <- result
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// doPipeline makes the requests of lines on a single connection. The
+// lines beyond -stop-after-total are returned untouched.
+func doPipeline(ctx context.Context, lines []httpline) []httpline {
+	if len(lines) == 1 && !dryRunFlag {
+		return doFanOut(ctx, lines[0])
+	}
+	n := 0
+	for n < len(lines) && reserve() {
+		n++
+	}
+	var results []httpline
+	if n > 0 {
+		results = doPipelineReserved(ctx, lines[:n])
+	}
+	for _, line := range lines[n:] {
+		skipped, _ := skipAfterStop(line)
+		results = append(results, skipped)
+	}
+	return results
+}
+
+// doPipelineReserved makes the requests of lines, for which requests of
+// -stop-after-total have been reserved, on a single connection.
+func doPipelineReserved(ctx context.Context, lines []httpline) []httpline {
+	if dryRunFlag {
+		for i := range lines {
+			lines[i] = doRequest(ctx, lines[i])
+		}
+		return lines
+	}
+	if circuitBreaker.skip(&lines[0]) {
+		for i := range lines[1:] {
+			circuitBreaker.skip(&lines[i+1])
+		}
+		return lines
+	}
+	reqs := make([]client.Request, len(lines))
+	uninjected := slices.Clone(lines)
+	for i := range lines {
+		jar.inject(&lines[i])
+		injectAuth(&lines[i])
+	}
+	for i, line := range lines {
+		var err error
+		if reqs[i], err = toClientRequest(line); err != nil || !lint(&lines[i]) {
+			// Make the requests one by one instead, so that only the
+			// invalid line fails. doRequest injects cookies and
+			// credentials itself.
+			logf(1, line, "not pipelining %d lines, because one is invalid", len(lines))
+			for i := range uninjected {
+				lines[i] = doRequest(ctx, uninjected[i])
+			}
+			return lines
+		}
+	}
+	resolved.apply(ctx, &reqs[0])
+	reqs[0].Warm = warmConns.take(ctx, lines[0])
+	ctx = context.WithValue(ctx, linenoKey{}, lines[0].N)
+	results, errs := requester.DoPipelined(ctx, reqs)
+	for i := range lines {
+		applyResult(&lines[i], results[i], errs[i])
+		circuitBreaker.record(lines[i])
+		jar.store(lines[i])
+	}
+	return lines
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/codesoap/preq/client"
+)
+
+// maxPrefetchLookups limits the number of concurrent DNS lookups of
+// -prefetch-dns.
+const maxPrefetchLookups = 64
+
+// resolved caches the addresses of the hosts, if -prefetch-dns is
+// given. A nil *dnsCache resolves nothing.
+var resolved *dnsCache
+
+// dnsCache resolves hosts ahead of the requests.
+type dnsCache struct {
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+	lookups chan struct{} // Limits the concurrent lookups.
+}
+
+// dnsEntry holds the addresses of a host once done is closed. addrs is
+// nil if the lookup failed.
+type dnsEntry struct {
+	done  chan struct{}
+	addrs []string
+}
+
+func newDNSCache() *dnsCache {
+	return &dnsCache{
+		entries: make(map[string]*dnsEntry),
+		lookups: make(chan struct{}, maxPrefetchLookups),
+	}
+}
+
+// prefetch starts resolving host in the background, if it has not been
+// resolved before. It blocks while the maximum number of lookups is in
+// progress.
+func (c *dnsCache) prefetch(ctx context.Context, host string) {
+	c.mu.Lock()
+	if _, ok := c.entries[host]; ok {
+		c.mu.Unlock()
+		return
+	}
+	entry := &dnsEntry{done: make(chan struct{})}
+	c.entries[host] = entry
+	c.mu.Unlock()
+	select {
+	case c.lookups <- struct{}{}:
+	case <-ctx.Done():
+		close(entry.done)
+		return
+	}
+	go func() {
+		defer func() { <-c.lookups }()
+		defer close(entry.done)
+		lookupCtx := ctx
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			lookupCtx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		addrs, err := net.DefaultResolver.LookupIPAddr(lookupCtx, host)
+		if err != nil {
+			return
+		}
+		for _, addr := range addrs {
+			entry.addrs = append(entry.addrs, addr.IP.String())
+		}
+	}()
+}
+
+// lookup returns the addresses of host, waiting for its lookup to
+// finish. nil is returned if host was not prefetched or the lookup
+// failed, so that the request resolves host itself and reports the
+// error.
+func (c *dnsCache) lookup(ctx context.Context, host string) []string {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	c.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	select {
+	case <-entry.done:
+		return entry.addrs
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// apply sets the addresses of req to the cached ones, if it has none.
+// Requests through a proxy are left alone, because the proxy resolves
+// the host itself, unless the addresses are given explicitly.
+func (c *dnsCache) apply(ctx context.Context, req *client.Request) {
+	proxied := (req.Proxy != nil || requester.Proxy != nil) && !req.HTTP3
+	if len(req.Addresses) == 0 && !proxied {
+		req.Addresses = c.lookup(ctx, req.Host)
+	}
+}
+
+// prefetchDNS forwards the lines from in to out, resolving their hosts
+// in the background. Up to n lines are buffered in out, so that the
+// hosts are resolved ahead of the requests.
+func prefetchDNS(ctx context.Context, in, out chan httpline) {
+	defer close(out)
+	for line := range in {
+		proxied := (line.Proxy != "" || proxyFlag != "") && !useHTTP3(line)
+		if len(line.Addresses) == 0 && net.ParseIP(line.Host) == nil && !proxied && !stopped.Load() {
+			resolved.prefetch(ctx, line.Host)
+		}
+		if !send(ctx, out, line) {
+			return
+		}
+	}
+}
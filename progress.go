@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// progress counts the lines that went through preq, so that the
+// progress of a run can be reported.
+type progress struct {
+	read      atomic.Int64
+	completed atomic.Int64
+	failed    atomic.Int64
+}
+
+var prog progress
+
+// reportProgress periodically prints the progress to standard error
+// until done is closed. If standard error is a terminal, a single status
+// line is updated every second. Otherwise a line is printed every ten
+// seconds.
+func reportProgress(done chan struct{}) {
+	interval, end := 10*time.Second, "\n"
+	if isTerminal(os.Stderr) {
+		interval, end = time.Second, "\r"
+	}
+	start := time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var lastCompleted int64
+	for {
+		select {
+		case <-done:
+			// Print the average throughput of the whole run at the end.
+			rate := float64(prog.completed.Load()) / time.Since(start).Seconds()
+			prog.print(rate, "\n")
+			return
+		case <-ticker.C:
+			completed := prog.completed.Load()
+			rate := float64(completed-lastCompleted) / interval.Seconds()
+			lastCompleted = completed
+			prog.print(rate, end)
+		}
+	}
+}
+
+func (p *progress) print(rate float64, end string) {
+	read, completed, failed := p.read.Load(), p.completed.Load(), p.failed.Load()
+	var errRate float64
+	if completed > 0 {
+		errRate = 100 * float64(failed) / float64(completed)
+	}
+	var workers string
+	if concurrency != nil {
+		workers = fmt.Sprintf(", workers: %d", concurrency.current())
+	}
+	fmt.Fprintf(os.Stderr, "read: %d, completed: %d, in flight: %d, errors: %.1f%%%s, %.1f req/s   %s",
+		read, completed, read-completed, errRate, workers, rate, end)
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
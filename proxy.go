@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/codesoap/preq/client"
+)
+
+// proxyOptions are the per-line proxy options. They override the
+// corresponding flags.
+type proxyOptions struct {
+	Proxy       string `json:"proxy,omitempty"`
+	ProxyBearer string `json:"proxybearer,omitempty"`
+}
+
+// proxy returns the proxy for the given options, which override those
+// of the flags. nil is returned, if no proxy is set.
+func proxy(opts proxyOptions) (*client.Proxy, error) {
+	if opts.Proxy == "" {
+		opts.Proxy = proxyFlag
+	}
+	if opts.ProxyBearer == "" {
+		opts.ProxyBearer = proxyBearerFlag
+	}
+	if opts.Proxy == "" {
+		if opts.ProxyBearer != "" {
+			return nil, errors.New("proxy bearer token given without proxy")
+		}
+		return nil, nil
+	}
+	u, err := url.Parse(opts.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy: %w", err)
+	}
+	p := &client.Proxy{Host: u.Hostname()}
+	switch u.Scheme {
+	case "http":
+		p.Port = 80
+	case "https":
+		p.Port, p.TLS = 443, true
+	default:
+		return nil, fmt.Errorf("invalid proxy scheme '%s'", u.Scheme)
+	}
+	if p.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		return nil, fmt.Errorf("invalid proxy '%s'", opts.Proxy)
+	}
+	if u.Port() != "" {
+		if p.Port, err = strconv.Atoi(u.Port()); err != nil || p.Port < 1 || p.Port > 65535 {
+			return nil, fmt.Errorf("invalid proxy port '%s'", u.Port())
+		}
+	}
+	if opts.ProxyBearer != "" {
+		p.Auth = "Bearer " + opts.ProxyBearer
+	} else if u.User != nil {
+		password, _ := u.User.Password()
+		p.Auth = client.BasicAuth(u.User.Username(), password)
+	}
+	return p, nil
+}
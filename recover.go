@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"syscall"
+
+	"github.com/codesoap/preq/client"
+)
+
+// isEarlyClose returns true if err indicates that the server closed the
+// connection while the response body was read.
+func isEarlyClose(err error) bool {
+	var perr *client.PhaseError
+	if !errors.As(err, &perr) || perr.Phase != client.PhaseBody {
+		return false
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
+
+// withConnectionClose returns req with any Connection header replaced
+// by "Connection: close".
+func withConnectionClose(req string) string {
+	return withHeader(req, "Connection", "close")
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// regexpList is a flag.Value that collects compiled regular
+// expressions from a flag, which may be given multiple times.
+type regexpList []*regexp.Regexp
+
+func (r *regexpList) String() string {
+	exprs := make([]string, len(*r))
+	for i, re := range *r {
+		exprs[i] = re.String()
+	}
+	return strings.Join(exprs, ",")
+}
+
+func (r *regexpList) Set(value string) error {
+	re, err := regexp.Compile(value)
+	if err != nil {
+		return err
+	}
+	*r = append(*r, re)
+	return nil
+}
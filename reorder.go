@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+)
+
+// send sends line to out. It returns false if ctx is done before.
+func send(ctx context.Context, out chan httpline, line httpline) bool {
+	select {
+	case out <- line:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// shuffleLines forwards the lines from in to out in a random order. At
+// most n lines are buffered, so lines are only moved within a window of
+// n lines.
+func shuffleLines(ctx context.Context, in, out chan httpline, n int) {
+	defer close(out)
+	window := make([]httpline, 0, n)
+	pop := func() httpline {
+		i := rand.Intn(len(window))
+		line := window[i]
+		window[i] = window[len(window)-1]
+		window = window[:len(window)-1]
+		return line
+	}
+	for line := range in {
+		window = append(window, line)
+		if len(window) == n && !send(ctx, out, pop()) {
+			return
+		}
+	}
+	for len(window) > 0 {
+		if !send(ctx, out, pop()) {
+			return
+		}
+	}
+}
+
+// interleaveLines forwards the lines from in to out, taking turns
+// between the hosts of the lines. At most n lines are buffered. Lines
+// for the same host keep their order.
+func interleaveLines(ctx context.Context, in, out chan httpline, n int) {
+	defer close(out)
+	var hosts []string // In the order of their turns.
+	queues := make(map[string][]httpline)
+	buffered := 0
+	pop := func() httpline {
+		host := hosts[0]
+		line := queues[host][0]
+		queues[host] = queues[host][1:]
+		hosts = hosts[1:]
+		if len(queues[host]) > 0 {
+			hosts = append(hosts, host)
+		} else {
+			delete(queues, host)
+		}
+		buffered--
+		return line
+	}
+	for line := range in {
+		host := strings.ToLower(line.Host)
+		if _, ok := queues[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		queues[host] = append(queues[host], line)
+		buffered++
+		if buffered == n && !send(ctx, out, pop()) {
+			return
+		}
+	}
+	for buffered > 0 {
+		if !send(ctx, out, pop()) {
+			return
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"math"
+	"slices"
+	"strconv"
+)
+
+// bench is the summary of the attempts of a repeated request.
+type bench struct {
+	N          int            `json:"n"`
+	OK         int            `json:"ok"`
+	Min        float64        `json:"min"` // Pings in milliseconds.
+	Avg        float64        `json:"avg"`
+	P95        float64        `json:"p95"`
+	Max        float64        `json:"max"`
+	Statuses   map[string]int `json:"statuses,omitempty"`
+	Consistent bool           `json:"consistent"`
+}
+
+// repeats returns how often request should be sent.
+func repeats(request httpline) int {
+	if request.Repeat > 0 {
+		return request.Repeat
+	}
+	return max(1, repeatFlag)
+}
+
+// doRepeated makes request as often as requested. Unless
+// -repeat-summary is given, a line is returned for each attempt. No
+// more attempts are made once -stop-after-total is reached, so nil is
+// returned if there was none.
+func doRepeated(ctx context.Context, request httpline) []httpline {
+	n := repeats(request)
+	if n == 1 {
+		if !reserve() {
+			return nil
+		}
+		return []httpline{doRequest(ctx, request)}
+	}
+	attempts := make([]httpline, 0, n)
+	for i := 1; i <= n && (i == 1 || ctx.Err() == nil); i++ {
+		if !reserve() {
+			break
+		}
+		attempt := doRequest(ctx, request)
+		attempt.Attempt = i
+		attempts = append(attempts, attempt)
+	}
+	if len(attempts) == 0 || !repeatSummaryFlag {
+		return attempts
+	}
+	return []httpline{summarize(attempts)}
+}
+
+// summarize returns the last of attempts with the "bench" field
+// describing all of them.
+func summarize(attempts []httpline) httpline {
+	b := bench{N: len(attempts), Statuses: make(map[string]int)}
+	var pings []int64 // In microseconds.
+	var sum int64
+	for _, attempt := range attempts {
+		if attempt.Err == "" {
+			b.OK++
+			ping := int64(math.Round(attempt.PingMS * 1000))
+			pings = append(pings, ping)
+			sum += ping
+		}
+		if code := statusCode(attempt.Resp); code != 0 {
+			b.Statuses[strconv.Itoa(code)]++
+		}
+	}
+	if len(pings) > 0 {
+		slices.Sort(pings)
+		b.Min, b.Max = microsToMillis(pings[0]), microsToMillis(pings[len(pings)-1])
+		b.Avg = microsToMillis(sum / int64(len(pings)))
+		b.P95 = microsToMillis(percentile(pings, 95))
+	}
+	b.Consistent = b.OK == b.N && len(b.Statuses) == 1
+	summary := attempts[len(attempts)-1]
+	summary.Attempt = 0
+	summary.Bench = &b
+	return summary
+}
+
+func microsToMillis(us int64) float64 {
+	return float64(us) / 1000
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// loadReqFiles reads the files of the "reqfile" and "bodyfile" fields of
+// line into its request: The content of "reqfile" replaces the missing
+// "req" field and the content of "bodyfile" is appended. Errors are
+// stored in line and reported when the request is made.
+func loadReqFiles(line *httpline) {
+	if line.ReqFile != "" {
+		if line.Req != "" {
+			line.fileErr = errors.New("req and reqfile cannot be combined")
+			return
+		}
+		req, err := os.ReadFile(line.ReqFile)
+		if err != nil {
+			line.fileErr = err
+			return
+		}
+		line.Req = string(req)
+	}
+	if line.BodyFile != "" {
+		body, err := os.ReadFile(line.BodyFile)
+		if err != nil {
+			line.fileErr = err
+			return
+		}
+		line.Req += string(body)
+		line.fileBodyLen = len(body)
+	}
+}
+
+// unloadReqFiles removes the contents of the files, that were read by
+// loadReqFiles, from the request of result again, to keep the output
+// small.
+func unloadReqFiles(result *httpline) {
+	if result.ReqFile != "" {
+		result.Req = ""
+	} else if result.fileBodyLen > 0 && len(result.Req) >= result.fileBodyLen {
+		result.Req = result.Req[:len(result.Req)-result.fileBodyLen]
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// statusCode returns the status code of the final response in resp or 0,
+// if it cannot be determined.
+func statusCode(resp string) int {
+	code, _ := statusAndReason(resp)
+	return code
+}
+
+// statusAndReason returns the status code and the reason phrase of the
+// final response in resp. The code is 0, if it cannot be determined. The
+// reason phrase is empty for HTTP/2 and HTTP/3 responses.
+func statusAndReason(resp string) (int, string) {
+	return parseStatusLine(finalResponse(resp))
+}
+
+// parseStatusLine returns the status code and the reason phrase from the
+// first line of msg. The code is 0, if msg does not start with a status
+// line.
+func parseStatusLine(msg string) (int, string) {
+	statusLine, _, _ := strings.Cut(msg, "\n")
+	_, rest, _ := strings.Cut(strings.TrimSpace(statusLine), " ")
+	codeStr, reason, _ := strings.Cut(strings.TrimLeft(rest, " "), " ")
+	code, err := strconv.Atoi(codeStr)
+	if err != nil {
+		return 0, ""
+	}
+	return code, strings.TrimSpace(reason)
+}
+
+// finalResponse returns resp without the interim 1xx responses, that
+// precede the final response, e.g. "100 Continue" or the "101 Switching
+// Protocols" of an h2c upgrade. A 101 response, that is not followed by
+// another response, like that of a WebSocket upgrade, is final. Other
+// messages, like requests, are returned unchanged.
+func finalResponse(resp string) string {
+	for {
+		if code, _ := parseStatusLine(resp); code < 100 || code > 199 {
+			return resp
+		}
+		_, rest, found := splitHead(resp)
+		if !found || !strings.HasPrefix(rest, "HTTP/") {
+			return resp
+		}
+		resp = rest
+	}
+}
+
+// splitHead splits the HTTP message msg after the empty line, that ends
+// its head. found is false, if the head is incomplete.
+func splitHead(msg string) (head, rest string, found bool) {
+	for i := 0; i < len(msg); {
+		end := strings.IndexByte(msg[i:], '\n')
+		if end < 0 {
+			break
+		}
+		line := msg[i : i+end]
+		i += end + 1
+		if line == "" || line == "\r" {
+			return msg[:i], msg[i:], true
+		}
+	}
+	return msg, "", false
+}
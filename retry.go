@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retryStatuses holds the status codes of -retry-status.
+var retryStatuses map[int]bool
+
+// parseStatusList parses a comma separated list of status codes.
+func parseStatusList(list string) (map[int]bool, error) {
+	statuses := make(map[int]bool)
+	for _, s := range strings.Split(list, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || code < 100 || code > 999 {
+			return nil, fmt.Errorf("invalid status code '%s'", s)
+		}
+		statuses[code] = true
+	}
+	return statuses, nil
+}
+
+// shouldRetry returns true if the status code of the response of result
+// is one of -retry-status and retries are left.
+func shouldRetry(result httpline) bool {
+	return retryStatuses[statusCode(result.Resp)] && result.Retries < retryMaxFlag
+}
+
+// retryDelay returns the time to wait before retrying the request of
+// result. The Retry-After header of the response is honored, but the
+// delay is capped at -retry-after-max. Without the header, the delay
+// doubles with each retry, starting at one second.
+func retryDelay(result httpline) time.Duration {
+	delay := time.Second << min(result.Retries, 10)
+	if values := headerValues(result.Resp, "Retry-After"); len(values) > 0 {
+		if seconds, err := strconv.Atoi(values[0]); err == nil && seconds >= 0 {
+			delay = time.Duration(seconds) * time.Second
+		} else if date, err := http.ParseTime(values[0]); err == nil {
+			delay = max(time.Until(date), 0)
+		}
+	}
+	return min(delay, retryAfterMaxFlag)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// samplePercentage is a flag.Value for the percentage of lines to
+// sample, like "10%".
+type samplePercentage float64
+
+func (s *samplePercentage) String() string {
+	if *s == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(*s), 'f', -1, 64) + "%"
+}
+
+func (s *samplePercentage) Set(value string) error {
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil || percent <= 0 || percent > 100 {
+		return fmt.Errorf("invalid percentage '%s'", value)
+	}
+	*s = samplePercentage(percent)
+	return nil
+}
+
+// lineRange is a flag.Value for a range of line numbers like "100:200".
+// Both ends are inclusive and optional.
+type lineRange struct {
+	from, to int // to is 0 if the range is open.
+}
+
+func (r *lineRange) String() string {
+	if *r == (lineRange{}) {
+		return ""
+	}
+	s := strconv.Itoa(r.from) + ":"
+	if r.to > 0 {
+		s += strconv.Itoa(r.to)
+	}
+	return s
+}
+
+func (r *lineRange) Set(value string) error {
+	from, to, found := strings.Cut(value, ":")
+	if !found {
+		return fmt.Errorf("invalid range '%s'", value)
+	}
+	var err error
+	r.from, r.to = 1, 0
+	if from != "" {
+		if r.from, err = strconv.Atoi(from); err != nil || r.from < 1 {
+			return fmt.Errorf("invalid range '%s'", value)
+		}
+	}
+	if to != "" {
+		if r.to, err = strconv.Atoi(to); err != nil || r.to < r.from {
+			return fmt.Errorf("invalid range '%s'", value)
+		}
+	}
+	return nil
+}
+
+func (r lineRange) contains(lineno int) bool {
+	return lineno >= r.from && (r.to == 0 || lineno <= r.to)
+}
+
+// sampler decides randomly whether lines are part of the -sample.
+var sampler *rand.Rand
+
+// selected returns true if the line with the given number shall be
+// requested according to -range and -sample.
+func selected(lineno int) bool {
+	if rangeFlag != (lineRange{}) && !rangeFlag.contains(lineno) {
+		return false
+	}
+	return sampleFlag == 0 || sampler.Float64()*100 < float64(sampleFlag)
+}
+
+// beyondRange returns true if no line following the one with the given
+// number can be selected anymore.
+func beyondRange(lineno int) bool {
+	return rangeFlag.to > 0 && lineno >= rangeFlag.to
+}
+
+// passLine sends rawLine to out, so that it is written to the output
+// untouched. It returns false if ctx is done before.
+func passLine(ctx context.Context, rawLine []byte, out chan httpline) bool {
+	select {
+	case out <- httpline{untouched: bytes.Clone(rawLine)}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// writeUntouched writes an input line, that was passed through, to w.
+func writeUntouched(w io.Writer, rawLine []byte) {
+	var err error
+	if formatFlag != "json" {
+		err = writeRow(w, rawLine, "")
+	} else {
+		_, err = fmt.Fprintln(w, string(rawLine))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: Could not write result:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codesoap/preq/client"
+	"github.com/codesoap/preq/extractor"
+)
+
+// selftestTimeout is the timeout of the requests made by -selftest.
+const selftestTimeout = time.Second
+
+// selftestCase is a request of -selftest and the check of its output
+// line, which returns a description of the problem, if there is one.
+type selftestCase struct {
+	name  string
+	line  map[string]any
+	check func(line httpline) string
+}
+
+// selftest starts local HTTP and HTTPS servers, makes requests to them
+// by running preq itself and checks the output lines. The result of
+// each check is printed to standard output and preq exits with status
+// 1, if one failed.
+func selftest() {
+	httpPort, err := serveSelftestHTTP()
+	if err == nil {
+		var httpsPort, closedPort int
+		if httpsPort, err = serveSelftestHTTPS(); err == nil {
+			closedPort, err = unusedPort()
+		}
+		if err == nil {
+			err = runSelftest(selftestCases(httpPort, httpsPort, closedPort))
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: Could not run self-test:", err)
+		os.Exit(1)
+	}
+}
+
+func selftestCases(httpPort, httpsPort, closedPort int) []selftestCase {
+	plain := func(path string) map[string]any {
+		return map[string]any{
+			"host": "127.0.0.1",
+			"port": httpPort,
+			"tls":  false,
+			"req":  "GET " + path + " HTTP/1.1\r\nHost: 127.0.0.1\r\n\r\n",
+		}
+	}
+	secure := func(fields map[string]any) map[string]any {
+		line := map[string]any{
+			"host": "127.0.0.1",
+			"port": httpsPort,
+			"req":  "GET / HTTP/1.1\r\nHost: 127.0.0.1\r\n\r\n",
+		}
+		for name, value := range fields {
+			line[name] = value
+		}
+		return line
+	}
+	insecure := map[string]any{"insecure": true}
+	refused := plain("/")
+	refused["port"] = closedPort
+	return []selftestCase{
+		{"content-length", plain("/length"), func(line httpline) string {
+			return expectFraming(line, extractor.FramingLength, "hello")
+		}},
+		{"chunked", plain("/chunked"), func(line httpline) string {
+			return expectFraming(line, extractor.FramingChunked, "0\r\n\r\n")
+		}},
+		{"close-delimited", plain("/close"), func(line httpline) string {
+			return expectFraming(line, extractor.FramingClose, "hello")
+		}},
+		{"conflicting framing", plain("/conflict"), func(line httpline) string {
+			if problem := expectFraming(line, extractor.FramingChunked, "0\r\n\r\n"); problem != "" {
+				return problem
+			} else if line.FrameRule != extractor.RuleEncodingOverridesLength {
+				return fmt.Sprintf("unexpected framing rule '%s'", line.FrameRule)
+			}
+			return ""
+		}},
+		{"keep-alive", plain("/length"), func(line httpline) string {
+			if line.ConnClose == nil || !line.ConnClose.Persistent || line.ConnClose.Header {
+				return "connection not persistent"
+			}
+			return ""
+		}},
+		{"connection close", plain("/connclose"), func(line httpline) string {
+			if line.ConnClose == nil || line.ConnClose.Persistent || !line.ConnClose.Header {
+				return "Connection: close not detected"
+			}
+			return ""
+		}},
+		{"slow body", plain("/slow"), func(line httpline) string {
+			if problem := expectFraming(line, extractor.FramingLength, "hello"); problem != "" {
+				return problem
+			} else if line.DurMS < 200 || line.PingMS >= line.DurMS-150 {
+				return fmt.Sprintf("unexpected timing: pingms %v, durms %v", line.PingMS, line.DurMS)
+			}
+			return ""
+		}},
+		{"truncated body", plain("/truncated"), func(line httpline) string {
+			return expectErr(line, 0, client.PhaseBody)
+		}},
+		{"no response", plain("/hang"), func(line httpline) string {
+			return expectErr(line, 33, client.PhaseHead)
+		}},
+		{"connection refused", refused, func(line httpline) string {
+			return expectErr(line, 30, client.PhaseConnect)
+		}},
+		{"invalid certificate", secure(nil), func(line httpline) string {
+			return expectErr(line, 20, client.PhaseTLS)
+		}},
+		{"TLS 1.3", secure(map[string]any{"tlsopts": insecure}), func(line httpline) string {
+			return expectTLS(line, "TLS 1.3", "")
+		}},
+		{"TLS 1.2", secure(map[string]any{"tlsopts": insecure, "tlsmax": "1.2"}), func(line httpline) string {
+			return expectTLS(line, "TLS 1.2", "")
+		}},
+		{"HTTP/2", secure(map[string]any{"tlsopts": insecure, "h2": true}), func(line httpline) string {
+			return expectTLS(line, "TLS 1.3", "h2")
+		}},
+	}
+}
+
+func expectFraming(line httpline, framing, bodySuffix string) string {
+	switch {
+	case line.Err != "":
+		return "unexpected error: " + line.Err
+	case line.Status != 200:
+		return fmt.Sprintf("unexpected status %d", line.Status)
+	case line.Framing != framing:
+		return fmt.Sprintf("unexpected framing '%s'", line.Framing)
+	case !strings.HasSuffix(line.Resp, bodySuffix):
+		return fmt.Sprintf("unexpected response %q", line.Resp)
+	}
+	return ""
+}
+
+// expectErr checks that line failed in phase. errno 0 accepts any.
+func expectErr(line httpline, errno int, phase string) string {
+	switch {
+	case line.Err == "":
+		return "no error"
+	case errno != 0 && line.Errno != errno:
+		return fmt.Sprintf("unexpected errno %d: %s", line.Errno, line.Err)
+	case line.Errdetail != phase:
+		return fmt.Sprintf("unexpected errdetail '%s': %s", line.Errdetail, line.Err)
+	}
+	return ""
+}
+
+func expectTLS(line httpline, version, proto string) string {
+	switch {
+	case line.Err != "":
+		return "unexpected error: " + line.Err
+	case line.Status != 200:
+		return fmt.Sprintf("unexpected status %d", line.Status)
+	case line.TLSVersion != version:
+		return fmt.Sprintf("unexpected TLS version '%s'", line.TLSVersion)
+	case line.ALPNProto != proto:
+		return fmt.Sprintf("unexpected ALPN protocol '%s'", line.ALPNProto)
+	}
+	return ""
+}
+
+// runSelftest runs preq with the requests of cases as input and checks
+// the output lines.
+func runSelftest(cases []selftestCase) error {
+	var input bytes.Buffer
+	for _, c := range cases {
+		line, err := json.Marshal(c.line)
+		if err != nil {
+			return err
+		}
+		input.Write(append(line, '\n'))
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(executable, "-t", selftestTimeout.String(), "-p", strconv.Itoa(len(cases)))
+	cmd.Stdin, cmd.Stderr = &input, os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return err
+	}
+	lines := make(map[int]httpline)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var line httpline
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return err
+		}
+		lines[line.N] = line
+	}
+	failed := 0
+	for i, c := range cases {
+		line, ok := lines[i+1]
+		problem := "no output line"
+		if ok {
+			problem = c.check(line)
+		}
+		if problem != "" {
+			failed++
+			fmt.Printf("FAIL %s: %s\n", c.name, problem)
+		} else {
+			fmt.Printf("ok   %s\n", c.name)
+		}
+	}
+	fmt.Printf("%d of %d checks passed.\n", len(cases)-failed, len(cases))
+	if failed > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// serveSelftestHTTP starts a server, whose responses deviate in framing
+// and timing depending on the path of the request, and returns its
+// port.
+func serveSelftestHTTP() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSelftestConn(conn)
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func serveSelftestConn(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			return
+		}
+		switch req.URL.Path {
+		case "/length":
+			fmt.Fprint(conn, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello")
+		case "/chunked":
+			fmt.Fprint(conn, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n")
+		case "/close":
+			fmt.Fprint(conn, "HTTP/1.1 200 OK\r\n\r\nhello")
+			return
+		case "/conflict":
+			fmt.Fprint(conn, "HTTP/1.1 200 OK\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n")
+		case "/connclose":
+			fmt.Fprint(conn, "HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: 5\r\n\r\nhello")
+			return
+		case "/slow":
+			fmt.Fprint(conn, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nh")
+			for _, part := range []string{"el", "lo"} {
+				time.Sleep(100 * time.Millisecond)
+				fmt.Fprint(conn, part)
+			}
+		case "/truncated":
+			fmt.Fprint(conn, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhello")
+			return
+		case "/hang":
+			// Wait for the client to give up.
+			reader.ReadByte()
+			return
+		default:
+			fmt.Fprint(conn, "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n")
+		}
+	}
+}
+
+// serveSelftestHTTPS starts an HTTPS server, which supports HTTP/2 and
+// uses a self-signed certificate, and returns its port.
+func serveSelftestHTTPS() (int, error) {
+	cert, err := selfSignedCert()
+	if err != nil {
+		return 0, err
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "hello")
+		}),
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		ErrorLog:  log.New(io.Discard, "", 0), // The invalid certificate causes errors.
+	}
+	go server.ServeTLS(l, "", "")
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func selfSignedCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "preq self-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// unusedPort returns a port, on which nothing listens.
+func unusedPort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// socketOptions is only supported on Unix-like systems.
+func socketOptions() (func(network, address string, c syscall.RawConn) error, error) {
+	if tcpSndBufFlag == 0 && tcpRcvBufFlag == 0 && ttlFlag == 0 {
+		return nil, nil
+	}
+	return nil, errors.New("socket buffer sizes and TTL are only supported on Unix-like systems")
+}
@@ -0,0 +1,39 @@
+//go:build unix
+
+package main
+
+import (
+	"strings"
+	"syscall"
+)
+
+// socketOptions returns a net.Dialer.Control function, which applies
+// the buffer sizes and TTL of the flags to sockets. nil is returned, if
+// none of these flags is set.
+func socketOptions() (func(network, address string, c syscall.RawConn) error, error) {
+	if tcpSndBufFlag == 0 && tcpRcvBufFlag == 0 && ttlFlag == 0 {
+		return nil, nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		controlErr := c.Control(func(fd uintptr) {
+			if tcpSndBufFlag > 0 && err == nil {
+				err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF, tcpSndBufFlag)
+			}
+			if tcpRcvBufFlag > 0 && err == nil {
+				err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, tcpRcvBufFlag)
+			}
+			if ttlFlag > 0 && err == nil {
+				if strings.HasSuffix(network, "6") {
+					err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttlFlag)
+				} else {
+					err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttlFlag)
+				}
+			}
+		})
+		if controlErr != nil {
+			return controlErr
+		}
+		return err
+	}, nil
+}
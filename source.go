@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// sourceOptions are the per-line options for the local end of
+// connections. They override the corresponding flags.
+type sourceOptions struct {
+	SourceIP  string `json:"sourceip,omitempty"`
+	Interface string `json:"interface,omitempty"`
+}
+
+// dialer returns the dialer for the given options, which override those
+// of the flags. The socket options of the -tcp-* and -ttl flags are
+// applied as well. nil is returned, if no options are set.
+func dialer(opts sourceOptions) (*net.Dialer, error) {
+	if opts.SourceIP == "" {
+		opts.SourceIP = sourceIPFlag
+	}
+	if opts.Interface == "" {
+		opts.Interface = interfaceFlag
+	}
+	if opts == (sourceOptions{}) && !tcpOptionsSet() {
+		return nil, nil
+	}
+	if err := checkTCPOptions(); err != nil {
+		return nil, err
+	}
+	sockopts, err := socketOptions()
+	if err != nil {
+		return nil, err
+	}
+	d := &net.Dialer{KeepAlive: tcpKeepAliveFlag, Control: sockopts}
+	if opts.SourceIP != "" {
+		ip := net.ParseIP(opts.SourceIP)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source IP '%s'", opts.SourceIP)
+		}
+		d.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	if opts.Interface != "" {
+		if _, err := net.InterfaceByName(opts.Interface); err != nil {
+			return nil, fmt.Errorf("invalid interface '%s': %w", opts.Interface, err)
+		}
+		control, err := bindToInterface(opts.Interface)
+		if err != nil {
+			return nil, err
+		}
+		d.Control = chainControls(control, sockopts)
+	}
+	return d, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// stats collects summary statistics about the results of a run.
+type stats struct {
+	total     int
+	successes int
+	errnos    map[int]int
+	statuses  map[int]int
+	pings     []int64
+}
+
+func newStats() *stats {
+	return &stats{errnos: make(map[int]int), statuses: make(map[int]int)}
+}
+
+func (s *stats) add(result httpline) {
+	s.total++
+	if result.Errno != 0 {
+		s.errnos[result.Errno]++
+	} else {
+		s.successes++
+	}
+	if code := statusCode(result.Resp); code != 0 {
+		s.statuses[code]++
+	}
+	if result.Reqat != nil && result.Resp != "" {
+		s.pings = append(s.pings, result.Ping)
+	}
+}
+
+func (s *stats) print(w io.Writer) {
+	fmt.Fprintf(w, "Lines: %d\n", s.total)
+	fmt.Fprintf(w, "Successes: %d\n", s.successes)
+	for _, errno := range sortedKeys(s.errnos) {
+		fmt.Fprintf(w, "errno %d: %d\n", errno, s.errnos[errno])
+	}
+	for _, code := range sortedKeys(s.statuses) {
+		fmt.Fprintf(w, "Status %d: %d\n", code, s.statuses[code])
+	}
+	if len(s.pings) > 0 {
+		sort.Slice(s.pings, func(i, j int) bool { return s.pings[i] < s.pings[j] })
+		fmt.Fprintf(w, "Ping p50/p90/p99: %dms/%dms/%dms\n",
+			percentile(s.pings, 50), percentile(s.pings, 90), percentile(s.pings, 99))
+	}
+}
+
+func sortedKeys(m map[int]int) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// percentile returns the p-th percentile of the sorted values, using the
+// nearest-rank method.
+func percentile(sorted []int64, p int) int64 {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// stopped is set once the limits of -stop-after-ok or -stop-after-total
+// have been reached or -run-timeout expired. From then on, no more
+// requests are made and the remaining input lines are written to the
+// output untouched.
+var stopped atomic.Bool
+
+// runEnd is the time at which -run-timeout expires or zero.
+var runEnd time.Time
+
+// runTimedOut is set once -run-timeout expired and the remaining lines
+// are being skipped.
+var runTimedOut atomic.Bool
+
+var expireRunOnce sync.Once
+
+// startRunTimeout makes preq stop after -run-timeout.
+func startRunTimeout() {
+	runEnd = time.Now().Add(runTimeoutFlag)
+	time.AfterFunc(runTimeoutFlag, expireRun)
+}
+
+func expireRun() {
+	expireRunOnce.Do(func() {
+		runTimedOut.Store(true)
+		stopped.Store(true)
+		fmt.Fprintln(os.Stderr, "Run timeout expired; skipping the remaining lines.")
+	})
+}
+
+// isStopped returns true if no more requests shall be made. Unlike
+// stopped, it takes the end of -run-timeout into account right away.
+func isStopped() bool {
+	if runExpired() {
+		expireRun()
+	}
+	return stopped.Load()
+}
+
+// runExpired returns true if -run-timeout has expired.
+func runExpired() bool {
+	return !runEnd.IsZero() && !time.Now().Before(runEnd)
+}
+
+// withRunDeadline returns ctx with the deadline of -run-timeout, so that
+// requests in flight are cancelled when it expires.
+func withRunDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if runEnd.IsZero() {
+		return ctx, func() {}
+	}
+	return context.WithDeadline(ctx, runEnd)
+}
+
+// stopAfter counts the successful results for -stop-after-ok.
+type stopAfter struct {
+	ok int64
+}
+
+// add counts result and sets stopped, if the limit has been reached.
+func (s *stopAfter) add(result httpline) {
+	if result.Errno == 0 {
+		s.ok++
+	}
+	if stopAfterOKFlag > 0 && s.ok >= stopAfterOKFlag {
+		stopped.Store(true)
+	}
+}
+
+// reserved counts the requests for -stop-after-total. Requests are
+// counted before they are made, rather than when their results are
+// printed, so that no more than the given number are made, even with
+// -fan-out, -repeat or many requests in flight.
+var reserved atomic.Int64
+
+// reserve claims one of the requests of -stop-after-total before a
+// request is made and sets stopped, once the last one is claimed. It
+// returns false if none is left.
+func reserve() bool {
+	if stopAfterTotalFlag <= 0 {
+		return true
+	}
+	n := reserved.Add(1)
+	if n >= stopAfterTotalFlag {
+		stopped.Store(true)
+	}
+	return n <= stopAfterTotalFlag
+}
+
+// keepInput stores a copy of the input line rawLine in line, if it may
+// be needed for writing it untouched after stopping.
+func keepInput(line *httpline, rawLine []byte) {
+	if stopAfterOKFlag > 0 || stopAfterTotalFlag > 0 || runTimeoutFlag > 0 {
+		line.input = bytes.Clone(rawLine)
+	}
+}
+
+// skipAfterStop returns the untouched input line of line, if no more
+// requests shall be made.
+func skipAfterStop(line httpline) (httpline, bool) {
+	if !isStopped() {
+		return line, false
+	}
+	return httpline{untouched: markSkipped(line.input)}, true
+}
+
+// markSkipped adds the field "skipped" to the input line rawLine, if
+// -run-timeout expired. Otherwise rawLine is returned unchanged.
+func markSkipped(rawLine []byte) []byte {
+	trimmed := bytes.TrimSpace(rawLine)
+	if !runTimedOut.Load() || len(trimmed) < 2 || trimmed[0] != '{' {
+		return rawLine
+	}
+	marked := []byte(`{"skipped":true`)
+	if rest := bytes.TrimSpace(trimmed[1:]); rest[0] != '}' {
+		marked = append(marked, ',')
+	}
+	return append(marked, trimmed[1:]...)
+}
@@ -0,0 +1,16 @@
+package main
+
+import "strings"
+
+// stringList is a flag.Value that collects the values of a flag, which
+// may be given multiple times.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// computedColumns are the columns of -format csv and tsv, that are
+// derived from the response instead of being fields of the output line.
+var computedColumns = map[string]func(resp string) string{
+	"bodyhash": func(resp string) string {
+		if resp == "" {
+			return ""
+		}
+		digest := sha256.Sum256([]byte(responseBody(resp)))
+		return hex.EncodeToString(digest[:])
+	},
+	"bodylen": func(resp string) string {
+		if resp == "" {
+			return ""
+		}
+		return strconv.Itoa(len(responseBody(resp)))
+	},
+}
+
+// tables holds the CSV writers for the outputs by output.
+var tables = make(map[io.Writer]*csv.Writer)
+
+// writeRow writes the output line out as a row of the columns given by
+// -fields to w. resp is the response of the line, from which the
+// computed columns are derived. A header row is written before the
+// first row.
+func writeRow(w io.Writer, out []byte, resp string) error {
+	columns := strings.Split(fieldsFlag, ",")
+	table, ok := tables[w]
+	if !ok {
+		table = csv.NewWriter(w)
+		if formatFlag == "tsv" {
+			table.Comma = '\t'
+		}
+		tables[w] = table
+		table.Write(columns)
+	}
+	var fields map[string]json.RawMessage
+	dec := json.NewDecoder(bytes.NewReader(out))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return err
+	}
+	row := make([]string, len(columns))
+	for i, column := range columns {
+		if compute, ok := computedColumns[column]; ok {
+			row[i] = compute(resp)
+		} else if value, ok := fields[column]; ok {
+			row[i] = cellValue(value)
+		}
+	}
+	table.Write(row)
+	table.Flush()
+	return table.Error()
+}
+
+// cellValue returns the value of a JSON field as a cell. Strings are
+// unquoted, other values are kept in their JSON form.
+func cellValue(value json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		return s
+	}
+	return string(value)
+}
+
+func checkFormat() error {
+	switch formatFlag {
+	case "json", "csv", "tsv":
+	default:
+		return fmt.Errorf("unknown format '%s'", formatFlag)
+	}
+	if fieldsFlag == "" {
+		return fmt.Errorf("no fields given")
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// tcpOptionsSet reports whether any socket option flag is set.
+func tcpOptionsSet() bool {
+	return tcpKeepAliveFlag != 0 || tcpSndBufFlag != 0 || tcpRcvBufFlag != 0 || ttlFlag != 0
+}
+
+// checkTCPOptions returns an error, if the socket option flags have
+// invalid values.
+func checkTCPOptions() error {
+	if tcpSndBufFlag < 0 {
+		return fmt.Errorf("invalid send buffer size %d", tcpSndBufFlag)
+	}
+	if tcpRcvBufFlag < 0 {
+		return fmt.Errorf("invalid receive buffer size %d", tcpRcvBufFlag)
+	}
+	if ttlFlag < 0 || ttlFlag > 255 {
+		return fmt.Errorf("invalid TTL %d", ttlFlag)
+	}
+	return nil
+}
+
+// chainControls returns a net.Dialer.Control function, which calls the
+// given functions, that are not nil, in order.
+func chainControls(controls ...func(network, address string, c syscall.RawConn) error) func(network, address string, c syscall.RawConn) error {
+	var used []func(network, address string, c syscall.RawConn) error
+	for _, control := range controls {
+		if control != nil {
+			used = append(used, control)
+		}
+	}
+	switch len(used) {
+	case 0:
+		return nil
+	case 1:
+		return used[0]
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		for _, control := range used {
+			if err := control(network, address, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// failureThreshold is a flag.Value for the maximum number of failed
+// requests. It is either an absolute number or a percentage of all
+// requests, if suffixed with "%".
+type failureThreshold struct {
+	set     bool
+	n       int64
+	percent float64
+}
+
+func (f *failureThreshold) String() string {
+	switch {
+	case !f.set:
+		return ""
+	case f.percent > 0:
+		return strconv.FormatFloat(f.percent, 'f', -1, 64) + "%"
+	}
+	return strconv.FormatInt(f.n, 10)
+}
+
+func (f *failureThreshold) Set(value string) error {
+	if p, isPercent := strings.CutSuffix(value, "%"); isPercent {
+		percent, err := strconv.ParseFloat(p, 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return fmt.Errorf("invalid percentage '%s'", value)
+		}
+		f.set, f.percent = true, percent
+		return nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid number '%s'", value)
+	}
+	f.set, f.n = true, n
+	return nil
+}
+
+// exceeded returns true if failed out of total requests exceed the
+// threshold.
+func (f *failureThreshold) exceeded(failed, total int64) bool {
+	switch {
+	case !f.set:
+		return false
+	case f.percent > 0:
+		return total > 0 && 100*float64(failed)/float64(total) > f.percent
+	}
+	return failed > f.n
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/codesoap/preq/client"
+)
+
+// tlsOptions are the per-line TLS options. They override the
+// corresponding flags.
+type tlsOptions struct {
+	TLSMin     string   `json:"tlsmin,omitempty"`
+	TLSMax     string   `json:"tlsmax,omitempty"`
+	TLSCiphers []string `json:"tlsciphers,omitempty"`
+	Hello      string   `json:"hello,omitempty"`
+	TLSOpts    *tlsOpts `json:"tlsopts,omitempty"`
+}
+
+// tlsOpts is the "tlsopts" object of a line, which allows mixing strict
+// and lax targets in one input. Its options override the corresponding
+// flags and fields.
+type tlsOpts struct {
+	Insecure      *bool    `json:"insecure,omitempty"`
+	MinVersion    string   `json:"minversion,omitempty"`
+	SNI           string   `json:"sni,omitempty"`
+	ALPN          []string `json:"alpn,omitempty"`
+	ClientCertRef string   `json:"clientcertref,omitempty"`
+}
+
+func (o tlsOptions) equal(other tlsOptions) bool {
+	return o.TLSMin == other.TLSMin && o.TLSMax == other.TLSMax &&
+		slices.Equal(o.TLSCiphers, other.TLSCiphers) && o.Hello == other.Hello &&
+		o.TLSOpts.equal(other.TLSOpts)
+}
+
+func (o *tlsOpts) equal(other *tlsOpts) bool {
+	if o == nil || other == nil {
+		return o == other
+	}
+	return (o.Insecure == nil) == (other.Insecure == nil) &&
+		(o.Insecure == nil || *o.Insecure == *other.Insecure) &&
+		o.MinVersion == other.MinVersion && o.SNI == other.SNI &&
+		slices.Equal(o.ALPN, other.ALPN) && o.ClientCertRef == other.ClientCertRef
+}
+
+// clientCerts holds the certificates of -client-cert by their name. The
+// certificate without name is used, if a line references none.
+var clientCerts = make(map[string]tls.Certificate)
+
+// addClientCert loads the certificate of a -client-cert value like
+// "cert.pem,key.pem" or "name=cert.pem,key.pem".
+func addClientCert(value string) error {
+	name, files, found := strings.Cut(value, "=")
+	if !found {
+		name, files = "", value
+	}
+	certFile, keyFile, found := strings.Cut(files, ",")
+	if !found {
+		return fmt.Errorf("missing key file in '%s'", value)
+	}
+	if _, exists := clientCerts[name]; exists {
+		return fmt.Errorf("duplicate certificate name '%s'", name)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	clientCerts[name] = cert
+	return nil
+}
+
+// sessionCache is shared by all requests, if -tls-session-cache is
+// given.
+var sessionCache tls.ClientSessionCache
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	if v, ok := tlsVersions[version]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("invalid TLS version '%s'", version)
+}
+
+// parseCipherSuites returns the IDs of the cipher suites with the given
+// names. Insecure cipher suites are accepted, too.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	suites := append(tls.CipherSuites(), tls.InsecureCipherSuites()...)
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		i := slices.IndexFunc(suites, func(s *tls.CipherSuite) bool { return s.Name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown cipher suite '%s'", name)
+		}
+		ids = append(ids, suites[i].ID)
+	}
+	return ids, nil
+}
+
+// tlsConfig returns the TLS configuration for the given options, which
+// override those of the flags. The options of "tlsopts" take precedence
+// over the other fields. nil is returned, if no options are set.
+func tlsConfig(opts tlsOptions) (*tls.Config, error) {
+	if opts.TLSMin == "" {
+		opts.TLSMin = tlsMinFlag
+	}
+	if opts.TLSMax == "" {
+		opts.TLSMax = tlsMaxFlag
+	}
+	if opts.TLSCiphers == nil && tlsCiphersFlag != "" {
+		opts.TLSCiphers = strings.Split(tlsCiphersFlag, ",")
+	}
+	insecure, sni, certRef := tlsVerifyFlag == "none", "", ""
+	if o := opts.TLSOpts; o != nil {
+		if o.Insecure != nil {
+			insecure = *o.Insecure
+		}
+		if o.MinVersion != "" {
+			opts.TLSMin = o.MinVersion
+		}
+		sni, certRef = o.SNI, o.ClientCertRef
+	}
+	cert, hasCert := clientCerts[certRef]
+	if !hasCert && certRef != "" {
+		return nil, fmt.Errorf("unknown client certificate '%s'", certRef)
+	}
+	opts.Hello, opts.TLSOpts = "", nil
+	if opts.equal(tlsOptions{}) && sessionCache == nil && !tlsNoTicketsFlag && !insecure && sni == "" && !hasCert {
+		return nil, nil
+	}
+	conf := &tls.Config{
+		ClientSessionCache:     sessionCache,
+		SessionTicketsDisabled: tlsNoTicketsFlag,
+		InsecureSkipVerify:     insecure,
+		ServerName:             sni,
+	}
+	if hasCert {
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	var err error
+	if opts.TLSMin != "" {
+		if conf.MinVersion, err = parseTLSVersion(opts.TLSMin); err != nil {
+			return nil, err
+		}
+	}
+	if opts.TLSMax != "" {
+		if conf.MaxVersion, err = parseTLSVersion(opts.TLSMax); err != nil {
+			return nil, err
+		}
+	}
+	if len(opts.TLSCiphers) > 0 {
+		if conf.CipherSuites, err = parseCipherSuites(opts.TLSCiphers); err != nil {
+			return nil, err
+		}
+	}
+	return conf, nil
+}
+
+// hello returns the name of the ClientHello to mimic for the given
+// options, which override -tls-hello.
+func hello(opts tlsOptions) (string, error) {
+	name := opts.Hello
+	if name == "" {
+		name = tlsHelloFlag
+	}
+	if name != "" && !slices.Contains(client.Hellos(), name) {
+		return "", fmt.Errorf("unknown ClientHello '%s'", name)
+	}
+	return name, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// captureSelected returns true if a transcript of result shall be
+// written according to -capture-lines.
+func captureSelected(result httpline) bool {
+	return captureDirFlag != "" && result.transcript != nil &&
+		(captureLinesFlag == "all" || result.Errno != 0)
+}
+
+// writeTranscript writes the transcript of result to a file in dir and
+// stores its path in the "transcript" field. Each entry of the
+// transcript starts with a line containing the time, the direction (">"
+// for sent and "<" for received data) and the number of bytes, which
+// are followed by the data itself and a newline.
+func writeTranscript(result *httpline, dir string) error {
+	name := strconv.Itoa(result.N)
+	if result.Attempt > 0 {
+		name += "-" + strconv.Itoa(result.Attempt)
+	}
+	path := filepath.Join(dir, name+".transcript")
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "# preq transcript of line %d, run %s\n", result.N, result.RunID)
+	fmt.Fprintf(w, "# %s port %d tls %t\n", result.Host, result.Port, *result.TLS)
+	for _, entry := range result.transcript {
+		direction := "<"
+		if entry.Sent {
+			direction = ">"
+		}
+		fmt.Fprintf(w, "%s %s %d\n", entry.Time.UTC().Format(time.RFC3339Nano), direction, len(entry.Data))
+		w.Write(entry.Data)
+		w.WriteString("\n")
+	}
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	result.Transcript = path
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// validateRequest checks the raw request req for common problems and
+// returns a description of each problem found.
+func validateRequest(req string) []string {
+	var problems []string
+	head, body, complete := strings.Cut(req, "\r\n\r\n")
+	if !complete {
+		problems = append(problems, "head is not terminated by CRLFCRLF")
+		head, body, _ = strings.Cut(req, "\n\n")
+	}
+	if strings.Contains(strings.ReplaceAll(head, "\r\n", ""), "\n") {
+		problems = append(problems, "bare LF line ending in head")
+	}
+	lines := strings.Split(strings.ReplaceAll(head, "\r\n", "\n"), "\n")
+	if problem := validateRequestLine(lines[0]); problem != "" {
+		problems = append(problems, problem)
+	}
+	hasHost, chunked, hasLength := false, false, false
+	for _, line := range lines[1:] {
+		name, value, _ := strings.Cut(line, ":")
+		switch strings.ToLower(name) {
+		case "host":
+			hasHost = true
+		case "transfer-encoding":
+			chunked = chunked || strings.Contains(strings.ToLower(value), "chunked")
+		case "content-length":
+			// Content-Length: 0 announces no body.
+			hasLength = hasLength || strings.TrimSpace(value) != "0"
+		}
+	}
+	if !hasHost {
+		problems = append(problems, "missing Host header")
+	}
+	method, _, _ := strings.Cut(lines[0], " ")
+	version := lines[0][strings.LastIndex(lines[0], " ")+1:]
+	if strings.HasPrefix(version, "HTTP/") && version != "HTTP/1.1" && version != "HTTP/1.0" {
+		problems = append(problems, fmt.Sprintf("unusual HTTP version '%s'", version))
+	}
+	if chunked && version == "HTTP/1.0" {
+		problems = append(problems, "chunked encoding in HTTP/1.0 request")
+	}
+	if (body != "" || chunked || hasLength) && (method == "GET" || method == "HEAD" || method == "TRACE") {
+		problems = append(problems, fmt.Sprintf("%s request with body", method))
+	}
+	return problems
+}
+
+func validateRequestLine(line string) string {
+	fields := strings.Split(line, " ")
+	if len(fields) != 3 {
+		return fmt.Sprintf("malformed request line '%s'", line)
+	}
+	for _, c := range fields[0] {
+		if c < 'A' || c > 'Z' {
+			return fmt.Sprintf("invalid method '%s'", fields[0])
+		}
+	}
+	if fields[1] == "" {
+		return "empty request target"
+	}
+	if !strings.HasPrefix(fields[2], "HTTP/") {
+		return fmt.Sprintf("invalid HTTP version '%s'", fields[2])
+	}
+	return ""
+}
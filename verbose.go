@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+)
+
+// verbosity is 0 by default, 1 with -v and 2 with -vv.
+var verbosity int
+
+var verboseLogger = log.New(os.Stderr, "", log.Ltime|log.Lmicroseconds)
+
+// linenoKey is the context key for the input line number of a request.
+type linenoKey struct{}
+
+// logf logs the given message to standard error, if the verbosity is at
+// least level. The message is tagged with the input line number of
+// request.
+func logf(level int, request httpline, format string, v ...any) {
+	logLine(level, request.N, format, v...)
+}
+
+// clientLogf is used as client.Client.Logf. The input line number is
+// taken from ctx.
+func clientLogf(ctx context.Context, level int, format string, v ...any) {
+	lineno, _ := ctx.Value(linenoKey{}).(int)
+	logLine(level, lineno, format, v...)
+}
+
+func logLine(level, lineno int, format string, v ...any) {
+	if verbosity < level {
+		return
+	}
+	verboseLogger.Printf("line %d: "+format, append([]any{lineno}, v...)...)
+}
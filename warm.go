@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/codesoap/preq/client"
+)
+
+// warmConns holds the connections established ahead of the requests,
+// if -tls-warm is given. A nil *warmPool establishes nothing.
+var warmConns *warmPool
+
+// warmPool establishes TLS connections ahead of the lines, that will
+// use them, so that the handshakes overlap with other requests.
+type warmPool struct {
+	mu       sync.Mutex
+	ctx      context.Context // Bounds the lifetime of the connections.
+	cancel   context.CancelFunc
+	limit    int            // The maximum number of connections per host.
+	limits   map[string]int // Overrides limit for some hosts.
+	hosts    map[string]*warmHost
+	isClosed bool
+}
+
+// warmHost holds the connections to a host, that have not been claimed
+// by a line yet.
+type warmHost struct {
+	conns  []*warmConn
+	wanted int // The lines, that have passed warmUp but not claimed a connection.
+}
+
+// warmConn is a connection, that is established for line. conn is nil,
+// if establishing it failed, and is only valid once done is closed.
+type warmConn struct {
+	line httpline
+	done chan struct{}
+	conn *client.WarmConn
+}
+
+// newWarmPool parses the values of -tls-warm, which are either a number
+// of connections per host or "host=n", to override it for a host.
+func newWarmPool(ctx context.Context, values []string) (*warmPool, error) {
+	p := &warmPool{limits: make(map[string]int), hosts: make(map[string]*warmHost)}
+	for _, value := range values {
+		host, count, found := strings.Cut(value, "=")
+		if !found {
+			host, count = "", value
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid number of connections in '%s'", value)
+		}
+		if found {
+			p.limits[strings.ToLower(host)] = n
+		} else {
+			p.limit = n
+		}
+	}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	return p, nil
+}
+
+// canWarm reports whether a connection can be established for request
+// ahead of time. request must have its defaults set.
+func canWarm(request httpline) bool {
+	return *request.TLS && !useHTTP3(request) && !fanOut(request) && !dryRunFlag
+}
+
+// sameConn reports whether a connection established for a can be used
+// for b.
+func sameConn(a, b httpline) bool {
+	return a.Host == b.Host && a.Port == b.Port && *a.TLS == *b.TLS && useHTTP2(a) == useHTTP2(b) && a.H2C == b.H2C &&
+		a.MaxBPS == b.MaxBPS && a.proxyOptions == b.proxyOptions && slices.Equal(a.Addresses, b.Addresses) &&
+		slices.Equal(a.ALPN, b.ALPN) && a.tlsOptions.equal(b.tlsOptions) && a.sourceOptions == b.sourceOptions
+}
+
+// prepare starts establishing a connection for request in the
+// background, unless enough connections to its host are being
+// established or ready for the lines ahead.
+func (p *warmPool) prepare(request httpline) {
+	request.SetDefaults()
+	if !canWarm(request) {
+		return
+	}
+	req, err := toClientRequest(request)
+	if err != nil {
+		return
+	}
+	limit, ok := p.limits[strings.ToLower(request.Host)]
+	if !ok {
+		limit = p.limit
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h, ok := p.hosts[request.Host]
+	if !ok {
+		h = &warmHost{}
+		p.hosts[request.Host] = h
+	}
+	h.wanted++
+	if p.isClosed || len(h.conns) >= min(limit, h.wanted) {
+		return
+	}
+	c := &warmConn{line: request, done: make(chan struct{})}
+	h.conns = append(h.conns, c)
+	go func() {
+		defer close(c.done)
+		ctx := context.WithValue(p.ctx, linenoKey{}, request.N)
+		resolved.apply(ctx, &req)
+		conn, err := requester.Warm(ctx, req)
+		if err != nil {
+			logf(1, request, "could not warm up connection: %v", err)
+			return
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.isClosed {
+			conn.Close()
+			return
+		}
+		c.conn = conn
+	}()
+}
+
+// take returns a connection for request, waiting for it to be
+// established if necessary, or nil if there is none.
+func (p *warmPool) take(ctx context.Context, request httpline) *client.WarmConn {
+	if p == nil {
+		return nil
+	}
+	request.SetDefaults()
+	if !canWarm(request) {
+		return nil
+	}
+	p.mu.Lock()
+	h, ok := p.hosts[request.Host]
+	if !ok {
+		p.mu.Unlock()
+		return nil
+	}
+	h.wanted = max(h.wanted-1, 0)
+	i := slices.IndexFunc(h.conns, func(c *warmConn) bool { return sameConn(c.line, request) })
+	if i < 0 {
+		p.mu.Unlock()
+		return nil
+	}
+	c := h.conns[i]
+	h.conns = slices.Delete(h.conns, i, i+1)
+	p.mu.Unlock()
+	select {
+	case <-c.done:
+		return c.conn
+	case <-ctx.Done():
+		go func() {
+			<-c.done
+			if c.conn != nil {
+				c.conn.Close()
+			}
+		}()
+		return nil
+	}
+}
+
+// close closes the connections, that have not been used, aborting
+// those that are still being established.
+func (p *warmPool) close() {
+	if p == nil {
+		return
+	}
+	p.cancel()
+	p.mu.Lock()
+	p.isClosed = true
+	var conns []*warmConn
+	for _, h := range p.hosts {
+		conns = append(conns, h.conns...)
+	}
+	p.mu.Unlock()
+	for _, c := range conns {
+		<-c.done
+		if c.conn != nil {
+			c.conn.Close()
+		}
+	}
+}
+
+// warmUp forwards the lines from in to out, establishing connections
+// for them in the background. The lines buffered in out are those, for
+// which connections are established ahead of the requests.
+func warmUp(ctx context.Context, in, out chan httpline) {
+	defer close(out)
+	for line := range in {
+		if !isStopped() {
+			warmConns.prepare(line)
+		}
+		if !send(ctx, out, line) {
+			return
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/base64"
+	"unicode/utf8"
+
+	"github.com/codesoap/preq/client"
+)
+
+var opcodeNames = map[byte]string{
+	0x0: "continuation",
+	0x1: "text",
+	0x2: "binary",
+	0x8: "close",
+	0x9: "ping",
+	0xa: "pong",
+}
+
+// wsFrame is a WebSocket frame in the "frames" field. Payloads, that
+// are not valid UTF-8, are stored base64 encoded in Payload64.
+type wsFrame struct {
+	Opcode    string `json:"opcode"`
+	Fin       bool   `json:"fin"`
+	Payload   string `json:"payload,omitempty"`
+	Payload64 string `json:"payload64,omitempty"`
+}
+
+// webSocketCapture returns the WebSocket capture options of the flags
+// or nil, if no frames shall be read.
+func webSocketCapture() *client.WebSocketCapture {
+	if wsFramesFlag <= 0 && wsTimeFlag <= 0 {
+		return nil
+	}
+	return &client.WebSocketCapture{MaxFrames: wsFramesFlag, MaxTime: wsTimeFlag}
+}
+
+func toWSFrames(frames []client.WebSocketFrame) []wsFrame {
+	var converted []wsFrame
+	for _, frame := range frames {
+		f := wsFrame{Opcode: opcodeNames[frame.Opcode], Fin: frame.Fin}
+		if f.Opcode == "" {
+			f.Opcode = "reserved"
+		}
+		if frame.Opcode != 0x2 && utf8.Valid(frame.Payload) {
+			f.Payload = string(frame.Payload)
+		} else {
+			f.Payload64 = base64.StdEncoding.EncodeToString(frame.Payload)
+		}
+		converted = append(converted, f)
+	}
+	return converted
+}